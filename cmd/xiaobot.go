@@ -1,10 +1,12 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"ninego/log"
@@ -46,6 +48,11 @@ func main() {
 		}
 	}()
 
+	// xiaobot test-scripts [-text] [a.test.js ...] 运行脚本测试，结果(JSON)输出到标准输出，有失败时退出码为1
+	if len(os.Args) > 1 && os.Args[1] == "test-scripts" {
+		os.Exit(testScripts(os.Args[2:]))
+	}
+
 	display_banner()
 
 	// 设置东八区时区
@@ -152,6 +159,11 @@ func main() {
 	jsengine.Schedules.Start()
 	defer jsengine.Schedules.Stop()
 
+	//加载事件脚本
+	go jsengine.Hooks.Emit(jsengine.EventStartup, nil)
+	//加载Web应用脚本(启动应用中的定时器等)
+	go jsengine.Apps.Reload()
+
 	if *trigger {
 		webui.RunChat(bot)
 		if err = webui.Run(0); err != nil {
@@ -164,3 +176,39 @@ func main() {
 		}
 	}
 }
+
+// 运行程序目录下(或指定)的 *.test.js，返回退出码
+func testScripts(args []string) int {
+	fs := flag.NewFlagSet("test-scripts", flag.ExitOnError)
+	text := fs.Bool("text", false, "输出便于阅读的文字结果(默认输出JSON)")
+	fs.Parse(args)
+
+	report := jsengine.RunScriptTests(fs.Args()...)
+	if *text {
+		for _, file := range report.Files {
+			fmt.Printf("%s  通过%d 失败%d 跳过%d (%dms)\n", file.File, file.Passed, file.Failed, file.Skipped, file.Duration)
+			if file.Error != "" {
+				fmt.Println("  ✖ 无法运行:", file.Error)
+			}
+			for _, t := range file.Tests {
+				switch {
+				case t.Skipped:
+					fmt.Println("  - " + t.Name)
+				case t.Passed:
+					fmt.Println("  ✔ " + t.Name)
+				default:
+					fmt.Println("  ✖ "+t.Name+"\n    ", strings.ReplaceAll(t.Error, "\n", "\n    "))
+				}
+			}
+		}
+		fmt.Printf("共%d个文件：通过%d 失败%d 跳过%d\n", len(report.Files), report.Passed, report.Failed, report.Skipped)
+	} else {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	}
+	if !report.OK {
+		return 1
+	}
+	return 0
+}
@@ -7,6 +7,10 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"xiaobot/gcron"
+	"xiaobot/jarvis"
+	"xiaobot/jsengine"
+	"xiaobot/knowledge"
 
 	"github.com/BurntSushi/toml"
 )
@@ -46,6 +50,43 @@ type Config struct {
 
 	MusicPath string `json:"music_path" toml:"music_path"`
 
+	//家庭所在经纬度(用于日出日落定时任务，默认北京)
+	Latitude  float64 `json:"latitude,omitempty" toml:"latitude,omitempty"`
+	Longitude float64 `json:"longitude,omitempty" toml:"longitude,omitempty"`
+
+	//家庭所在城市(提示词模板中的 {{city}}，默认北京)
+	City string `json:"city,omitempty" toml:"city,omitempty"`
+
+	//缺少某年节假日数据时自动从网络下载
+	HolidayOnline bool `json:"holiday_online" toml:"holiday_online"`
+
+	//脚本最长执行时间(秒)，如 {"query":10,"task":60,"schedule":300,"adapter":5,"hook":10}，0=不限制
+	ScriptTimeout map[string]int `json:"script_timeout,omitempty" toml:"script_timeout,omitempty"`
+
+	//网页保存脚本时保留的历史版本，如 {"keep":50,"days":90}：每个脚本最多保留的个数(默认50)和天数(默认不限)，0=不限制
+	ScriptHistory map[string]int `json:"script_history,omitempty" toml:"script_history,omitempty"`
+
+	//Go技能开关，如 {"time":true,"volume":true,"reminder":true,"music":true}，内置技能默认不启用
+	Skills map[string]bool `json:"skills,omitempty" toml:"skills,omitempty"`
+
+	//提问的路由规则(按顺序第一个匹配的规则决定由小爱、AI、脚本还是技能回答)，见 router.go
+	Routes []RouteRule `json:"routes,omitempty" toml:"routes,omitempty"`
+	//路由规则 llm 动作使用的AI配置，如 {"coder": {"bot": "deepseek-coder", "prompt": "你是程序员"}}，未设置的项同默认配置
+	LLMProfiles map[string]*LLMProfile `json:"llm_profiles,omitempty" toml:"llm_profiles,omitempty"`
+	//小爱表示不知道的说法(替换内置的列表)，小爱这样回答时改由AI回答
+	UnknownAnswers []string `json:"unknown_answers,omitempty" toml:"unknown_answers,omitempty"`
+
+	//长期记忆：问AI时附加最相关的记忆条数(默认10，-1为不附加)；memory_auto 为 true 时用AI从对话中总结需要记住的事实，见 memory.go
+	MemoryInject int  `json:"memory_inject,omitempty" toml:"memory_inject,omitempty"`
+	MemoryAuto   bool `json:"memory_auto,omitempty" toml:"memory_auto,omitempty"`
+
+	//本地知识库的资料目录(.txt、.md、.html)，问AI时附加最相关的 knowledge_top 段(默认3，-1为不附加)，见 knowledge.go
+	KnowledgePath string `json:"knowledge_path,omitempty" toml:"knowledge_path,omitempty"`
+	KnowledgeTop  int    `json:"knowledge_top,omitempty" toml:"knowledge_top,omitempty"`
+
+	//人设库(语音、网页或脚本切换，当前人设按音箱保存)，见 persona.go
+	Personas []*Persona `json:"personas,omitempty" toml:"personas,omitempty"`
+
 	QueryJS string            `json:"-" toml:"-"`
 	TaskJS  map[string]string `json:"-" toml:"-"`
 }
@@ -79,6 +120,22 @@ func (c *Config) PostInit() error {
 	if v := os.Getenv("MUSIC_PATH"); v != "" {
 		c.MusicPath = v
 	}
+	gcron.SetHomeLocation(c.Latitude, c.Longitude)
+	gcron.NetworkHolidays.Online = c.HolidayOnline
+	if c.City != "" {
+		jsengine.SetPromptVar("city", c.City)
+	} else {
+		jsengine.SetPromptVar("city", "北京")
+	}
+	jsengine.SetTimeouts(c.ScriptTimeout)
+	jsengine.SetVersionRetention(c.ScriptHistory)
+	memoryInject = c.MemoryInject
+	knowledgeTop = c.KnowledgeTop
+	personas = c.Personas
+	knowledge.Default.SetDir(c.KnowledgePath)
+	if len(c.UnknownAnswers) > 0 {
+		jarvis.UnknownPhrases = c.UnknownAnswers
+	}
 	if c.TokenPath == "" {
 		c.TokenPath = filepath.Join(os.Getenv("HOME"), ".mi.token")
 	}
@@ -178,6 +235,12 @@ func NewConfigFromOptions(options map[string]interface{}) (*Config, error) {
 			//config.TokenPath = value.(string)
 		case "music_path":
 			config.MusicPath = value.(string)
+		case "latitude":
+			config.Latitude = value.(float64)
+		case "longitude":
+			config.Longitude = value.(float64)
+		case "holiday_online":
+			config.HolidayOnline = value.(bool)
 		}
 	}
 
@@ -0,0 +1,531 @@
+package xiaobot
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"ninego/log"
+	"xiaobot/jsengine"
+)
+
+/*
+多轮对话
+	技能(ctx.StartDialog)或脚本(bot.dialog)开始对话后，接下来的提问先交给对话处理(在事件脚本、query.bot和技能之前)：
+	逐个追问缺少的槽位(“几点？”)，需要确认时问“确定吗？”，回答“确认/好的”后执行，“取消/算了”结束对话。
+	每次追问后唤醒音箱(不用再说唤醒词)，小爱自己对回答的答复被静音；超过等待时间没有回答、或追问次数用完时结束对话。
+	同一时间只有一个对话，开始新的对话会结束正在进行的对话。
+*/
+
+const (
+	dialogMaxTurns = 5                //默认最多追问的次数
+	dialogTimeout  = 30 * time.Second //默认等待回答的时间
+	dialogConfirm  = "\x00confirm"    //正在等待确认
+)
+
+var (
+	dialogCancelWords  = []string{"取消", "算了", "不用了", "不要了", "退出", "结束"}
+	dialogDenyWords    = []string{"不", "别", "否", "取消", "算了"}
+	dialogConfirmWords = []string{"确认", "确定", "是", "好", "对", "可以", "执行", "没问题", "行", "嗯", "要"}
+)
+
+// Slot 对话中要填的槽位
+type Slot struct {
+	Name   string
+	Prompt string                                 //缺少时的追问，可用 {槽位名} 引用已填的槽位
+	Parse  func(query string) (interface{}, bool) //从回答中取值，为nil时取整句回答(不从开始对话的提问中取值)
+}
+
+// DialogSpec 多轮对话的定义
+type DialogSpec struct {
+	Name        string
+	Slots       []Slot
+	Confirm     string        //槽位填完后的确认提问，如 "确定设置{time}的闹钟吗？"，为空时不确认
+	Risky       bool          //有风险的操作(执行命令、控制设备)：必须口头确认，Confirm为空时用默认的提问
+	MaxTurns    int           //最多追问的次数(含没听懂时的重复追问)，默认5
+	Timeout     time.Duration //每次等待回答的时间，默认30秒
+	CancelText  string        //取消时的回答，默认“好的，已取消”
+	TimeoutText string        //超时结束时播放的文字，默认不播放
+
+	Done   func(ctx *SkillContext, slots map[string]interface{}) error //槽位填完(并确认)后执行
+	Cancel func(ctx *SkillContext)                                     //取消时执行，可为nil
+}
+
+type dialog struct {
+	spec      *DialogSpec
+	skill     string
+	bot       *MiBot
+	slots     map[string]interface{}
+	turns     int
+	asking    string //正在追问的槽位名，等待确认时为dialogConfirm
+	confirmed bool
+	started   time.Time
+	timer     *time.Timer
+}
+
+type dialogManager struct {
+	mu     sync.Mutex
+	active *dialog
+}
+
+// Dialogs 正在进行的多轮对话
+var Dialogs = &dialogManager{}
+
+// DialogInfo 正在进行的对话
+type DialogInfo struct {
+	Name    string                 `json:"name"`
+	Skill   string                 `json:"skill"`
+	Asking  string                 `json:"asking"` //正在追问的槽位，等待确认时为 confirm
+	Slots   map[string]interface{} `json:"slots"`
+	Turns   int                    `json:"turns"`
+	Started time.Time              `json:"started"`
+}
+
+// Active 正在进行的对话，没有时返回nil
+func (m *dialogManager) Active() *DialogInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.active
+	if d == nil {
+		return nil
+	}
+	asking := d.asking
+	if asking == dialogConfirm {
+		asking = "confirm"
+	}
+	return &DialogInfo{Name: d.spec.Name, Skill: d.skill, Asking: asking, Slots: scriptSlots(d.slots), Turns: d.turns, Started: d.started}
+}
+
+// Cancel 结束正在进行的对话(不播放)
+func (m *dialogManager) Cancel() {
+	if d := m.take(); d != nil {
+		d.end("cancel")
+	}
+}
+
+// 取出正在进行的对话(停止等待回答的计时)
+func (m *dialogManager) take() *dialog {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.active
+	if d != nil && d.timer != nil {
+		d.timer.Stop()
+	}
+	m.active = nil
+	return d
+}
+
+// 等待对话的回答，超时后结束对话
+func (m *dialogManager) wait(d *dialog) {
+	if old := m.take(); old != nil && old != d {
+		log.Printf("多轮对话 %s 被 %s 结束\n", old.spec.Name, d.spec.Name)
+		old.end("cancel")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.active = d
+	d.timer = time.AfterFunc(d.timeout(), func() {
+		m.mu.Lock()
+		if m.active != d {
+			m.mu.Unlock()
+			return
+		}
+		m.active = nil
+		m.mu.Unlock()
+		log.Printf("多轮对话 %s 等待回答超时\n", d.spec.Name)
+		d.end("timeout")
+		if d.spec.TimeoutText != "" && d.bot != nil {
+			if err := d.bot.Box.MiTTS(d.spec.TimeoutText); err != nil {
+				log.Error("播放对话超时提示出错:", err)
+			}
+		}
+	})
+}
+
+func (d *dialog) timeout() time.Duration {
+	if d.spec.Timeout > 0 {
+		return d.spec.Timeout
+	}
+	return dialogTimeout
+}
+
+func (d *dialog) maxTurns() int {
+	if d.spec.MaxTurns > 0 {
+		return d.spec.MaxTurns
+	}
+	return dialogMaxTurns
+}
+
+// 对话结束时通知事件脚本
+func (d *dialog) end(status string) {
+	log.Printf("多轮对话 %s 结束: %s\n", d.spec.Name, status)
+	go jsengine.Hooks.Emit(jsengine.EventDialog, map[string]interface{}{
+		"name":   d.spec.Name,
+		"slots":  scriptSlots(d.slots),
+		"status": status,
+	})
+}
+
+func (d *dialog) slot(name string) *Slot {
+	for i := range d.spec.Slots {
+		if d.spec.Slots[i].Name == name {
+			return &d.spec.Slots[i]
+		}
+	}
+	return nil
+}
+
+// 第一个缺少的槽位
+func (d *dialog) missing() *Slot {
+	for i := range d.spec.Slots {
+		if _, ok := d.slots[d.spec.Slots[i].Name]; !ok {
+			return &d.spec.Slots[i]
+		}
+	}
+	return nil
+}
+
+// fill 从回答中取槽位的值：asked为正在追问的槽位(nil时只取有Parse的槽位)，返回asked是否取到值
+func (d *dialog) fill(query string, asked *Slot) bool {
+	ok := false
+	if asked != nil {
+		if asked.Parse == nil {
+			if text := strings.Trim(query, " ,，。.!！?？"); text != "" {
+				d.slots[asked.Name], ok = text, true
+			}
+		} else if v, found := asked.Parse(query); found {
+			d.slots[asked.Name], ok = v, true
+		}
+	}
+	// 一句回答中可能还有其他槽位的值，如 “明天七点，叫我起床”
+	for i := range d.spec.Slots {
+		s := &d.spec.Slots[i]
+		if _, done := d.slots[s.Name]; done || s.Parse == nil {
+			continue
+		}
+		if v, found := s.Parse(query); found {
+			d.slots[s.Name] = v
+		}
+	}
+	return ok
+}
+
+var slotRef = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// render 把提问中的 {槽位名} 替换为槽位的值
+func (d *dialog) render(text string) string {
+	return slotRef.ReplaceAllStringFunc(text, func(ref string) string {
+		v, ok := d.slots[ref[1:len(ref)-1]]
+		if !ok {
+			return ref
+		}
+		return slotText(v)
+	})
+}
+
+func (d *dialog) confirmPrompt() string {
+	if d.spec.Confirm != "" {
+		return d.spec.Confirm
+	}
+	return "确定要执行吗？"
+}
+
+// 槽位值的说法
+func slotText(v interface{}) string {
+	if t, ok := v.(time.Time); ok {
+		return spokenReminderTime(t, time.Now())
+	}
+	return fmt.Sprint(v)
+}
+
+// 传给脚本的槽位值(时间为 2006-01-02 15:04:05)
+func scriptSlots(slots map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(slots))
+	for k, v := range slots {
+		if t, ok := v.(time.Time); ok {
+			v = t.Format(time.DateTime)
+		}
+		result[k] = v
+	}
+	return result
+}
+
+// NumberSlot 数字槽位(阿拉伯数字或中文数字)，值为int
+func NumberSlot(query string) (interface{}, bool) {
+	return firstNumber(query)
+}
+
+// TimeSlot 时间槽位(十分钟后、明天早上七点)，值为time.Time
+func TimeSlot(query string) (interface{}, bool) {
+	t := parseReminderTime(query, time.Now())
+	return t, !t.IsZero()
+}
+
+// OptionSlot 选项槽位：回答中包含的第一个选项
+func OptionSlot(options ...string) func(query string) (interface{}, bool) {
+	return func(query string) (interface{}, bool) {
+		for _, o := range options {
+			if o != "" && strings.Contains(query, o) {
+				return o, true
+			}
+		}
+		return nil, false
+	}
+}
+
+// PatternSlot 正则表达式槽位：值为第一个分组(没有分组时为匹配的文字)
+func PatternSlot(pattern string) (func(query string) (interface{}, bool), error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return func(query string) (interface{}, bool) {
+		m := re.FindStringSubmatch(query)
+		if m == nil {
+			return nil, false
+		}
+		if len(m) > 1 {
+			return m[1], true
+		}
+		return m[0], true
+	}, nil
+}
+
+func isConfirm(query string) bool {
+	return !containsAny(query, dialogDenyWords...) && containsAny(query, dialogConfirmWords...)
+}
+
+// StartDialog 开始多轮对话：槽位的值先从slots和本次提问中取，再逐个追问缺少的槽位
+func (c *SkillContext) StartDialog(spec *DialogSpec, slots map[string]interface{}) error {
+	if spec.Done == nil {
+		return errors.New("对话没有Done")
+	}
+	d := &dialog{spec: spec, skill: c.skill, bot: c.Bot, slots: map[string]interface{}{}, started: time.Now()}
+	for k, v := range slots {
+		d.slots[k] = v
+	}
+	d.fill(c.Query, nil)
+	log.Printf("开始多轮对话 %s\n", spec.Name)
+	return c.talk.advanceDialog(c, d)
+}
+
+// ask 播放追问，播放完后唤醒音箱等待回答
+func (c *SkillContext) ask(text string) error {
+	c.replies = append(c.replies, text)
+	c.asked = true
+	log.Printf("-对话%s-追问: %s\n", c.skill, text)
+	if err := c.talk.miTTS(text, true); err != nil {
+		return err
+	}
+	if c.talk.ChatDontTTS == 0 && !c.talk.terminated() {
+		c.Bot.Box.WakeUp()
+	}
+	return nil
+}
+
+// advanceDialog 追问缺少的槽位或确认，都完成后执行对话的Done
+func (mt *MiTalk) advanceDialog(ctx *SkillContext, d *dialog) error {
+	if slot := d.missing(); slot != nil {
+		d.asking = slot.Name
+		Dialogs.wait(d)
+		return ctx.ask(d.render(slot.Prompt))
+	}
+	if (d.spec.Confirm != "" || d.spec.Risky) && !d.confirmed {
+		d.asking = dialogConfirm
+		Dialogs.wait(d)
+		return ctx.ask(d.render(d.confirmPrompt()))
+	}
+	d.end("done")
+	return d.spec.Done(ctx, d.slots)
+}
+
+// dialogTurn 处理对话中的一次回答
+func (mt *MiTalk) dialogTurn(ctx *SkillContext, d *dialog) error {
+	query := ctx.Query
+	if queryIn(query, dialogCancelWords) || (d.asking == dialogConfirm && containsAny(query, dialogDenyWords...)) {
+		d.end("cancel")
+		if d.spec.Cancel != nil {
+			d.spec.Cancel(ctx)
+		}
+		if d.spec.CancelText != "" {
+			return ctx.Say(d.spec.CancelText)
+		}
+		return ctx.Say("好的，已取消")
+	}
+
+	d.turns++
+	understood := false
+	if d.asking == dialogConfirm {
+		understood = isConfirm(query)
+		d.confirmed = understood
+	} else {
+		understood = d.fill(query, d.slot(d.asking))
+	}
+	if understood {
+		return mt.advanceDialog(ctx, d)
+	}
+	if d.turns >= d.maxTurns() {
+		d.end("failed")
+		return ctx.Say("没听明白，先不处理了")
+	}
+	Dialogs.wait(d)
+	if d.asking == dialogConfirm {
+		return ctx.ask("请说确认或者取消")
+	}
+	return ctx.ask("没听明白，" + d.render(d.slot(d.asking).Prompt))
+}
+
+// handleDialog 有进行中的多轮对话时由对话处理本次提问，返回是否已处理
+func (mt *MiTalk) handleDialog(query string) bool {
+	d := Dialogs.take()
+	if d == nil {
+		return false
+	}
+	log.Printf("多轮对话 %s 的回答: %s\n", d.spec.Name, query)
+	ctx := &SkillContext{Query: query, Score: 1, Config: mt.config, Bot: mt.Bot, talk: mt, skill: d.skill}
+	mt.Bot.startSpeakerMuteLoop()
+	// 同技能一样在后台处理，新的唤醒或提问可打断追问的播放
+	go func() {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Error("多轮对话", d.spec.Name, "处理出错:", r)
+				}
+			}()
+			if err := mt.dialogTurn(ctx, d); err != nil {
+				log.Error("多轮对话", d.spec.Name, "处理出错:", err)
+			}
+		}()
+		mt.finishSkill(ctx)
+	}()
+	return true
+}
+
+// startScriptDialog 脚本开始多轮对话 bot.dialog({...})，在后台追问(脚本不等待对话结束)
+//
+//	name、slots:[{name, prompt, type:'number'|'time'|'text', options:[...], pattern:'正则'}]、confirm、turns、timeout(秒)、
+//	cancelText、timeoutText、values(已知的槽位)、query(从中取槽位的值)、script(完成后执行的任务脚本，槽位为请求参数)
+func (mt *MiBot) startScriptDialog(options map[string]interface{}) error {
+	str := func(key string) string {
+		s, _ := options[key].(string)
+		return s
+	}
+	num := func(key string) float64 {
+		switch v := options[key].(type) {
+		case int64:
+			return float64(v)
+		case float64:
+			return v
+		}
+		return 0
+	}
+	spec := &DialogSpec{
+		Name:        str("name"),
+		Confirm:     str("confirm"),
+		MaxTurns:    int(num("turns")),
+		Timeout:     time.Duration(num("timeout") * float64(time.Second)),
+		CancelText:  str("cancelText"),
+		TimeoutText: str("timeoutText"),
+	}
+	if spec.Name == "" {
+		spec.Name = "script"
+	}
+	list, _ := options["slots"].([]interface{})
+	for _, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return errors.New("slots 的每一项应为对象")
+		}
+		name, _ := m["name"].(string)
+		prompt, _ := m["prompt"].(string)
+		if name == "" || prompt == "" {
+			return errors.New("槽位须有 name 和 prompt")
+		}
+		slot := Slot{Name: name, Prompt: prompt}
+		kind, _ := m["type"].(string)
+		pattern, _ := m["pattern"].(string)
+		choices, _ := m["options"].([]interface{})
+		switch {
+		case pattern != "":
+			parse, err := PatternSlot(pattern)
+			if err != nil {
+				return err
+			}
+			slot.Parse = parse
+		case len(choices) > 0:
+			words := make([]string, 0, len(choices))
+			for _, c := range choices {
+				words = append(words, fmt.Sprint(c))
+			}
+			slot.Parse = OptionSlot(words...)
+		case kind == "number":
+			slot.Parse = NumberSlot
+		case kind == "time":
+			slot.Parse = TimeSlot
+		}
+		spec.Slots = append(spec.Slots, slot)
+	}
+	if script := str("script"); script != "" {
+		source, ok := mt.config.TaskJS[script]
+		if !ok {
+			return fmt.Errorf("没有任务脚本: %s", script)
+		}
+		if risk := jsengine.ScriptRisk(source, filepath.Join(GetExecutableDir(), script+".bot")); risk != "" {
+			spec.Risky = true
+			if spec.Confirm == "" {
+				spec.Confirm = fmt.Sprintf("%s会%s，确定要执行吗？", script, risk)
+			}
+		}
+		spec.Done = func(ctx *SkillContext, slots map[string]interface{}) error {
+			return runTaskScript(ctx, script, scriptSlots(slots))
+		}
+	} else {
+		spec.Done = func(ctx *SkillContext, slots map[string]interface{}) error { return nil } //由事件脚本的 dialog 事件处理
+	}
+	values, _ := options["values"].(map[string]interface{})
+	query := str("query")
+
+	talk := mt.Talk
+	mt.startSpeakerMuteLoop()
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Error("多轮对话", spec.Name, "处理出错:", r)
+			}
+		}()
+		ctx := &SkillContext{Query: query, Score: 1, Config: mt.config, Bot: mt, talk: talk, skill: "script"}
+		if err := ctx.StartDialog(spec, values); err != nil {
+			log.Error("多轮对话", spec.Name, "处理出错:", err)
+		}
+	}()
+	return nil
+}
+
+// runTaskScript 执行任务脚本(params为请求参数)，脚本用 res.send() 返回的文字被播放
+func runTaskScript(ctx *SkillContext, name string, params map[string]interface{}) error {
+	script, ok := ctx.Config.TaskJS[name]
+	if !ok {
+		return fmt.Errorf("没有任务脚本: %s", name)
+	}
+	values := url.Values{}
+	for k, v := range params {
+		values.Set(k, fmt.Sprint(v))
+	}
+	req := httptest.NewRequest(http.MethodGet, "/task/"+url.PathEscape(name)+"?"+values.Encode(), nil)
+	req.SetPathValue("action", name)
+	rec := httptest.NewRecorder()
+	if err := jsengine.Do_Action(rec, req, &script); err != nil {
+		return err
+	}
+	if rec.Code == http.StatusOK && strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		return ctx.Say(rec.Body.String())
+	}
+	return nil
+}
@@ -262,13 +262,9 @@ https://raw.githubusercontent.com/NateScarlet/holiday-cn/master/{year}.json
 */
 
 import (
-	"encoding/json"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"net/http"
-
-	"os"
+	"ninego/log"
+	"sort"
 	"sync"
 	"time"
 )
@@ -289,81 +285,79 @@ type HolidayData struct {
 
 // holidayCache 缓存已加载的年份节假日数据，key为年份(int)
 var holidayCache = make(map[int]map[string]Holiday)
-var cacheMutex sync.RWMutex // 读写锁，保证并发安全
+var holidayCacheExpire = make(map[int]time.Time) // 临时缓存的过期时间(网络数据正在下载或下载失败时)
+var cacheMutex sync.RWMutex                      // 读写锁，保证并发安全
 
-// getHolidayData 获取指定年份的节假日数据（优先从缓存，无则下载）
+// ResetHolidayCache 清空缓存(数据来源变化后调用)
+func ResetHolidayCache() {
+	cacheMutex.Lock()
+	holidayCache = make(map[int]map[string]Holiday)
+	holidayCacheExpire = make(map[int]time.Time)
+	cacheMutex.Unlock()
+}
+
+// getHolidayData 获取指定年份的节假日数据（优先从缓存，无则合并各数据来源）
 func getHolidayData(year int) (map[string]Holiday, error) {
 	// 先尝试从缓存读取（读锁）
 	cacheMutex.RLock()
 	data, ok := holidayCache[year]
+	if expire, temp := holidayCacheExpire[year]; temp && time.Now().After(expire) {
+		ok = false
+	}
 	cacheMutex.RUnlock()
 	if ok {
-		return data, nil
-	}
-
-	// 缓存未命中
-	var holidayData HolidayData
-	filepath := fmt.Sprintf("%d.json", year)
-
-	// 读取文件内容
-	readFromfile := func(filepath string) error {
-		file, err := os.Open(filepath)
-		if err != nil {
-			return err
-		}
-		defer file.Close()
-
-		content, err := ioutil.ReadAll(file)
-		if err != nil {
-			return err
+		if len(data) == 0 {
+			return nil, fmt.Errorf("无%d年节假日数据", year)
 		}
-
-		// 解析JSON
-		if err := json.Unmarshal([]byte(content), &holidayData); err != nil {
-			return err
-		}
-		return nil
+		return data, nil
 	}
-	if !IsExist(filepath) || readFromfile(filepath) != nil {
-		// 读本地文件失败，下载数据
-		url := fmt.Sprintf("https://raw.githubusercontent.com/NateScarlet/holiday-cn/master/%d.json", year)
-		resp, err := http.Get(url)
-		if err != nil {
-			return nil, fmt.Errorf("下载%d年节假日数据失败: %w", year, err)
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			return nil, fmt.Errorf("下载%d年节假日数据失败，状态码: %d", year, resp.StatusCode)
-		}
 
-		//完整读取Body到内存
-		bodyBytes, err := io.ReadAll(resp.Body)
+	// 缓存未命中，按优先级从低到高合并（key为"2026-01-01"格式的日期字符串）
+	dateMap := make(map[string]Holiday)
+	fetchFailed := false
+	for _, provider := range HolidayProviders() {
+		days, err := provider.Holidays(year)
 		if err != nil {
-			return nil, fmt.Errorf("下载%d年节假日数据失败: %w", year, err)
+			log.Debug("节假日数据", provider.Name(), err)
+			if _, ok := err.(holidayFetchError); ok {
+				fetchFailed = true
+			}
+			continue
 		}
-		// 解析JSON数据
-		if err := json.Unmarshal(bodyBytes, &holidayData); err != nil {
-			return nil, fmt.Errorf("解析%d年节假日数据失败: %w", year, err)
+		for _, day := range days {
+			dateMap[day.Date] = day
 		}
-		//将原始字节写入文件
-		os.WriteFile(filepath, bodyBytes, 0644)
 	}
 
-	// 构建日期映射（key为"2026-01-01"格式的日期字符串）
-	dateMap := make(map[string]Holiday, len(holidayData.Days))
-	for _, day := range holidayData.Days {
-		dateMap[day.Date] = day
-	}
-
-	// 写入缓存（写锁）
+	// 写入缓存（写锁），无数据也缓存，避免每次都重新查找；
+	// 网络数据正在下载或下载失败时只临时缓存，下载完成后清空缓存，过期后重试下载
 	cacheMutex.Lock()
 	holidayCache[year] = dateMap
+	if fetchFailed {
+		holidayCacheExpire[year] = time.Now().Add(holidayRetryInterval)
+	} else {
+		delete(holidayCacheExpire, year)
+	}
 	cacheMutex.Unlock()
 
+	if len(dateMap) == 0 {
+		log.Printf("无%d年节假日数据，按周末规则判断节假日\n", year)
+		return nil, fmt.Errorf("无%d年节假日数据", year)
+	}
 	return dateMap, nil
 }
 
+// HolidayList 返回指定年份的节假日/调休日期列表（按日期排序）
+func HolidayList(year int) []Holiday {
+	data, _ := getHolidayData(year)
+	list := make([]Holiday, 0, len(data))
+	for _, day := range data {
+		list = append(list, day)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Date < list[j].Date })
+	return list
+}
+
 // IsHoliday 判断指定日期是否为节假日/休息日
 // 返回true表示是休息日（包括法定假日、调休后的休息日、周末）
 func IsHoliday(t time.Time) bool {
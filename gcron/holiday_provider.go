@@ -0,0 +1,345 @@
+package gcron
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ninego/log"
+)
+
+// HolidayProvider 节假日数据来源
+// Holidays 返回指定年份的节假日/调休日期，无该年数据时返回错误
+type HolidayProvider interface {
+	Name() string
+	Holidays(year int) ([]Holiday, error)
+}
+
+// 数据来源按优先级从低到高排列，高优先级的同一日期覆盖低优先级
+var (
+	holidayProviders = []HolidayProvider{
+		EmbeddedHolidays,
+		LocalHolidays,
+		NetworkHolidays,
+		OverrideHolidays,
+	}
+	providersMutex sync.RWMutex
+)
+
+// RegisterHolidayProvider 注册自定义节假日来源(优先级仅低于用户自定义日期)
+func RegisterHolidayProvider(p HolidayProvider) {
+	providersMutex.Lock()
+	n := len(holidayProviders)
+	holidayProviders = append(holidayProviders[:n-1], p, holidayProviders[n-1])
+	providersMutex.Unlock()
+	ResetHolidayCache()
+}
+
+// HolidayProviders 返回当前的节假日来源列表
+func HolidayProviders() []HolidayProvider {
+	providersMutex.RLock()
+	defer providersMutex.RUnlock()
+	return append([]HolidayProvider(nil), holidayProviders...)
+}
+
+// ------------------------------
+// 内置数据(随程序发布，离线可用)
+// ------------------------------
+
+//go:embed holidays/*.json
+var embeddedHolidayFS embed.FS
+
+var EmbeddedHolidays HolidayProvider = embeddedProvider{}
+
+type embeddedProvider struct{}
+
+func (embeddedProvider) Name() string { return "内置数据" }
+
+func (embeddedProvider) Holidays(year int) ([]Holiday, error) {
+	content, err := embeddedHolidayFS.ReadFile(fmt.Sprintf("holidays/%d.json", year))
+	if err != nil {
+		return nil, fmt.Errorf("无%d年内置节假日数据", year)
+	}
+	return parseHolidayJSON(content)
+}
+
+// ------------------------------
+// 本地文件: {year}.json(holiday-cn格式) 和 holiday*.ics
+// ------------------------------
+
+var LocalHolidays = &LocalHolidayProvider{Dir: "."}
+
+type LocalHolidayProvider struct {
+	Dir string
+}
+
+func (p *LocalHolidayProvider) Name() string { return "本地文件" }
+
+func (p *LocalHolidayProvider) Holidays(year int) ([]Holiday, error) {
+	var days []Holiday
+	found := false
+	if content, err := os.ReadFile(filepath.Join(p.Dir, fmt.Sprintf("%d.json", year))); err == nil {
+		list, err := parseHolidayJSON(content)
+		if err != nil {
+			return nil, fmt.Errorf("解析%d年节假日数据失败: %w", year, err)
+		}
+		days = append(days, list...)
+		found = true
+	}
+
+	// ics日历: 全天事件为休息日，名称含“班”的为调休上班日
+	files, _ := filepath.Glob(filepath.Join(p.Dir, "holiday*.ics"))
+	prefix := fmt.Sprintf("%d-", year)
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			continue
+		}
+		events, err := parseICS(f)
+		f.Close()
+		if err != nil {
+			continue
+		}
+		for _, e := range events {
+			for d := e.Start; d.Before(e.End) || d.Equal(e.Start); d = d.AddDate(0, 0, 1) {
+				date := d.Format("2006-01-02")
+				if strings.HasPrefix(date, prefix) {
+					days = append(days, Holiday{Name: e.Summary, Date: date, IsOffDay: !strings.Contains(e.Summary, "班")})
+					found = true
+				}
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("无%d年本地节假日文件", year)
+	}
+	return days, nil
+}
+
+// ------------------------------
+// 网络数据(可选): https://github.com/NateScarlet/holiday-cn
+// ------------------------------
+
+var NetworkHolidays = &NetworkHolidayProvider{
+	URL: "https://raw.githubusercontent.com/NateScarlet/holiday-cn/master/%d.json",
+}
+
+// NetworkHolidayProvider 仅在Online开启时在后台下载缺少的年份(不阻塞查询)，
+// 下载结果保存为本地{year}.json，之后由本地文件提供
+type NetworkHolidayProvider struct {
+	URL    string
+	Online bool
+
+	mu          sync.Mutex
+	downloading map[int]bool      //正在下载的年份
+	failedAt    map[int]time.Time //下载失败的年份，holidayRetryInterval 内不再下载
+	notFoundAt  map[int]time.Time //网上还没有的年份，holidayNotFoundInterval 内不再下载
+}
+
+const (
+	holidayRetryInterval    = 10 * time.Minute //下载失败后多久重试
+	holidayNotFoundInterval = 24 * time.Hour   //网上还没有该年数据(404)时多久重试
+)
+
+// HolidaysChanged 节假日数据在后台下载完成后调用(重新计算任务的下次执行时间)
+var HolidaysChanged func()
+
+// 网上还没有该年的数据(尚未发布)
+var errHolidayNotFound = errors.New("网上还没有节假日数据")
+
+// 正在下载或下载失败(网络问题等)的错误，合并结果只临时缓存，之后重试
+type holidayFetchError struct{ error }
+
+func (p *NetworkHolidayProvider) Name() string { return "网络数据" }
+
+func (p *NetworkHolidayProvider) Holidays(year int) ([]Holiday, error) {
+	if !p.Online {
+		return nil, fmt.Errorf("未开启网络节假日数据")
+	}
+	if IsExist(filepath.Join(LocalHolidays.Dir, fmt.Sprintf("%d.json", year))) {
+		return nil, fmt.Errorf("已有%d年本地节假日文件", year)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.downloading[year] {
+		return nil, holidayFetchError{fmt.Errorf("正在下载%d年节假日数据", year)}
+	}
+	if at, ok := p.notFoundAt[year]; ok && time.Since(at) < holidayNotFoundInterval {
+		return nil, fmt.Errorf("%d年%w", year, errHolidayNotFound) //作为无数据缓存
+	}
+	if at, ok := p.failedAt[year]; ok && time.Since(at) < holidayRetryInterval {
+		return nil, holidayFetchError{fmt.Errorf("%d年节假日数据下载失败，稍后重试", year)}
+	}
+	if p.downloading == nil {
+		p.downloading = make(map[int]bool)
+		p.failedAt = make(map[int]time.Time)
+		p.notFoundAt = make(map[int]time.Time)
+	}
+	p.downloading[year] = true
+	go p.fetch(year)
+	return nil, holidayFetchError{fmt.Errorf("正在下载%d年节假日数据", year)}
+}
+
+// 后台下载，完成后清空缓存(下次查询时合并新数据)
+func (p *NetworkHolidayProvider) fetch(year int) {
+	_, err := p.Download(year)
+	p.mu.Lock()
+	delete(p.downloading, year)
+	delete(p.failedAt, year)
+	delete(p.notFoundAt, year)
+	switch {
+	case errors.Is(err, errHolidayNotFound):
+		p.notFoundAt[year] = time.Now()
+	case err != nil:
+		p.failedAt[year] = time.Now()
+	}
+	p.mu.Unlock()
+	if err != nil {
+		log.Println(err)
+		if !errors.Is(err, errHolidayNotFound) {
+			return //临时缓存过期后重试
+		}
+	} else {
+		log.Printf("已下载%d年节假日数据\n", year)
+	}
+	ResetHolidayCache()
+	if err == nil && HolidaysChanged != nil {
+		HolidaysChanged()
+	}
+}
+
+// Download 下载指定年份的数据并保存到本地
+func (p *NetworkHolidayProvider) Download(year int) ([]Holiday, error) {
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Get(fmt.Sprintf(p.URL, year))
+	if err != nil {
+		return nil, fmt.Errorf("下载%d年节假日数据失败: %w", year, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%d年%w", year, errHolidayNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("下载%d年节假日数据失败，状态码: %d", year, resp.StatusCode)
+	}
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("下载%d年节假日数据失败: %w", year, err)
+	}
+	days, err := parseHolidayJSON(bodyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("解析%d年节假日数据失败: %w", year, err)
+	}
+	//将原始字节写入文件
+	file := filepath.Join(LocalHolidays.Dir, fmt.Sprintf("%d.json", year))
+	if err := os.WriteFile(file, bodyBytes, 0644); err != nil {
+		log.Error("保存节假日数据失败:", err)
+	}
+	return days, nil
+}
+
+// RefreshHolidays 从网络重新下载指定年份的节假日数据(不受Online开关限制)
+func RefreshHolidays(year int) error {
+	if _, err := NetworkHolidays.Download(year); err != nil {
+		return err
+	}
+	ResetHolidayCache()
+	return nil
+}
+
+// ------------------------------
+// 用户自定义: 额外假日、调休上班、个人请假
+// ------------------------------
+
+const (
+	OverrideHoliday = "holiday" //额外假日
+	OverrideWorkday = "workday" //调休上班
+	OverrideLeave   = "leave"   //个人请假
+)
+
+// HolidayOverride 用户自定义日期
+type HolidayOverride struct {
+	Date string `json:"date"` //2026-01-01
+	Name string `json:"name"`
+	Kind string `json:"kind"` //holiday/workday/leave
+}
+
+var OverrideHolidays = &OverrideHolidayProvider{File: "holiday_overrides.json"}
+
+type OverrideHolidayProvider struct {
+	File string
+
+	mu   sync.RWMutex
+	list []HolidayOverride
+	load bool
+}
+
+func (p *OverrideHolidayProvider) Name() string { return "自定义" }
+
+func (p *OverrideHolidayProvider) Holidays(year int) ([]Holiday, error) {
+	prefix := fmt.Sprintf("%d-", year)
+	var days []Holiday
+	for _, o := range p.List() {
+		if strings.HasPrefix(o.Date, prefix) {
+			days = append(days, Holiday{Name: o.Name, Date: o.Date, IsOffDay: o.Kind != OverrideWorkday})
+		}
+	}
+	return days, nil
+}
+
+// List 返回全部自定义日期(按日期排序)
+func (p *OverrideHolidayProvider) List() []HolidayOverride {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.load {
+		p.load = true
+		if content, err := os.ReadFile(p.File); err == nil {
+			json.Unmarshal(content, &p.list)
+		}
+	}
+	return append([]HolidayOverride(nil), p.list...)
+}
+
+// Save 保存自定义日期并刷新缓存
+func (p *OverrideHolidayProvider) Save(list []HolidayOverride) error {
+	for _, o := range list {
+		if _, err := time.Parse("2006-01-02", o.Date); err != nil {
+			return fmt.Errorf("无效日期: %q", o.Date)
+		}
+		if o.Kind != OverrideHoliday && o.Kind != OverrideWorkday && o.Kind != OverrideLeave {
+			return fmt.Errorf("无效类型: %q", o.Kind)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Date < list[j].Date })
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(p.File, data, 0644); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.list, p.load = list, true
+	p.mu.Unlock()
+	ResetHolidayCache()
+	return nil
+}
+
+func parseHolidayJSON(content []byte) ([]Holiday, error) {
+	var holidayData HolidayData
+	if err := json.Unmarshal(content, &holidayData); err != nil {
+		return nil, err
+	}
+	return holidayData.Days, nil
+}
@@ -0,0 +1,448 @@
+package gcron
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// icsEvent iCalendar(.ics)中的一个VEVENT
+type icsEvent struct {
+	Summary string
+	Start   time.Time
+	End     time.Time
+	AllDay  bool              //DTSTART为VALUE=DATE
+	Props   map[string]string //其他属性(原始值)
+}
+
+// parseICS 解析iCalendar文本中的VEVENT(只处理常用属性)
+func parseICS(r io.Reader) ([]icsEvent, error) {
+	var events []icsEvent
+	var cur *icsEvent
+
+	lines, err := unfoldICS(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		name, params, value := splitICSLine(line)
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			cur = &icsEvent{Props: make(map[string]string)}
+		case name == "END" && value == "VEVENT":
+			if cur != nil && !cur.Start.IsZero() {
+				if cur.End.IsZero() {
+					cur.End = cur.Start
+					if cur.AllDay {
+						cur.End = cur.Start.AddDate(0, 0, 1)
+					}
+				}
+				events = append(events, *cur)
+			}
+			cur = nil
+		case cur == nil:
+		case name == "SUMMARY":
+			cur.Summary = unescapeICS(value)
+		case name == "DTSTART":
+			cur.Start, cur.AllDay = parseICSTime(params, value)
+		case name == "DTEND":
+			cur.End, _ = parseICSTime(params, value)
+		default:
+			if _, ok := cur.Props[name]; ok {
+				cur.Props[name] += "," + value
+			} else {
+				cur.Props[name] = value
+			}
+		}
+	}
+	return events, nil
+}
+
+// 展开折行(以空格或Tab开头的行是上一行的延续)
+func unfoldICS(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// 拆分 NAME;PARAM=xx:VALUE
+func splitICSLine(line string) (name string, params map[string]string, value string) {
+	idx := strings.Index(line, ":")
+	if idx < 0 {
+		return strings.ToUpper(line), nil, ""
+	}
+	head, value := line[:idx], line[idx+1:]
+	parts := strings.Split(head, ";")
+	name = strings.ToUpper(parts[0])
+	params = make(map[string]string)
+	for _, p := range parts[1:] {
+		if kv := strings.SplitN(p, "=", 2); len(kv) == 2 {
+			params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+	return
+}
+
+func parseICSTime(params map[string]string, value string) (time.Time, bool) {
+	if params["VALUE"] == "DATE" || len(value) == 8 {
+		t, err := time.ParseInLocation("20060102", value, time.Local)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return t, true
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return t.Local(), false
+	}
+	loc := time.Local
+	if tzid := params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, loc)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t.Local(), false
+}
+
+func unescapeICS(s string) string {
+	return strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(s)
+}
+
+// ------------------------------
+// 定时任务 <-> iCalendar
+// ------------------------------
+
+var icsWeekdays = []string{"MO", "TU", "WE", "TH", "FR", "SA", "SU"} //星期1~7
+
+// ExportICS 将定时任务导出为iCalendar日历
+// 能用RRULE表示的(公历每日/每周/每月/每年)生成重复规则，跳过节假日/工作日的日期写入EXDATE；
+// 农历、节气、日出日落等规则展开为逐次的事件实例。
+func ExportICS(w io.Writer, jobs []*CronJob) error {
+	now := time.Now()
+	var b strings.Builder
+	b.WriteString("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//xiaobot//gcron//CN\r\nCALSCALE:GREGORIAN\r\n")
+	writeICSLine(&b, "X-WR-CALNAME:xiaobot定时任务")
+	if _, offset := now.Zone(); offset == 8*3600 {
+		writeICSLine(&b, "X-WR-TIMEZONE:Asia/Shanghai")
+	}
+	for _, job := range jobs {
+		uid := strings.TrimSuffix(job.Filename, ".json") + "@xiaobot"
+		rrule := job.rrule()
+		if rrule == "" {
+			// 展开为实例：每年的任务展开10年，其他展开1年
+			until := now.AddDate(1, 0, 0)
+			if job.JobCycle == -1 {
+				until = now.AddDate(10, 0, 0)
+			}
+			day := ""
+			for _, o := range job.Preview(now, until, 0) {
+				// 同一天只取第一次(忽略“重复多次”)
+				if o.Skipped || o.Time.Format("20060102") == day {
+					continue
+				}
+				day = o.Time.Format("20060102")
+				job.writeEvent(&b, uid[:len(uid)-len("@xiaobot")]+"-"+day+"@xiaobot", o.Time, "", nil)
+			}
+			continue
+		}
+		var exdates []time.Time
+		for _, d := range job.ExcludeDates {
+			if !d.Before(now) && job.inRule(d) {
+				exdates = append(exdates, d)
+			}
+		}
+		if job.SkipHolidays || job.SkipWeekdays {
+			from := job.StartTime.Add(-time.Second)
+			if from.Before(now) {
+				from = now
+			}
+			for _, o := range job.Preview(from, from.AddDate(1, 0, 0), 0) {
+				if (o.Reason == SkipHoliday || o.Reason == SkipWorkday) && job.inRule(o.Time) {
+					exdates = append(exdates, o.Time)
+				}
+			}
+		}
+		job.writeEvent(&b, uid, job.StartTime, rrule, exdates)
+	}
+	b.WriteString("END:VCALENDAR\r\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (t *CronJob) writeEvent(b *strings.Builder, uid string, start time.Time, rrule string, exdates []time.Time) {
+	b.WriteString("BEGIN:VEVENT\r\n")
+	writeICSLine(b, "UID:"+uid)
+	writeICSLine(b, "DTSTAMP:"+time.Now().UTC().Format("20060102T150405Z"))
+	writeICSLine(b, "DTSTART:"+start.Format("20060102T150405"))
+	writeICSLine(b, "SUMMARY:"+escapeICS(t.Name))
+	writeICSLine(b, "DESCRIPTION:"+escapeICS(t.ParseScheduleDescription()))
+	if rrule != "" {
+		writeICSLine(b, "RRULE:"+rrule)
+	}
+	if len(exdates) > 0 {
+		list := make([]string, len(exdates))
+		for i, d := range exdates {
+			list[i] = d.Format("20060102T150405")
+		}
+		writeICSLine(b, "EXDATE:"+strings.Join(list, ","))
+	}
+	b.WriteString("END:VEVENT\r\n")
+}
+
+// rrule 返回任务对应的重复规则，不能用RRULE表示时返回空
+func (t *CronJob) rrule() string {
+	if t.Lunar != "" || t.JobCycle == 0 {
+		return ""
+	}
+	var rule string
+	switch t.JobCycle {
+	case 1: //每日 / 每月的某几日
+		rule = "FREQ=DAILY"
+		if len(t.CycleDetails) > 0 {
+			rule = "FREQ=MONTHLY;BYMONTHDAY=" + joinInts(t.CycleDetails)
+		}
+	case 2: //每周
+		rule = "FREQ=DAILY"
+		if len(t.CycleDetails) > 0 {
+			days := make([]string, 0, len(t.CycleDetails))
+			for _, n := range t.CycleDetails {
+				days = append(days, icsWeekdays[n-1])
+			}
+			rule = "FREQ=WEEKLY;BYDAY=" + strings.Join(days, ",")
+		}
+	case 3: //每月 / 每年的某几月
+		rule = fmt.Sprintf("FREQ=MONTHLY;BYMONTHDAY=%d", t.StartTime.Day())
+		if len(t.CycleDetails) > 0 {
+			rule = fmt.Sprintf("FREQ=YEARLY;BYMONTH=%s;BYMONTHDAY=%d", joinInts(t.CycleDetails), t.StartTime.Day())
+		}
+	case -1: //每年(选了节气的需展开)
+		if len(t.CycleDetails) > 0 {
+			return ""
+		}
+		rule = "FREQ=YEARLY"
+	default: //日出日落、节气日
+		return ""
+	}
+	switch t.JobEnd {
+	case 1:
+		rule += fmt.Sprintf(";COUNT=%d", t.EndCount)
+	case 2:
+		rule += ";UNTIL=" + t.EndDate.Format("20060102T150405")
+	}
+	return rule
+}
+
+// inRule 日期是否属于rrule()生成的重复规则(跳过节假日时会检查规则以外的日期，不应写入EXDATE)
+func (t *CronJob) inRule(d time.Time) bool {
+	has := func(n int) bool {
+		if len(t.CycleDetails) == 0 {
+			return true
+		}
+		for _, v := range t.CycleDetails {
+			if v == n {
+				return true
+			}
+		}
+		return false
+	}
+	switch t.JobCycle {
+	case 1:
+		return has(d.Day())
+	case 2:
+		return has((int(d.Weekday())+6)%7 + 1)
+	case 3:
+		return d.Day() == t.StartTime.Day() && has(int(d.Month()))
+	case -1:
+		return d.Day() == t.StartTime.Day() && d.Month() == t.StartTime.Month()
+	}
+	return false
+}
+
+// ImportICS 将iCalendar日历中的事件转换为定时任务(未保存)
+// 全天事件在allDayAt(如8*time.Hour)时刻提醒；EXDATE的日期不执行；
+// 已过去的不重复事件和无法转换的事件不导入，在skipped中说明原因。
+func ImportICS(r io.Reader, allDayAt time.Duration) (jobs []*CronJob, skipped []string, err error) {
+	events, err := parseICS(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	for _, e := range events {
+		job := &CronJob{
+			IsActive:  true,
+			Name:      e.Summary,
+			StartTime: e.Start,
+			UID:       e.Props["UID"],
+		}
+		if e.AllDay {
+			job.StartTime = e.Start.Add(allDayAt)
+		}
+		if rule := e.Props["RRULE"]; rule != "" {
+			if err := job.applyRRule(rule); err != nil {
+				skipped = append(skipped, fmt.Sprintf("%s: %v", e.Summary, err))
+				continue
+			}
+		} else if job.StartTime.Before(now) {
+			skipped = append(skipped, fmt.Sprintf("%s: 已过去", e.Summary))
+			continue
+		}
+		if v := e.Props["EXDATE"]; v != "" {
+			for _, s := range strings.Split(v, ",") {
+				if d, _ := parseICSTime(nil, s); !d.IsZero() {
+					job.ExcludeDates = append(job.ExcludeDates, d)
+				}
+			}
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, skipped, nil
+}
+
+func (t *CronJob) applyRRule(rule string) error {
+	parts := make(map[string]string)
+	for _, kv := range strings.Split(rule, ";") {
+		if p := strings.SplitN(kv, "=", 2); len(p) == 2 {
+			parts[strings.ToUpper(p[0])] = p[1]
+		}
+	}
+	if v := parts["INTERVAL"]; v != "" && v != "1" {
+		return fmt.Errorf("不支持的间隔 INTERVAL=%s", v)
+	}
+	for _, key := range []string{"BYSETPOS", "BYHOUR", "BYMINUTE", "BYYEARDAY", "BYWEEKNO"} {
+		if parts[key] != "" {
+			return fmt.Errorf("不支持的规则 %s", key)
+		}
+	}
+	byday, bymonth, bymonthday := parts["BYDAY"], parts["BYMONTH"], parts["BYMONTHDAY"]
+
+	switch parts["FREQ"] {
+	case "DAILY":
+		if byday != "" || bymonth != "" || bymonthday != "" {
+			return fmt.Errorf("不支持的规则 %s", rule)
+		}
+		t.JobCycle = 1
+	case "WEEKLY":
+		t.JobCycle = 2
+		if byday == "" {
+			byday = icsWeekdays[(int(t.StartTime.Weekday())+6)%7]
+		}
+		for _, d := range strings.Split(byday, ",") {
+			idx := indexOf(icsWeekdays, strings.ToUpper(d))
+			if idx < 0 {
+				return fmt.Errorf("不支持的星期 %s", d)
+			}
+			t.CycleDetails = append(t.CycleDetails, idx+1)
+		}
+	case "MONTHLY":
+		if byday != "" || bymonth != "" {
+			return fmt.Errorf("不支持的规则 %s", rule)
+		}
+		if bymonthday == "" || bymonthday == fmt.Sprint(t.StartTime.Day()) {
+			t.JobCycle = 3
+		} else {
+			t.JobCycle = 1
+			days, err := splitInts(bymonthday, 1, 31)
+			if err != nil {
+				return err
+			}
+			t.CycleDetails = days
+		}
+	case "YEARLY":
+		if byday != "" || (bymonthday != "" && bymonthday != fmt.Sprint(t.StartTime.Day())) {
+			return fmt.Errorf("不支持的规则 %s", rule)
+		}
+		if bymonth == "" || bymonth == fmt.Sprint(int(t.StartTime.Month())) {
+			t.JobCycle = -1
+		} else {
+			t.JobCycle = 3
+			months, err := splitInts(bymonth, 1, 12)
+			if err != nil {
+				return err
+			}
+			t.CycleDetails = months
+		}
+	default:
+		return fmt.Errorf("不支持的频率 FREQ=%s", parts["FREQ"])
+	}
+
+	if v := parts["COUNT"]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("无效次数 COUNT=%s", v)
+		}
+		t.JobEnd, t.EndCount = 1, n
+	} else if v := parts["UNTIL"]; v != "" {
+		until, _ := parseICSTime(nil, v)
+		if until.IsZero() {
+			return fmt.Errorf("无效日期 UNTIL=%s", v)
+		}
+		t.JobEnd, t.EndDate = 2, until
+	}
+	return nil
+}
+
+// 按75字节折行
+func writeICSLine(b *strings.Builder, line string) {
+	for len(line) > 75 {
+		cut := 75
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+	}
+	b.WriteString(line + "\r\n")
+}
+
+func escapeICS(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+func joinInts(list []int) string {
+	s := make([]string, len(list))
+	for i, n := range list {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, ",")
+}
+
+func splitInts(s string, min, max int) ([]int, error) {
+	var list []int
+	for _, v := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < min || n > max {
+			return nil, fmt.Errorf("不支持的取值 %s", v)
+		}
+		list = append(list, n)
+	}
+	return list, nil
+}
+
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
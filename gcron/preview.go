@@ -0,0 +1,101 @@
+package gcron
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// 候选时间被跳过的原因
+const (
+	SkipHoliday  = "holiday"   //节假日
+	SkipWorkday  = "workday"   //工作日
+	SkipExcluded = "excluded"  //不执行的日期(日历的EXDATE)
+	SkipEndCount = "end_count" //已达执行次数
+	SkipEndDate  = "end_date"  //已过终止日期
+)
+
+// 预览最多返回的触发次数
+const previewLimit = 500
+
+// Occurrence 预览中的一次触发（或被跳过的候选时间）
+type Occurrence struct {
+	Time    time.Time `json:"time"`
+	Skipped bool      `json:"skipped"`
+	Reason  string    `json:"reason,omitempty"` //跳过原因: holiday/workday/excluded/end_count/end_date
+
+	Conditional bool `json:"conditional,omitempty"` //执行时需满足执行条件
+}
+
+// Preview 从from开始模拟执行任务，返回之后的触发时间及被跳过的候选时间
+// count>0 时最多返回count次触发；until非零时只返回until之前的时间。
+// 模拟使用任务的副本，不影响正在调度中的Schedule。
+func (t *CronJob) Preview(from, until time.Time, count int) []Occurrence {
+	if count <= 0 && until.IsZero() {
+		count = 10
+	}
+	if count <= 0 || count > previewLimit {
+		count = previewLimit
+	}
+
+	job := &CronJob{}
+	job.Assign(t)
+	p := Recurring(job)
+
+	var skipped []Occurrence
+	seen := make(map[int64]bool)
+	p.onSkip = func(at time.Time, reason string) {
+		if seen[at.Unix()] {
+			return
+		}
+		seen[at.Unix()] = true
+		skipped = append(skipped, Occurrence{Time: at, Skipped: true, Reason: reason})
+	}
+
+	conditional := strings.TrimSpace(t.Condition) != ""
+	var list []Occurrence
+	cur := from
+	next := p.Next(cur)
+	for {
+		if !next.After(cur) {
+			// 不再触发：若因结束条件终止，给出本应触发的时间
+			if p.isTimesOver {
+				p.onSkip = nil
+				reason := SkipEndCount
+				if p.TaskEnd == 2 {
+					reason = SkipEndDate
+				}
+				if end := p.reset(cur); end.After(cur) && (until.IsZero() || !end.After(until)) {
+					list = append(list, Occurrence{Time: end, Skipped: true, Reason: reason})
+				}
+			}
+			break
+		}
+		if !until.IsZero() && next.After(until) {
+			break
+		}
+		list = append(list, Occurrence{Time: next, Conditional: conditional})
+		if len(list) >= count {
+			break
+		}
+		// 与Cron.run一致：先计算下一次时间，再执行(累计次数)
+		cur = next
+		next = p.Next(cur)
+		p.tally()
+	}
+
+	// 只保留已模拟区间内的跳过记录
+	bound := until
+	if len(list) > 0 && (bound.IsZero() || len(list) >= count) {
+		bound = list[len(list)-1].Time
+	}
+	for _, s := range skipped {
+		if s.Time.After(from) && !s.Time.After(bound) {
+			list = append(list, s)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Time.Before(list[j].Time)
+	})
+	return list
+}
@@ -3,9 +3,11 @@ package gcron
 import (
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"io/ioutil"
 	"ninego/log"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 )
@@ -15,6 +17,16 @@ type ScriptExecutor interface {
 	Execute(script string, name string) error
 }
 
+// ConditionEvaluator 执行条件求值接口(执行器可选实现)
+type ConditionEvaluator interface {
+	Evaluate(expr string) (bool, error)
+}
+
+// FiredNotifier 定时任务触发通知接口(执行器可选实现)
+type FiredNotifier interface {
+	Fired(filename, name string)
+}
+
 // ScriptJob 是专门执行脚本的Job实现
 type ScriptJob struct {
 	Schedule *PeriodSchedule
@@ -31,8 +43,14 @@ func (sj ScriptJob) Run() {
 		}
 		// 使用指定的执行器执行脚本
 		executor := sj.Executor
+		if executor != nil && !sj.checkCondition() {
+			return
+		}
+		if notifier, ok := executor.(FiredNotifier); ok {
+			notifier.Fired(sj.Schedule.Filename, sj.Schedule.Name)
+		}
 		if executor != nil {
-			err := executor.Execute(sj.Schedule.RunScript, sj.Schedule.Name)
+			err := executor.Execute(sj.Schedule.RunScript, sj.Schedule.ScriptFile())
 			if err != nil {
 				log.Printf("脚本执行失败 [%s]: %v\n", sj.Schedule.Name, err)
 			}
@@ -42,6 +60,27 @@ func (sj ScriptJob) Run() {
 	}
 }
 
+// 检查执行条件，条件不满足(或求值出错)时不执行
+func (sj ScriptJob) checkCondition() bool {
+	expr := strings.TrimSpace(sj.Schedule.Condition)
+	if expr == "" {
+		return true
+	}
+	evaluator, ok := sj.Executor.(ConditionEvaluator)
+	if !ok {
+		return true
+	}
+	met, err := evaluator.Evaluate(expr)
+	if err != nil {
+		log.Printf("执行条件出错 [%s]: %v\n", sj.Schedule.Name, err)
+		return false
+	}
+	if !met {
+		log.Printf("执行条件不满足，跳过执行 [%s]: %s\n", sj.Schedule.Name, expr)
+	}
+	return met
+}
+
 // 添加计划任务到Cron
 func (c *Cron) Schedule(cronJob *CronJob, executor ScriptExecutor) {
 	for _, entry := range c.entries {
@@ -91,7 +130,7 @@ type CronJob struct {
 	StartTime time.Time `json:"start_time"` //开始日期时间
 	Lunar     string    `json:"lunar"`      //农历
 
-	JobCycle       int    `json:"job_cycle"`       //周期类型: 0=一次 1=每日 2=每周 3=每月 -1=每年
+	JobCycle       int    `json:"job_cycle"`       //周期类型: 0=一次 1=每日 2=每周 3=每月 -1=每年 4=日出日落 5=节气日
 	CycleDetails   []int  `json:"cycle_details"`   //周期明细 bits=0->all, bit30..0=1~31日/1~12月/星期1~7 periodic
 	SkipHolidays   bool   `json:"skip_holidays"`   //跳过节假日
 	SkipWeekdays   bool   `json:"skip_weekdays"`   //跳过工作日
@@ -99,6 +138,17 @@ type CronJob struct {
 	RepeatInterval string `json:"repeat_interval"` //间隔时长
 	RepeatDuration string `json:"repeat_duration"` //持续时间(时长表达式,小于1s=次数)
 
+	SunEvent      string `json:"sun_event"`      //太阳事件: dawn/sunrise/noon/sunset/dusk (4=日出日落必填,5=节气日可选)
+	OffsetMinutes int    `json:"offset_minutes"` //相对触发时间的偏移分钟数(负数=提前)
+
+	Condition     string `json:"condition"`      //执行条件(JS表达式,为空=无条件),如 !bot.storage.away
+	JitterMinutes int    `json:"jitter_minutes"` //随机延后分钟数,在[触发时间,触发时间+N分钟)内随机执行
+
+	BotScript string `json:"bot,omitempty"` //执行的任务脚本名(xxx.bot,不含后缀),为空时执行同名的.job脚本
+
+	UID          string      `json:"uid,omitempty"`           //从日历导入的事件UID(再次导入时更新该任务)
+	ExcludeDates []time.Time `json:"exclude_dates,omitempty"` //不执行的日期(日历事件的EXDATE)
+
 	JobEnd   int       `json:"job_end"`   //0=永久 1=次数 2=日期时间
 	EndCount int       `json:"end_count"` //执行次数
 	EndDate  time.Time `json:"end_date"`  //终止日期
@@ -194,6 +244,68 @@ func (t *CronJob) ParseScheduleDescription() string {
 				str += fmt.Sprintf("：%s月%s", LunarMonthStr(lunar.Month), LunarDayStr(lunar.Day))
 			}
 		}
+	case 4:
+		str = "每天"
+		if len(t.CycleDetails) > 0 {
+			str = "每周"
+			weeks := []string{"一", "二", "三", "四", "五", "六", "日"}
+			for _, n := range t.CycleDetails {
+				str += fmt.Sprintf("%s,", weeks[n-1])
+			}
+			str = strings.TrimRight(str, ",") + " "
+		}
+		str += t.eventDescription()
+	case 5:
+		str = "节气："
+		if len(t.CycleDetails) > 0 {
+			for _, n := range t.CycleDetails {
+				str += solarTerms[n-1] + ","
+			}
+			str = strings.TrimRight(str, ",")
+		} else {
+			str += "每个节气"
+		}
+		str += t.eventDescription()
+	}
+	if t.JitterMinutes > 0 {
+		str += fmt.Sprintf("(随机延后%d分钟内)", t.JitterMinutes)
+	}
+	if strings.TrimSpace(t.Condition) != "" {
+		str += "(有执行条件)"
+	}
+	return str
+}
+
+// 触发时刻的描述，如“日落前30分钟”、“08:00提前1天”
+func (t *CronJob) eventDescription() string {
+	offset := t.OffsetMinutes
+	word := "后"
+	if offset < 0 {
+		offset, word = -offset, "前"
+	}
+	var span string
+	switch {
+	case offset == 0:
+	case offset%1440 == 0:
+		span = fmt.Sprintf("%d天", offset/1440)
+	case offset%60 == 0:
+		span = fmt.Sprintf("%d小时", offset/60)
+	default:
+		span = fmt.Sprintf("%d分钟", offset)
+	}
+	if t.SunEvent != "" {
+		if span == "" {
+			return SunEventName(t.SunEvent)
+		}
+		return SunEventName(t.SunEvent) + word + span
+	}
+	str := fmt.Sprintf(" %02d:%02d", t.StartTime.Hour(), t.StartTime.Minute())
+	if span != "" {
+		if word == "前" {
+			str += "提前" + span
+		} else {
+			str += "推迟" + span
+		}
 	}
 	return str
 }
@@ -213,6 +325,13 @@ func (t *CronJob) Assign(src *CronJob) {
 	t.JobRepeat = src.JobRepeat
 	t.RepeatInterval = src.RepeatInterval
 	t.RepeatDuration = src.RepeatDuration
+	t.SunEvent = src.SunEvent
+	t.OffsetMinutes = src.OffsetMinutes
+	t.Condition = src.Condition
+	t.JitterMinutes = src.JitterMinutes
+	t.BotScript = src.BotScript
+	t.UID = src.UID
+	t.ExcludeDates = append([]time.Time(nil), src.ExcludeDates...)
 	t.JobEnd = src.JobEnd
 	t.EndCount = src.EndCount
 	t.EndDate = src.EndDate
@@ -280,13 +399,23 @@ type PeriodSchedule struct {
 	IsLunar bool
 
 	//周期类型
-	TaskCycle int //0=一次 1=每日 2=每周 3=每月 -1=每年
+	TaskCycle int //0=一次 1=每日 2=每周 3=每月 -1=每年 4=日出日落 5=节气日
 	//周期明细
-	CycleDetails int //bits=0->all, bit30..0=1~31日/1~12月/星期1~7
+	CycleDetails int //bits=0->all, bit30..0=1~31日/1~12月/星期1~7/24节气
+	//太阳事件(为空时用开始时间的时分)
+	SunEvent string
+	//触发偏移
+	Offset time.Duration
+	//随机延后窗口
+	Jitter time.Duration
+	//执行条件(JS表达式)
+	Condition string
 	//跳过节假日
 	SkipHolidays bool
 	//跳过工作日
 	SkipWeekdays bool
+	//不执行的日期
+	ExcludeDates []time.Time
 	//是否重复
 	TaskRepeat     bool
 	RepeatInterval time.Duration //间隔时长
@@ -297,7 +426,8 @@ type PeriodSchedule struct {
 	EndDate  time.Time
 
 	RunScript string
-	Job       Job // 任务执行接口
+	BotScript string //引用的任务脚本名，每次执行时重新读取
+	Job       Job    // 任务执行接口
 
 	//下一次开始
 	NextTime time.Time
@@ -310,6 +440,8 @@ type PeriodSchedule struct {
 	currentRepeat int64 // 当前重复序号（0起始）
 	repeatCount   int64 // 要重复的次数
 	runTotal      int   //总次数
+
+	onSkip func(t time.Time, reason string) // 候选时间被跳过时回调(预览用)
 }
 
 // 生成定时任务
@@ -332,6 +464,12 @@ func Recurring(cronJob *CronJob) *PeriodSchedule {
 	schedule.TaskCycle = cronJob.JobCycle
 	schedule.SkipHolidays = cronJob.SkipHolidays
 	schedule.SkipWeekdays = cronJob.SkipWeekdays
+	schedule.ExcludeDates = cronJob.ExcludeDates
+	schedule.SunEvent = cronJob.SunEvent
+	schedule.Offset = time.Duration(cronJob.OffsetMinutes) * time.Minute
+	schedule.Jitter = time.Duration(cronJob.JitterMinutes) * time.Minute
+	schedule.Condition = cronJob.Condition
+	schedule.BotScript = cronJob.BotScript
 	schedule.TaskRepeat = cronJob.JobRepeat
 	schedule.TaskEnd = cronJob.JobEnd
 	schedule.EndCount = cronJob.EndCount
@@ -376,7 +514,7 @@ func Recurring(cronJob *CronJob) *PeriodSchedule {
 		script, _ := ioutil.ReadAll(file)
 		schedule.RunScript = string(script)
 	}
-	fmt.Printf("schedule=%+v\n", schedule)*/
+	log.Printf("schedule=%+v\n", schedule)*/
 	return schedule
 }
 
@@ -387,18 +525,41 @@ func (p *PeriodSchedule) nextAfter(next, t time.Time) bool {
 	if p.SkipHolidays { //跳过节假日
 		if IsHoliday(next) {
 			//log.Debug("跳过节假日", next)
+			p.skipped(next, SkipHoliday)
 			return false
 		}
 	}
 	if p.SkipWeekdays { //跳过工作日
 		if IsWorkday(next) {
 			//log.Debug("跳过工作日", next)
+			p.skipped(next, SkipWorkday)
 			return false
 		}
 	}
+	if p.isExcluded(next) {
+		p.skipped(next, SkipExcluded)
+		return false
+	}
 	return true
 }
 
+// 是否为不执行的日期(按日期比较，全天事件的EXDATE没有时刻)
+func (p *PeriodSchedule) isExcluded(t time.Time) bool {
+	day := t.Format("20060102")
+	for _, d := range p.ExcludeDates {
+		if d.Local().Format("20060102") == day {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *PeriodSchedule) skipped(t time.Time, reason string) {
+	if p.onSkip != nil {
+		p.onSkip(t, reason)
+	}
+}
+
 func (p *PeriodSchedule) reset(t time.Time) time.Time {
 	var nextTime time.Time
 	yy, mm, dd := 0, 0, 0
@@ -422,6 +583,10 @@ func (p *PeriodSchedule) reset(t time.Time) time.Time {
 		IsLunar = false
 	case 3: //每月
 		mm = 1
+	case 4: //日出日落
+		return p.resetSun(t)
+	case 5: //节气日
+		return p.resetSolarTerm(t)
 	}
 	year, month, day := p.StartTime.Date()
 	hour, min, sec := p.StartTime.Clock()
@@ -540,6 +705,27 @@ func (p *PeriodSchedule) reset(t time.Time) time.Time {
 }
 
 func (p *PeriodSchedule) Run() {
+	p.tally()
+
+	log.Printf("执行任务: %s, 当前重复次数: %d, 总执行次数: %d. time=%s\n", p.Name, p.currentRepeat, p.runTotal, time.Now())
+
+	/*/if p.RunScript != "" {
+		err := p.loadScript()
+		if err != nil {
+			log.Printf("任务执行失败 [%s]: %v\n", p.Name, err)
+		} else {
+			log.Printf("任务执行成功 [%s]\n", p.Name)
+		}
+	}*/
+	// 实际执行脚本
+	if p.Job != nil {
+		p.Job.Run()
+	}
+
+}
+
+// tally 累计执行次数并检查结束条件（Run与预览模拟共用）
+func (p *PeriodSchedule) tally() {
 	if p.repeatCount > 0 {
 		p.currentRepeat += 1
 		if p.TaskEnd == 1 && p.currentRepeat == 1 { //次数
@@ -552,8 +738,6 @@ func (p *PeriodSchedule) Run() {
 		}
 	}
 
-	log.Printf("执行任务: %s, 当前重复次数: %d, 总执行次数: %d. time=%s\n", p.Name, p.currentRepeat, p.runTotal, time.Now())
-
 	if p.TaskEnd == 1 { //次数
 		if p.runTotal > p.EndCount {
 			p.isTimesOver = true
@@ -564,25 +748,34 @@ func (p *PeriodSchedule) Run() {
 			p.isTimesOver = true
 		}
 	}
+}
 
-	/*/if p.RunScript != "" {
-		err := p.loadScript()
-		if err != nil {
-			fmt.Printf("任务执行失败 [%s]: %v\n", p.Name, err)
-		} else {
-			fmt.Printf("任务执行成功 [%s]\n", p.Name)
-		}
-	}*/
-	// 实际执行脚本
-	if p.Job != nil {
-		p.Job.Run()
+// BotScriptPath 任务脚本(xxx.bot)的路径，与任务脚本(/task)一样在程序所在的目录(不受当前目录影响)
+func BotScriptPath(name string) string {
+	dir := "."
+	if exePath, err := os.Executable(); err == nil {
+		dir = filepath.Dir(exePath)
 	}
-
+	return filepath.Join(dir, name+".bot")
 }
 
 // loadScript 执行任务脚本
+// ScriptFile 执行的脚本文件名(用于读取脚本的权限声明)
+func (p *PeriodSchedule) ScriptFile() string {
+	if p.BotScript != "" {
+		return BotScriptPath(p.BotScript)
+	}
+	return p.Filename
+}
+
 func (p *PeriodSchedule) LoadScript() error {
-	if p.RunScript == "" {
+	if p.BotScript != "" {
+		script, err := ioutil.ReadFile(BotScriptPath(p.BotScript))
+		if err != nil {
+			return err
+		}
+		p.RunScript = string(script)
+	} else if p.RunScript == "" {
 		if IsExist(p.Filename) {
 			file, err := os.Open(p.Filename)
 			if err != nil {
@@ -662,7 +855,7 @@ func (p *PeriodSchedule) nextPeriod(t time.Time) time.Duration {
 	}
 
 	// 计算下一次周期的开始时间
-	nextTime = p.reset(t)
+	nextTime = p.resetJitter(t)
 
 	// 更新状态
 	p.NextTime = nextTime
@@ -672,6 +865,34 @@ func (p *PeriodSchedule) nextPeriod(t time.Time) time.Duration {
 	return p.afterPeriod
 }
 
+// resetJitter 计算加上随机延后的下一次时间
+// 延后量由任务和触发时间决定(同一次触发每次计算结果相同)，预览与实际执行一致；
+// 从t-Jitter开始查找，避免在窗口内重置调度时漏掉尚未执行的这一次。
+func (p *PeriodSchedule) resetJitter(t time.Time) time.Time {
+	if p.Jitter <= 0 {
+		return p.reset(t)
+	}
+	from := t.Add(-p.Jitter)
+	for i := 0; i < 100; i++ {
+		base := p.reset(from)
+		if !base.After(from) {
+			return t //不再触发
+		}
+		if next := base.Add(p.jitterOf(base)); next.After(t) {
+			return next
+		}
+		from = base
+	}
+	return t
+}
+
+// jitterOf 返回某次触发的随机延后量(按整秒)
+func (p *PeriodSchedule) jitterOf(base time.Time) time.Duration {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s@%d", p.Filename, base.Unix())
+	return time.Duration(h.Sum64()%uint64(p.Jitter/time.Second)) * time.Second
+}
+
 // IsExpired 检查任务是否已经过期（所有执行次数已完成或已超过结束时间）
 // 返回true表示任务已经完成，不再需要调度
 func (p *PeriodSchedule) IsExpired() bool {
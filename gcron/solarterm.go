@@ -110,3 +110,31 @@ func GetDateBySolarTerm(year int, termName string) (time.Time, error) {
 	}
 	return day, nil
 }
+
+// 节气日：选中节气(CycleDetails,0=全部)当天的触发时间
+func (p *PeriodSchedule) resetSolarTerm(t time.Time) time.Time {
+	var nextTime time.Time
+	for year := t.Year() - 1; year <= t.Year()+2; year++ {
+		for i := 0; i < 24; i++ {
+			if p.CycleDetails != 0 && (p.CycleDetails&dayToBitMask(i+1)) == 0 {
+				continue
+			}
+			d := solarTermDay(year, i)
+			day := time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, time.Local)
+			if day.Before(time.Date(p.StartTime.Year(), p.StartTime.Month(), p.StartTime.Day(), 0, 0, 0, 0, time.Local)) {
+				continue
+			}
+			next, ok := p.eventTime(day)
+			if !ok || !p.nextAfter(next, t) {
+				continue
+			}
+			if nextTime.IsZero() || next.Before(nextTime) {
+				nextTime = next
+			}
+		}
+	}
+	if nextTime.IsZero() {
+		return t
+	}
+	return nextTime
+}
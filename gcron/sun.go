@@ -0,0 +1,181 @@
+package gcron
+
+/*
+日出日落计算（离线）
+算法参考 Almanac for Computers(1990) 的日出日落公式，民用精度约±2分钟。
+	日出/日落：太阳天顶角 90°50′（含大气折射与视半径）
+	黎明/黄昏：民用晨昏蒙影，太阳天顶角 96°
+	正午：日出与日落的中点
+*/
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// 太阳事件
+const (
+	SunDawn    = "dawn"    //黎明(民用晨光始)
+	SunSunrise = "sunrise" //日出
+	SunNoon    = "noon"    //正午
+	SunSunset  = "sunset"  //日落
+	SunDusk    = "dusk"    //黄昏(民用昏影终)
+)
+
+var sunEventNames = map[string]string{
+	SunDawn:    "黎明",
+	SunSunrise: "日出",
+	SunNoon:    "正午",
+	SunSunset:  "日落",
+	SunDusk:    "黄昏",
+}
+
+// 家庭所在位置（默认北京）
+var (
+	homeLatitude  = 39.9042
+	homeLongitude = 116.4074
+	homeMutex     sync.RWMutex
+)
+
+// SetHomeLocation 设置家庭所在经纬度（北纬/东经为正），均为0时保持默认值
+func SetHomeLocation(latitude, longitude float64) {
+	if latitude == 0 && longitude == 0 {
+		return
+	}
+	homeMutex.Lock()
+	homeLatitude, homeLongitude = latitude, longitude
+	homeMutex.Unlock()
+}
+
+// HomeLocation 返回当前使用的经纬度
+func HomeLocation() (latitude, longitude float64) {
+	homeMutex.RLock()
+	defer homeMutex.RUnlock()
+	return homeLatitude, homeLongitude
+}
+
+// SunEventName 返回太阳事件的中文名
+func SunEventName(event string) string {
+	return sunEventNames[event]
+}
+
+// GetSunEventTime 计算指定日期(本地时区)的太阳事件时间
+// 极昼/极夜等当天不发生该事件时返回false
+func GetSunEventTime(day time.Time, event string) (time.Time, bool) {
+	lat, lon := HomeLocation()
+	switch event {
+	case SunDawn:
+		return sunTime(day, lat, lon, 96, true)
+	case SunSunrise:
+		return sunTime(day, lat, lon, 90.8333, true)
+	case SunSunset:
+		return sunTime(day, lat, lon, 90.8333, false)
+	case SunDusk:
+		return sunTime(day, lat, lon, 96, false)
+	case SunNoon:
+		rise, ok1 := sunTime(day, lat, lon, 90.8333, true)
+		set, ok2 := sunTime(day, lat, lon, 90.8333, false)
+		if !ok1 || !ok2 {
+			return time.Time{}, false
+		}
+		return rise.Add(set.Sub(rise) / 2).Truncate(time.Second), true
+	}
+	return time.Time{}, false
+}
+
+func sunTime(day time.Time, lat, lon, zenith float64, rising bool) (time.Time, bool) {
+	rad := math.Pi / 180
+	year, month, date := day.Date()
+	n := float64(day.YearDay())
+
+	// 近似时间
+	lngHour := lon / 15
+	t := n + (18-lngHour)/24
+	if rising {
+		t = n + (6-lngHour)/24
+	}
+
+	// 太阳平近点角与真黄经
+	m := 0.9856*t - 3.289
+	l := normalize(m+1.916*math.Sin(m*rad)+0.020*math.Sin(2*m*rad)+282.634, 360)
+
+	// 赤经（与黄经同象限）
+	ra := normalize(math.Atan(0.91764*math.Tan(l*rad))/rad, 360)
+	ra += math.Floor(l/90)*90 - math.Floor(ra/90)*90
+	ra /= 15
+
+	// 赤纬
+	sinDec := 0.39782 * math.Sin(l*rad)
+	cosDec := math.Cos(math.Asin(sinDec))
+
+	// 时角
+	cosH := (math.Cos(zenith*rad) - sinDec*math.Sin(lat*rad)) / (cosDec * math.Cos(lat*rad))
+	if cosH > 1 || cosH < -1 {
+		return time.Time{}, false
+	}
+	h := math.Acos(cosH) / rad
+	if rising {
+		h = 360 - h
+	}
+	h /= 15
+
+	// 当地平时 -> UTC
+	ut := normalize(h+ra-0.06571*t-6.622-lngHour, 24)
+	result := time.Date(year, month, date, 0, 0, 0, 0, time.UTC).
+		Add(time.Duration(ut * float64(time.Hour))).In(day.Location()).Truncate(time.Second)
+
+	// 校正到本地日期的同一天
+	local := time.Date(year, month, date, 0, 0, 0, 0, day.Location())
+	for result.Before(local) {
+		result = result.Add(Day)
+	}
+	for !result.Before(local.Add(Day)) {
+		result = result.Add(-Day)
+	}
+	return result, true
+}
+
+func normalize(v, max float64) float64 {
+	v = math.Mod(v, max)
+	if v < 0 {
+		v += max
+	}
+	return v
+}
+
+// 计算某天的触发时间：有太阳事件用事件时间，否则用开始时间的时分秒，再加偏移
+func (p *PeriodSchedule) eventTime(day time.Time) (time.Time, bool) {
+	if p.SunEvent != "" {
+		at, ok := GetSunEventTime(day, p.SunEvent)
+		if !ok {
+			return time.Time{}, false
+		}
+		return at.Add(p.Offset), true
+	}
+	hour, min, sec := p.StartTime.Clock()
+	year, month, date := day.Date()
+	return time.Date(year, month, date, hour, min, sec, 0, time.Local).Add(p.Offset), true
+}
+
+// 日出日落：从开始日期起，每天(或选中的星期)的太阳事件时间
+func (p *PeriodSchedule) resetSun(t time.Time) time.Time {
+	start := time.Date(p.StartTime.Year(), p.StartTime.Month(), p.StartTime.Day(), 0, 0, 0, 0, time.Local)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local).AddDate(0, 0, -1) //偏移可能跨天
+	if day.Before(start) {
+		day = start
+	}
+	for i := 0; i < 400; i++ {
+		key := int(day.Weekday())
+		if key == 0 {
+			key = 7
+		}
+		if p.CycleDetails == 0 || (p.CycleDetails&dayToBitMask(key)) != 0 {
+			if next, ok := p.eventTime(day); ok && p.nextAfter(next, t) {
+				return next
+			}
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	return t //一年内都不会发生(极昼/极夜)
+}
@@ -3,6 +3,7 @@ package jarvis
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	//"crypto/tls"
 	//"net/http"
@@ -45,6 +46,34 @@ type ChatGPTRequestBody struct {
 	Messages         []RoleContent `json:"messages,omitempty"`
 }
 
+// UnknownPhrases 小爱表示不知道的说法：小爱的回答包含其中之一时改由AI回答(配置 unknown_answers 可替换)
+var UnknownPhrases = []string{
+	"被你问住了", "把我难住了", "被难住了", "我好像还不太知道",
+	"我暂时还回答不上", "我暂时还不支持", "暂不支持",
+	"我还在研究中", "我还在学习中", "要再学习", "要更努力学习",
+	"换个方式再说一遍", "换个话题", "这个话题我不太擅长",
+	"最新小爱音箱APP", "本设备暂不支持该功能", "绑定音乐账号",
+}
+
+// 提示词中的不知道的说法
+func unknownList() string {
+	list := make([]string, 0, len(UnknownPhrases))
+	for _, phrase := range UnknownPhrases {
+		list = append(list, "- "+phrase)
+	}
+	return strings.Join(list, "\n")
+}
+
+// PromptContext 问AI时附加到系统提示词后的内容(如长期记忆)，按提问返回，为空时不附加
+type PromptContext func(query string) string
+
+var promptContexts []PromptContext
+
+// AddPromptContext 注册附加到系统提示词的内容
+func AddPromptContext(f PromptContext) {
+	promptContexts = append(promptContexts, f)
+}
+
 type GhatGPT struct {
 	Model   string
 	Key     string
@@ -52,6 +81,7 @@ type GhatGPT struct {
 	Proxy   string
 
 	Prompt         string
+	NoContext      bool //不附加 AddPromptContext 的内容
 	GPTOptions     map[string]interface{}
 	Adapter        *jsengine.Program
 	HistoryMessage []RoleContent
@@ -66,7 +96,7 @@ func (g *GhatGPT) Ask(msg, answer string) (string, error) {
 	messages := []openai.ChatCompletionMessage{
 		{
 			Role:    openai.ChatMessageRoleSystem,
-			Content: g.Prompt, // "system系统提示词"
+			Content: g.systemPrompt(msg), // "system系统提示词"
 		},
 	}
 	if g.HistoryMessage != nil {
@@ -100,19 +130,8 @@ func (g *GhatGPT) Ask(msg, answer string) (string, error) {
 %s
 </answer>
 不知道的表述包含以下关键词：-
-- 我暂时还回答不上
-- 被你问住了
-- 把我难住了
-- 被难住了
-- 我还在学习中
-- 我还在研究中
-- 要再学习
-- 要更努力学习
-- 换个话题
-- 换个方式再说一遍
-- 最新小爱音箱APP
-- 本设备暂不支持该功能
-如果AI的回答中包含上述任意关键词，请重新给出答复（直接输出答案，不要带有任何其他信息，不要让人知道有AI的存在）；如果不包含，则直接返回AI回答的原文内容。`, msg, answer),
+%s
+如果AI的回答中包含上述任意关键词，请重新给出答复（直接输出答案，不要带有任何其他信息，不要让人知道有AI的存在）；如果不包含，则直接返回AI回答的原文内容。`, msg, answer, unknownList()),
 			},
 		)
 	}
@@ -162,7 +181,7 @@ func (g *GhatGPT) AskStream(msg, answer string) (Stream *openai.ChatCompletionSt
 	messages := []openai.ChatCompletionMessage{
 		{
 			Role:    openai.ChatMessageRoleSystem,
-			Content: g.Prompt, // "system系统提示词"
+			Content: g.systemPrompt(msg), // "system系统提示词"
 		},
 	}
 	if g.HistoryMessage != nil {
@@ -196,19 +215,8 @@ func (g *GhatGPT) AskStream(msg, answer string) (Stream *openai.ChatCompletionSt
 %s
 </answer>
 不知道的表述包含以下关键词：-
-- 我暂时还回答不上
-- 被你问住了
-- 把我难住了
-- 被难住了
-- 我还在学习中
-- 我还在研究中
-- 要再学习
-- 要更努力学习
-- 换个话题
-- 换个方式再说一遍
-- 最新小爱音箱APP
-- 本设备暂不支持该功能
-如果AI的回答中包含上述任意关键词，请重新给出答复（直接输出答案，不要带有任何其他信息，不要让人知道有AI的存在）；如果不包含，则直接返回AI回答的原文内容。`, msg, answer),
+%s
+如果AI的回答中包含上述任意关键词，请重新给出答复（直接输出答案，不要带有任何其他信息，不要让人知道有AI的存在）；如果不包含，则直接返回AI回答的原文内容。`, msg, answer, unknownList()),
 			},
 		)
 	}
@@ -244,6 +252,23 @@ func (g *GhatGPT) AskStream(msg, answer string) (Stream *openai.ChatCompletionSt
 	return stream, nil
 }
 
+// 系统提示词：人设提示词(按模板填入日期等)和附加的内容
+func (g *GhatGPT) systemPrompt(query string) string {
+	prompt, err := jsengine.RenderPrompt(g.Prompt, map[string]interface{}{"query": query})
+	if err != nil {
+		log.Error(err)
+	}
+	if g.NoContext {
+		return prompt
+	}
+	for _, f := range promptContexts {
+		if text := strings.TrimSpace(f(query)); text != "" {
+			prompt += "\n\n" + text
+		}
+	}
+	return prompt
+}
+
 func (g *GhatGPT) GetHistory() *[]RoleContent {
 	return &g.HistoryMessage
 }
@@ -365,7 +365,9 @@ func (pro *Program) ConvertRequest(header map[string]string, openai []byte) (out
 	vm.Set("response", nil)
 	vm.Set("header", header)
 	//vm.Set("openai", requestMap)
-	result, err := vm.RunProgram(pro.program)
+	result, err := runWithTimeout(vm, timeoutOf(ContextAdapter), func() (goja.Value, error) {
+		return vm.RunProgram(pro.program)
+	})
 	if err != nil {
 		fmt.Printf("执行JavaScript失败: %v\n", err)
 		return nil, err
@@ -399,7 +401,9 @@ func (pro *Program) ConvertResponse(response []byte, stream bool) (out []byte, e
 	vm.Set("request", nil)
 	vm.Set("response", responseMap)
 	//vm.Set("openai", openaiMap)
-	result, err := vm.RunProgram(pro.program)
+	result, err := runWithTimeout(vm, timeoutOf(ContextAdapter), func() (goja.Value, error) {
+		return vm.RunProgram(pro.program)
+	})
 	if err != nil {
 		fmt.Printf("执行JavaScript失败: %v\n", err)
 		return nil, err
@@ -0,0 +1,789 @@
+package jsengine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ninego/log"
+	"xiaobot/jsengine/console"
+
+	"github.com/dop251/goja"
+	"github.com/gorilla/websocket"
+)
+
+/*
+Web应用脚本
+	程序目录下 scripts/apps/*.js，每个文件是一个应用，路由注册在 /app/{文件名}/ 下，文件修改后自动重新加载：
+	app.get('/items', function(req, res) { res.json(bot.storage.items || []); });		//GET /app/shopping/items
+	app.post('/items/{id}', function(req, res) { var id = req.params().id; ... res.send('ok'); });
+	app.sse('/events', function(req, res) { var t = setInterval(function() { res.event({now: Date.now()}); }, 1000); res.onClose(function() { clearInterval(t); }); });
+	app.ws('/chat', function(ws, req) { ws.onMessage(function(msg) { ws.send('echo: ' + msg); }); });
+	app.static('/', 'www');		//scripts/apps/shopping/www 下的文件
+	每个应用在独立的VM中运行(保留全局变量和定时器)，按脚本声明的权限执行，未声明时使用 DefaultPermissions[ContextApp]。
+*/
+
+// Web应用脚本目录(相对程序目录)
+var AppDir = filepath.Join("scripts", "apps")
+
+// Web应用的URL前缀
+const AppPrefix = "/app/"
+
+// 请求体的最大长度
+const maxAppBody = 1 << 20
+
+var errAppStopped = errors.New("应用已停止(脚本已修改或重新加载)")
+
+// 不设置CheckOrigin，只接受同源页面的连接(其他网站的页面不能连接并调用应用)
+var appUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// 路由中的参数，如 /items/{id} 中的 id
+var routeParam = regexp.MustCompile(`\{(\w+)(\.\.\.)?\}`)
+
+type webApp struct {
+	name   string
+	file   string
+	engine *Engine
+	mux    *http.ServeMux
+	routes int
+	ctx    context.Context //应用卸载时取消，结束未完成的流和websocket
+	cancel context.CancelFunc
+}
+
+type webApps struct {
+	mu      sync.Mutex //保护下面的字段，运行应用脚本时不持有
+	loadMu  sync.Mutex //同时只加载一次
+	apps    map[string]*webApp
+	loaded  time.Time //加载时scripts/apps的最后修改时间
+	checked time.Time //上次检查时间
+}
+
+var Apps = &webApps{}
+
+// Web应用目录的绝对路径
+func appDirPath() string {
+	if filepath.IsAbs(AppDir) {
+		return AppDir
+	}
+	return filepath.Join(GetExecutableDir(), AppDir)
+}
+
+// 应用脚本的最后修改时间(不包括静态文件，修改静态文件不重新加载)
+func appsModTime(dir string) time.Time {
+	var last time.Time
+	if info, err := os.Stat(dir); err == nil {
+		last = info.ModTime()
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.js"))
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil && info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+	}
+	return last
+}
+
+// ServeHTTP 处理 /app/{name}/... 请求
+func (a *webApps) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, AppPrefix), "/")
+	a.refresh()
+	a.mu.Lock()
+	app := a.apps[name]
+	a.mu.Unlock()
+	if app == nil {
+		http.NotFound(w, r)
+		return
+	}
+	app.mux.ServeHTTP(w, r)
+}
+
+// List 已加载的应用名称
+func (a *webApps) List() []string {
+	a.refresh()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	names := make([]string, 0, len(a.apps))
+	for name := range a.apps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Reload 重新加载全部应用
+func (a *webApps) Reload() {
+	a.reload(false)
+}
+
+// 首次使用或 scripts/apps 有修改时重新加载(最多每2秒检查一次)
+func (a *webApps) refresh() {
+	a.mu.Lock()
+	fresh := a.apps != nil && time.Since(a.checked) < 2*time.Second
+	if !fresh {
+		a.checked = time.Now()
+	}
+	a.mu.Unlock()
+	if !fresh {
+		a.reload(true)
+	}
+}
+
+// 加载应用时不持有a.mu(应用脚本运行时仍可处理请求)，加载完成后替换并停止原来的应用
+func (a *webApps) reload(onlyModified bool) {
+	a.loadMu.Lock()
+	defer a.loadMu.Unlock()
+	dir := appDirPath()
+	modTime := appsModTime(dir)
+	a.mu.Lock()
+	first := a.apps == nil
+	a.mu.Unlock()
+	if onlyModified && !first {
+		if !modTime.After(a.loaded) {
+			return
+		}
+		log.Println("Web应用脚本已修改，重新加载", dir)
+	}
+
+	apps := make(map[string]*webApp)
+	files, _ := filepath.Glob(filepath.Join(dir, "*.js"))
+	sort.Strings(files)
+	for _, file := range files {
+		app, err := loadApp(file)
+		if err != nil {
+			log.Printf("[应用] 加载%s出错: %v\n", filepath.Base(file), err)
+			continue
+		}
+		apps[app.name] = app
+		log.Printf("加载Web应用 %s%s/，路由%d个\n", AppPrefix, app.name, app.routes)
+	}
+
+	a.mu.Lock()
+	old := a.apps
+	a.apps, a.loaded, a.checked = apps, modTime, time.Now()
+	a.mu.Unlock()
+	for _, app := range old {
+		app.stop()
+	}
+}
+
+// 加载应用脚本，启动应用的事件循环
+func loadApp(file string) (*webApp, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	perms, err := ScriptPermissions(string(content), file)
+	if err != nil {
+		log.Error("脚本权限声明有误，使用默认权限:", err)
+	}
+	if perms == nil {
+		perms = DefaultPermissions[ContextApp]
+	}
+
+	app := &webApp{
+		name: strings.TrimSuffix(filepath.Base(file), ".js"),
+		file: file,
+		mux:  http.NewServeMux(),
+	}
+	app.ctx, app.cancel = context.WithCancel(context.Background())
+	app.engine = app.newEngine()
+	app.engine.sandbox.grant(filepath.Base(file), perms)
+	app.engine.loop.Start()
+
+	err = app.call(func(rt *goja.Runtime) error {
+		_, err := rt.RunString("(function() {" + string(content) + "\n})()")
+		return err
+	})
+	if err != nil {
+		app.stop()
+		return nil, err
+	}
+	return app, nil
+}
+
+// 应用使用的VM(不放入vmPool)，全局变量和定时器在多次请求间保留
+func (app *webApp) newEngine() *Engine {
+	eng := NewEngine(nil)
+	enableRequire(eng.Runtime)
+	console.Enable(eng.Runtime)
+
+	botMap := eng.sandbox.guardBotMap(BotfuncMap)
+	bindStorage(eng.Runtime, botMap)
+	RegisterBotMap(eng.Runtime, botMap)
+	eng.Runtime.Set("app", app.object(eng.Runtime))
+	guardTimers(eng.Runtime, ContextApp, func(err error) {
+		log.Println("[应用] 定时器回调出错:", err)
+	})
+	return eng
+}
+
+// 卸载应用：结束未完成的请求，停止事件循环
+func (app *webApp) stop() {
+	app.cancel()
+	app.engine.Runtime.Interrupt(errAppStopped) //中断可能正在执行的死循环，否则Terminate会一直等待
+	app.engine.loop.Terminate()
+	app.engine.closeStreams()
+	sandboxes.Delete(app.engine.Runtime)
+}
+
+// call 在应用的事件循环中执行fn并等待返回(不等待定时器和Promise)，超过时间限制时中断脚本
+func (app *webApp) call(fn func(rt *goja.Runtime) error) error {
+	limit := timeoutOf(ContextApp)
+	w := startWatchdog(app.engine.Runtime, limit, nil)
+	done := make(chan error, 1)
+	ok := app.engine.loop.RunOnLoop(func(rt *goja.Runtime) {
+		var err error
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("%v", r)
+				}
+			}()
+			err = fn(rt)
+		}()
+		if w.stop() {
+			rt.ClearInterrupt()
+			var ie *goja.InterruptedError
+			if errors.As(err, &ie) {
+				err = &TimeoutError{Limit: limit}
+			}
+		}
+		done <- err
+	})
+	if !ok {
+		w.stop()
+		return errAppStopped
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-app.ctx.Done():
+		return errAppStopped
+	}
+}
+
+// 定时器回调也有执行时间限制(context类型的限制)，出错时交给report(事件循环会忽略定时器回调的错误)
+func guardTimers(rt *goja.Runtime, context string, report func(err error)) {
+	guard := func(call goja.FunctionCall) goja.Value {
+		fn, ok := goja.AssertFunction(call.Argument(0))
+		if !ok {
+			return call.Argument(0)
+		}
+		return rt.ToValue(func(c goja.FunctionCall) goja.Value {
+			limit := timeoutOf(context)
+			w := startWatchdog(rt, limit, nil)
+			_, err := fn(c.This, c.Arguments...)
+			if w.stop() {
+				rt.ClearInterrupt()
+				var ie *goja.InterruptedError
+				if errors.As(err, &ie) {
+					err = &TimeoutError{Limit: limit}
+				}
+			}
+			if err != nil {
+				report(err)
+			}
+			return goja.Undefined()
+		})
+	}
+	wrap, err := rt.RunString(`(function (guard) {
+		['setTimeout', 'setInterval', 'setImmediate'].forEach(function (name) {
+			var set = globalThis[name];
+			if (typeof set !== 'function') return;
+			globalThis[name] = function (fn) {
+				var args = Array.prototype.slice.call(arguments);
+				args[0] = guard(fn);
+				return set.apply(globalThis, args);
+			};
+		});
+	})`)
+	if err != nil {
+		log.Error("guardTimers:", err)
+		return
+	}
+	if f, ok := goja.AssertFunction(wrap); ok {
+		f(nil, rt.ToValue(guard))
+	}
+}
+
+// ------------------------------
+// JS 中的 app 对象
+// ------------------------------
+
+func (app *webApp) object(rt *goja.Runtime) map[string]interface{} {
+	route := func(method string) func(call goja.FunctionCall) goja.Value {
+		return func(call goja.FunctionCall) goja.Value {
+			app.route(rt, method, call.Argument(0).String(), call.Argument(1), false)
+			return goja.Undefined()
+		}
+	}
+	return map[string]interface{}{
+		"name":   app.name,
+		"path":   AppPrefix + app.name,
+		"get":    route(http.MethodGet),
+		"post":   route(http.MethodPost),
+		"put":    route(http.MethodPut),
+		"delete": route(http.MethodDelete),
+		"all":    route(""),
+		// app.route('PATCH', '/items/{id}', fn)
+		"route": func(call goja.FunctionCall) goja.Value {
+			app.route(rt, strings.ToUpper(call.Argument(0).String()), call.Argument(1).String(), call.Argument(2), false)
+			return goja.Undefined()
+		},
+		// app.sse('/events', fn) 即 app.get，响应头设为 text/event-stream
+		"sse": func(call goja.FunctionCall) goja.Value {
+			app.route(rt, http.MethodGet, call.Argument(0).String(), call.Argument(1), true)
+			return goja.Undefined()
+		},
+		// app.ws('/chat', function(ws, req) {...})
+		"ws": func(call goja.FunctionCall) goja.Value {
+			fn, ok := goja.AssertFunction(call.Argument(1))
+			if !ok {
+				panic(rt.NewTypeError("app.ws: 第二个参数须为函数"))
+			}
+			path := call.Argument(0).String()
+			app.handle(rt, http.MethodGet, path, app.serveWebSocket(fn, routeParams(path)))
+			return goja.Undefined()
+		},
+		// app.static('/', 'www') 目录相对 scripts/apps/{name}，省略时为该目录
+		"static": func(call goja.FunctionCall) goja.Value {
+			prefix := strings.TrimSuffix(call.Argument(0).String(), "/") + "/"
+			dir := filepath.Join(appDirPath(), app.name)
+			if sub := call.Argument(1); !goja.IsUndefined(sub) && !goja.IsNull(sub) {
+				dir = filepath.Join(dir, filepath.Clean("/"+sub.String()))
+			}
+			strip := strings.TrimSuffix(AppPrefix+app.name+prefix, "/")
+			app.handle(rt, http.MethodGet, prefix, http.StripPrefix(strip, http.FileServer(http.Dir(dir))))
+			return goja.Undefined()
+		},
+	}
+}
+
+// 路由中的参数名
+func routeParams(path string) []string {
+	var names []string
+	for _, m := range routeParam.FindAllStringSubmatch(path, -1) {
+		names = append(names, m[1])
+	}
+	return names
+}
+
+func (app *webApp) route(rt *goja.Runtime, method, path string, handler goja.Value, sse bool) {
+	fn, ok := goja.AssertFunction(handler)
+	if !ok {
+		panic(rt.NewTypeError("app路由: 处理函数须为函数 " + path))
+	}
+	app.handle(rt, method, path, app.serveRequest(fn, routeParams(path), sse))
+}
+
+// 注册到应用的路由，路由有误(如重复)时抛出JS异常
+func (app *webApp) handle(rt *goja.Runtime, method, path string, h http.Handler) {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	pattern := AppPrefix + app.name + path
+	if method != "" {
+		pattern = method + " " + pattern
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			panic(rt.NewTypeError(fmt.Sprintf("app路由有误 %s: %v", pattern, r)))
+		}
+	}()
+	app.mux.Handle(pattern, h)
+	app.routes++
+}
+
+func (app *webApp) request(r *http.Request, params []string) (*JSRequest, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxAppBody))
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(params))
+	for _, name := range params {
+		values[name] = r.PathValue(name)
+	}
+	return &JSRequest{req: r, params: values, body: string(body), parsed: true}, nil
+}
+
+// 处理HTTP请求：调用处理函数后等待响应完成(res.send/json/end)、客户端断开或超时
+func (app *webApp) serveRequest(fn goja.Callable, params []string, sse bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jsReq, err := app.request(r, params)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		res := newAppResponse(w, r)
+		if sse {
+			res.startEvents()
+		}
+		err = app.call(func(rt *goja.Runtime) error {
+			_, err := fn(nil, rt.ToValue(jsReq.object()), rt.ToValue(res.object()))
+			return err
+		})
+		if err != nil {
+			log.Printf("[应用] %s %s 出错: %v\n", r.Method, r.URL.Path, err)
+			status := http.StatusInternalServerError
+			if IsTimeout(err) {
+				status = http.StatusGatewayTimeout
+			}
+			res.close("JS 执行失败: "+err.Error(), status)
+			app.closed(&res.onClose)
+			return
+		}
+		res.wait(r.Context(), app.ctx, timeoutOf(ContextApp))
+		app.closed(&res.onClose)
+	}
+}
+
+// 调用连接关闭的回调(回调列表只在事件循环中访问)
+func (app *webApp) closed(handlers *[]goja.Callable) {
+	app.call(func(rt *goja.Runtime) error {
+		for _, fn := range *handlers {
+			if _, err := fn(nil); err != nil {
+				log.Println("[应用] onClose出错:", err)
+			}
+		}
+		return nil
+	})
+}
+
+// ------------------------------
+// JS 中的 res 对象(支持流式输出)
+// ------------------------------
+
+type appResponse struct {
+	base      *JSResponse
+	mu        sync.Mutex
+	done      chan struct{} //已发送完整响应(send/json/redirect/end)
+	finished  bool
+	closed    bool //请求已结束，不能再写
+	streaming bool
+	onClose   []goja.Callable //只在事件循环中访问
+}
+
+func newAppResponse(w http.ResponseWriter, r *http.Request) *appResponse {
+	return &appResponse{
+		base: &JSResponse{w: w, req: r},
+		done: make(chan struct{}),
+	}
+}
+
+func (res *appResponse) object() map[string]interface{} {
+	return map[string]interface{}{
+		"status":   res.status,   // JS: res.status(200)
+		"set":      res.set,      // JS: res.set('key', 'val')
+		"send":     res.send,     // JS: res.send('text')
+		"json":     res.json,     // JS: res.json({})
+		"redirect": res.redirect, // JS: res.redirect('/url')
+		"write":    res.write,    // JS: res.write('chunk') 分块输出，最后调用 res.end()
+		"event":    res.event,    // JS: res.event(data, name) 发送SSE事件
+		"end":      res.end,      // JS: res.end() 结束响应
+		"onClose":  res.addOnClose,
+	}
+}
+
+func (res *appResponse) status(code int) {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	// 直接设置状态码，不用 JSResponse.Status(会加上 X-Status-Code 头)
+	if !res.closed && !res.base.wrote && !res.base.redirect {
+		res.base.status = code
+	}
+}
+
+func (res *appResponse) set(key, value string) {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	if !res.closed {
+		res.base.Set(key, value)
+	}
+}
+
+func (res *appResponse) send(body string) error {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	if res.closed {
+		return nil
+	}
+	defer res.finish()
+	return res.base.Send(body)
+}
+
+func (res *appResponse) json(data interface{}) error {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	if res.closed {
+		return nil
+	}
+	defer res.finish()
+	return res.base.Json(data)
+}
+
+func (res *appResponse) redirect(url string) {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	if res.closed {
+		return
+	}
+	res.base.Redirect(url)
+	res.finish()
+}
+
+// 开始流式输出(发送响应头，取消写超时)，调用时须持有锁
+func (res *appResponse) startStream() {
+	if res.streaming {
+		return
+	}
+	res.streaming = true
+	if res.base.status == 0 {
+		res.base.status = http.StatusOK
+	}
+	http.NewResponseController(res.base.w).SetWriteDeadline(time.Time{})
+	res.base.w.WriteHeader(res.base.status)
+	res.base.wrote = true
+}
+
+// 设置SSE响应头
+func (res *appResponse) startEvents() {
+	res.base.w.Header().Set("Content-Type", "text/event-stream")
+	res.base.w.Header().Set("Cache-Control", "no-cache")
+	res.base.w.Header().Set("X-Accel-Buffering", "no")
+}
+
+func (res *appResponse) flush(data string) bool {
+	if _, err := io.WriteString(res.base.w, data); err != nil {
+		return false
+	}
+	http.NewResponseController(res.base.w).Flush()
+	return true
+}
+
+// write 分块输出，连接已关闭时返回false
+func (res *appResponse) write(chunk string) bool {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	if res.closed || res.finished || (res.base.wrote && !res.streaming) {
+		return false
+	}
+	if res.base.w.Header().Get("Content-Type") == "" {
+		res.base.w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	res.startStream()
+	return res.flush(chunk)
+}
+
+// event 发送SSE事件，data不是字符串时转为JSON，连接已关闭时返回false
+func (res *appResponse) event(data interface{}, name string) bool {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	if res.closed || res.finished || (res.base.wrote && !res.streaming) {
+		return false
+	}
+	if !res.streaming {
+		res.startEvents()
+	}
+	res.startStream()
+	text, ok := data.(string)
+	if !ok {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return false
+		}
+		text = string(b)
+	}
+	var sb strings.Builder
+	if name != "" {
+		sb.WriteString("event: " + name + "\n")
+	}
+	for _, line := range strings.Split(text, "\n") {
+		sb.WriteString("data: " + line + "\n")
+	}
+	sb.WriteString("\n")
+	return res.flush(sb.String())
+}
+
+func (res *appResponse) end() {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	if !res.closed && !res.base.wrote && !res.base.redirect {
+		res.startStream()
+	}
+	res.finish()
+}
+
+func (res *appResponse) addOnClose(call goja.FunctionCall) goja.Value {
+	if fn, ok := goja.AssertFunction(call.Argument(0)); ok {
+		res.onClose = append(res.onClose, fn)
+	}
+	return goja.Undefined()
+}
+
+// 调用时须持有锁
+func (res *appResponse) finish() {
+	if !res.finished {
+		res.finished = true
+		close(res.done)
+	}
+}
+
+// wait 等待响应完成或客户端断开；未开始输出时最多等待limit
+func (res *appResponse) wait(client, app context.Context, limit time.Duration) {
+	var timeout <-chan time.Time
+	if limit > 0 {
+		timeout = time.After(limit)
+	}
+	for {
+		select {
+		case <-res.done:
+		case <-client.Done():
+		case <-app.Done():
+		case <-timeout:
+			res.mu.Lock()
+			streaming := res.streaming
+			res.mu.Unlock()
+			if streaming {
+				timeout = nil
+				continue
+			}
+			res.close("JS 执行超时: 处理函数没有发送响应", http.StatusGatewayTimeout)
+			return
+		}
+		res.close("", 0)
+		return
+	}
+}
+
+// close 结束请求，还没有输出时发送错误信息
+func (res *appResponse) close(message string, status int) {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	if res.closed {
+		return
+	}
+	if message != "" && !res.base.wrote && !res.base.redirect {
+		http.Error(res.base.w, message, status)
+	}
+	res.closed = true
+	res.finish()
+}
+
+// ------------------------------
+// JS 中的 ws 对象
+// ------------------------------
+
+type appSocket struct {
+	conn      *websocket.Conn
+	mu        sync.Mutex
+	onMessage []goja.Callable //只在事件循环中访问
+	onClose   []goja.Callable
+}
+
+func (ws *appSocket) object() map[string]interface{} {
+	return map[string]interface{}{
+		"send":  ws.send,  // JS: ws.send('text') 或 ws.send({...}) 发送JSON
+		"close": ws.close, // JS: ws.close()
+		"onMessage": func(call goja.FunctionCall) goja.Value {
+			if fn, ok := goja.AssertFunction(call.Argument(0)); ok {
+				ws.onMessage = append(ws.onMessage, fn)
+			}
+			return goja.Undefined()
+		},
+		"onClose": func(call goja.FunctionCall) goja.Value {
+			if fn, ok := goja.AssertFunction(call.Argument(0)); ok {
+				ws.onClose = append(ws.onClose, fn)
+			}
+			return goja.Undefined()
+		},
+	}
+}
+
+// send 发送文本消息，data不是字符串时转为JSON，发送失败返回false
+func (ws *appSocket) send(data interface{}) bool {
+	text, ok := data.(string)
+	if !ok {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return false
+		}
+		text = string(b)
+	}
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return ws.conn.WriteMessage(websocket.TextMessage, []byte(text)) == nil
+}
+
+func (ws *appSocket) close() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	ws.conn.Close()
+}
+
+// 处理websocket连接：调用处理函数后，收到的每条消息交给 ws.onMessage 的回调
+func (app *webApp) serveWebSocket(fn goja.Callable, params []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jsReq, err := app.request(r, params)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		conn, err := appUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Printf("[应用] 升级WS连接失败：%v\n", err)
+			return
+		}
+		ws := &appSocket{conn: conn}
+		defer conn.Close()
+		conn.SetReadLimit(maxAppBody)
+
+		err = app.call(func(rt *goja.Runtime) error {
+			_, err := fn(nil, rt.ToValue(ws.object()), rt.ToValue(jsReq.object()))
+			return err
+		})
+		if err != nil {
+			log.Printf("[应用] %s 出错: %v\n", r.URL.Path, err)
+			ws.close()
+			return
+		}
+
+		stop := context.AfterFunc(app.ctx, ws.close)
+		defer stop()
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				break
+			}
+			err = app.call(func(rt *goja.Runtime) error {
+				for _, fn := range ws.onMessage {
+					if _, err := fn(nil, rt.ToValue(string(msg))); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if errors.Is(err, errAppStopped) {
+				break
+			}
+			if err != nil {
+				log.Printf("[应用] %s onMessage出错: %v\n", r.URL.Path, err)
+			}
+		}
+		app.closed(&ws.onClose)
+	}
+}
@@ -0,0 +1,163 @@
+package jsengine
+
+import (
+	"ninego/log"
+
+	"github.com/dop251/goja"
+)
+
+/*
+池中VM的隔离
+	VM初始化完成后记录全局对象、内置构造函数及其prototype的属性快照，每次运行结束后恢复：
+	删除脚本新增的全局变量、还原被修改的内置对象、取消未执行的定时器。
+	var/function 声明的全局变量不能删除，恢复为undefined。
+	只有 bot.storage(global) 中的数据在多次运行间保留。
+	无法恢复(如冻结了内置对象、定义了不可配置的属性)时丢弃该VM。
+*/
+
+// 定时器跟踪和快照恢复，只使用初始化时保存的内置函数，不受脚本修改的影响
+const baselineScript = `(function () {
+	var G = globalThis;
+	var ownKeys = Reflect.ownKeys, deleteProp = Reflect.deleteProperty, apply = Reflect.apply;
+	var getDesc = Object.getOwnPropertyDescriptor, defineProp = Object.defineProperty;
+	var getProto = Object.getPrototypeOf, setProto = Object.setPrototypeOf;
+	var isExtensible = Object.isExtensible, create = Object.create;
+	var hasOwn = Function.prototype.call.bind(Object.prototype.hasOwnProperty);
+
+	// 跟踪定时器，运行结束后取消
+	var timers = [];
+	function track(set, clear) {
+		if (typeof set !== 'function' || typeof clear !== 'function') return;
+		return function () {
+			var handle = apply(set, G, arguments);
+			timers[timers.length] = [handle, clear];
+			return handle;
+		};
+	}
+	[['setTimeout', 'clearTimeout'], ['setInterval', 'clearInterval'], ['setImmediate', 'clearImmediate']].forEach(function (p) {
+		var wrapped = track(G[p[0]], G[p[1]]);
+		if (wrapped) G[p[0]] = wrapped;
+	});
+
+	function copyDesc(d) {
+		var c = create(null);
+		if (hasOwn(d, 'value')) {
+			c.value = d.value;
+			c.writable = d.writable;
+		} else {
+			c.get = d.get;
+			c.set = d.set;
+		}
+		c.enumerable = d.enumerable;
+		c.configurable = d.configurable;
+		return c;
+	}
+	function sameDesc(a, b) {
+		return (a.value === b.value || (a.value !== a.value && b.value !== b.value)) &&
+			a.writable === b.writable && a.get === b.get && a.set === b.set &&
+			a.enumerable === b.enumerable && a.configurable === b.configurable;
+	}
+
+	function snapshot(o) {
+		var keys = ownKeys(o), index = create(null), descs = [];
+		for (var i = 0; i < keys.length; i++) {
+			index[keys[i]] = true;
+			descs[i] = copyDesc(getDesc(o, keys[i]));
+			// Go对象的字段每次读取都返回新值，不做比较
+			if (!sameDesc(getDesc(o, keys[i]), descs[i])) descs[i] = null;
+		}
+		return { obj: o, proto: getProto(o), extensible: isExtensible(o), keys: keys, index: index, descs: descs };
+	}
+
+	function remove(o, k) {
+		if (deleteProp(o, k)) return true;
+		var d = getDesc(o, k);
+		if (d && hasOwn(d, 'value') && d.writable) {
+			o[k] = undefined;
+			return true;
+		}
+		return false;
+	}
+
+	function restore(s) {
+		var o = s.obj, ok = true, i;
+		if (getProto(o) !== s.proto) {
+			try { setProto(o, s.proto); } catch (e) { ok = false; }
+		}
+		var keys = ownKeys(o);
+		for (i = 0; i < keys.length; i++) {
+			if (!s.index[keys[i]] && !remove(o, keys[i])) ok = false;
+		}
+		for (i = 0; i < s.keys.length; i++) {
+			if (!s.descs[i]) continue;
+			var d = getDesc(o, s.keys[i]);
+			if (d && sameDesc(d, s.descs[i])) continue;
+			try { defineProp(o, s.keys[i], s.descs[i]); } catch (e) { ok = false; }
+		}
+		return ok && isExtensible(o) === s.extensible;
+	}
+
+	var seen = [], snapshots = [];
+	function add(o) {
+		if (o === null || (typeof o !== 'object' && typeof o !== 'function')) return;
+		for (var i = 0; i < seen.length; i++) if (seen[i] === o) return;
+		seen[seen.length] = o;
+		try { snapshots[snapshots.length] = snapshot(o); } catch (e) { }
+	}
+	// 最先恢复Object.prototype，之后比较属性描述符时不受脚本添加的继承属性影响
+	add(Object.prototype);
+	add(Function.prototype);
+	add(G);
+	var globals = ownKeys(G);
+	for (var i = 0; i < globals.length; i++) {
+		var d = getDesc(G, globals[i]);
+		if (!d || !hasOwn(d, 'value')) continue;
+		add(d.value);
+		if (typeof d.value === 'function') {
+			var p = getDesc(d.value, 'prototype');
+			if (p && hasOwn(p, 'value')) add(p.value);
+		}
+	}
+
+	return function () {
+		var ok = true, i;
+		for (i = 0; i < timers.length; i++) timers[i][1](timers[i][0]);
+		timers = [];
+		for (i = 0; i < snapshots.length; i++) {
+			if (!restore(snapshots[i])) ok = false;
+		}
+		return ok;
+	};
+})()`
+
+// snapshotBaseline 记录VM的初始状态，返回恢复函数
+func snapshotBaseline(e *Engine) (reset goja.Callable) {
+	e.loop.Run(func(runtime *goja.Runtime) {
+		value, err := runtime.RunString(baselineScript)
+		if err != nil {
+			log.Error("记录VM初始状态失败:", err)
+			return
+		}
+		reset, _ = goja.AssertFunction(value)
+	})
+	return
+}
+
+// resetState 恢复到初始状态，返回false时VM不能再使用
+func (e *Engine) resetState() (ok bool) {
+	if e.resetModules != nil {
+		e.resetModules()
+	}
+	if e.reset == nil {
+		return true
+	}
+	e.loop.Run(func(runtime *goja.Runtime) {
+		value, err := e.reset(nil)
+		if err != nil {
+			log.Error("恢复VM初始状态失败:", err)
+			return
+		}
+		ok = value.ToBoolean()
+	})
+	return
+}
@@ -58,6 +58,13 @@ func adaptToGoja(rt *goja.Runtime, fn interface{}) goja.Value {
 			case reflect.Int:
 				// 转为 int
 				goVal = reflect.ValueOf(int(argVal.ToFloat()))
+			case reflect.Interface:
+				// 任意类型(数组转为[]interface{}, 对象转为map[string]interface{})
+				if exported := argVal.Export(); exported != nil {
+					goVal = reflect.ValueOf(exported)
+				} else {
+					goVal = reflect.Zero(paramType)
+				}
 			default:
 				// 直接返回错误字符串
 				return rt.ToValue("不支持的参数类型: " + paramType.Kind().String())
@@ -1,22 +1,72 @@
 package console
 
 import (
+	"encoding/json"
 	"fmt"
 	"ninego/log"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/dop251/goja"
 )
 
+// Output 接收console的输出(如网页调试控制台)，source为调用位置(文件:行号)
+type Output func(level, source, text string)
+
 type Console struct {
 	timeMtx sync.Mutex
 	timeMap map[string]time.Time
+	vm      *goja.Runtime
+	output  Output
 }
 
 func (c *Console) log(args ...any) {
 	//fmt.Println(args...)
 	log.Println(args...)
+	c.emit("log", args...)
+}
+
+// 按级别输出的console方法(log/info/warn/error...)
+func (c *Console) logger(level string) func(args ...any) {
+	return func(args ...any) {
+		log.Println(args...)
+		c.emit(level, args...)
+	}
+}
+
+// 发送到output，对象转为JSON
+func (c *Console) emit(level string, args ...any) {
+	if c.output == nil {
+		return
+	}
+	parts := make([]string, 0, len(args))
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case string:
+			parts = append(parts, v)
+		case map[string]any, []any:
+			data, err := json.Marshal(v)
+			if err != nil {
+				parts = append(parts, fmt.Sprint(v))
+			} else {
+				parts = append(parts, string(data))
+			}
+		default:
+			parts = append(parts, fmt.Sprint(v))
+		}
+	}
+	c.output(level, c.source(), strings.Join(parts, " "))
+}
+
+// 调用console的脚本位置
+func (c *Console) source() string {
+	for _, frame := range c.vm.CaptureCallStack(10, nil) {
+		if pos := frame.Position(); pos.Line > 0 {
+			return fmt.Sprintf("%s:%d", frame.SrcName(), pos.Line)
+		}
+	}
+	return ""
 }
 
 func (c *Console) time(key string) {
@@ -40,6 +90,7 @@ func (c *Console) timeEnd(key string) {
 	delete(c.timeMap, key)
 	elapsed := time.Since(start)
 	fmt.Printf("%s: %v\n", key, elapsed)
+	c.emit("log", fmt.Sprintf("%s: %v", key, elapsed))
 }
 
 func (c *Console) Inject(vm *goja.Runtime) error {
@@ -50,19 +101,19 @@ func (c *Console) Inject(vm *goja.Runtime) error {
 	if err := consoleObj.Set("log", c.log); err != nil {
 		return err
 	}
-	if err := consoleObj.Set("trace", c.log); err != nil {
+	if err := consoleObj.Set("trace", c.logger("trace")); err != nil {
 		return err
 	}
-	if err := consoleObj.Set("debug", c.log); err != nil {
+	if err := consoleObj.Set("debug", c.logger("debug")); err != nil {
 		return err
 	}
-	if err := consoleObj.Set("info", c.log); err != nil {
+	if err := consoleObj.Set("info", c.logger("info")); err != nil {
 		return err
 	}
-	if err := consoleObj.Set("warn", c.log); err != nil {
+	if err := consoleObj.Set("warn", c.logger("warn")); err != nil {
 		return err
 	}
-	if err := consoleObj.Set("error", c.log); err != nil {
+	if err := consoleObj.Set("error", c.logger("error")); err != nil {
 		return err
 	}
 	if err := consoleObj.Set("time", c.time); err != nil {
@@ -78,8 +129,15 @@ func (c *Console) Inject(vm *goja.Runtime) error {
 }
 
 func Enable(vm *goja.Runtime) error {
+	return EnableOutput(vm, nil)
+}
+
+// EnableOutput 注入console，输出同时发送到out(为nil时只记录日志)
+func EnableOutput(vm *goja.Runtime, out Output) error {
 	console := &Console{
 		timeMap: make(map[string]time.Time),
+		vm:      vm,
+		output:  out,
 	}
 	return console.Inject(vm)
 }
@@ -2,103 +2,216 @@ package jsengine
 
 import (
 	"encoding/json"
-	"io/ioutil"
-	"os"
-	"sync"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/dop251/goja"
 )
 
+// bot.storage 等脚本数据的存储
+var store = NewStorage("botdata.storage", "botdata.journal")
+
 // 供js中使用的全局对象
-var global = NewSharedData() //map[string]interface{}{}
+var global = store.Namespace(GlobalNamespace) //map[string]interface{}{}
 
+// SaveStorageToFile 保存快照并清空日志(程序退出时调用)
 func SaveStorageToFile() error {
-	if global != nil {
-		// 将map转换为JSON字节切片
-		marshal, err := json.Marshal(global.data)
-		if err != nil {
-			return err
-		}
-		// 保存到文件
-		err = ioutil.WriteFile("botdata.storage", marshal, 0666)
-		if err != nil {
-			return err
-		}
-		//instance = nil
-	}
-	return nil
+	return store.Save()
 }
 
+// LoadStorageFromFile 读取快照和日志，之后每次写入都会立即保存
 func LoadStorageFromFile() error {
-	if global != nil {
-		bytes, err := os.ReadFile("botdata.storage")
-		if err != nil {
-			return err
-		}
-		// 将JSON字节切片转换为map
-		err = json.Unmarshal(bytes, &global.data)
+	err := store.Load() //快照有误时也已打开日志，可继续使用
+	// 初始化值
+	global.Set("runcount", 0)
+	return err
+}
+
+// ScriptStorage 脚本数据的存储(网页管理用)
+func ScriptStorage() *Storage {
+	return store
+}
+
+// SharedData 存储中一个命名空间的读写接口
+type SharedData struct {
+	st *Storage
+	ns string
+}
+
+// 复制值(JSON)，避免脚本修改返回的对象时绕过存储
+func cloneValue(v interface{}) interface{} {
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		data, err := json.Marshal(v)
 		if err != nil {
-			return err
+			return v
 		}
-		// 初始化值
-		global.Set("runcount", 0)
+		var copied interface{}
+		json.Unmarshal(data, &copied)
+		return copied
+	}
+	return v
+}
+
+// 未过期的值(已持有锁)
+func (s *SharedData) entry(key string) *storageEntry {
+	if e := s.st.spaces[s.ns][key]; e != nil && !e.expired(nowMillis()) {
+		return e
 	}
 	return nil
 }
 
-// SharedData 带读写锁的共享数据
-type SharedData struct {
-	mu   sync.RWMutex
-	data map[string]interface{}
+func (s *SharedData) Get(key string) interface{} {
+	v, _ := s.Lookup(key)
+	return v
 }
 
-func NewSharedData() *SharedData {
-	return &SharedData{
-		data: make(map[string]interface{}),
+// Lookup 读取值(副本)，ok表示键存在且未过期
+func (s *SharedData) Lookup(key string) (v interface{}, ok bool) {
+	s.st.mu.RLock()
+	defer s.st.mu.RUnlock()
+	if e := s.entry(key); e != nil {
+		return cloneValue(e.Value), true
 	}
+	return nil, false
 }
 
-func (s *SharedData) Get(key string) interface{} {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	return s.data[key]
+func (s *SharedData) Has(key string) bool {
+	s.st.mu.RLock()
+	defer s.st.mu.RUnlock()
+	return s.entry(key) != nil
 }
 
 func (s *SharedData) Set(key string, val interface{}) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.data[key] = val
+	s.SetTTL(key, val, 0)
+}
+
+// SetTTL 保存值，ttl后过期(<=0为不过期)
+func (s *SharedData) SetTTL(key string, val interface{}, ttl time.Duration) {
+	s.st.mu.Lock()
+	defer s.st.mu.Unlock()
+	s.st.write(&journalRecord{Op: "set", NS: s.ns, Key: key, Value: val, Expire: expireAt(ttl)})
 }
 
 func (s *SharedData) Update(key string, val int) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if i, ok := s.data[key]; ok {
-		switch i.(type) {
+	s.Incr(key, float64(val), 0)
+}
+
+// Incr 数值加delta并返回新值，不存在时从0开始；ttl>0时重新设置过期时间，否则保持原来的过期时间
+func (s *SharedData) Incr(key string, delta float64, ttl time.Duration) (float64, error) {
+	s.st.mu.Lock()
+	defer s.st.mu.Unlock()
+	var n float64
+	var expire int64
+	if e := s.entry(key); e != nil {
+		switch v := e.Value.(type) {
 		case int:
-			s.data[key] = i.(int) + val
+			n = float64(v)
+		case int64:
+			n = float64(v)
 		case float64:
-			s.data[key] = i.(float64) + float64(val)
+			n = v
+		default:
+			return 0, fmt.Errorf("%s 的值不是数字", key)
 		}
-		//s.data[key] += 1
-	} else {
-		s.data[key] = val
+		expire = e.Expire
+	}
+	if ttl > 0 {
+		expire = expireAt(ttl)
 	}
+	n += delta
+	s.st.write(&journalRecord{Op: "set", NS: s.ns, Key: key, Value: n, Expire: expire})
+	return n, nil
+}
+
+// CompareAndSet 当前值与expected相同(按JSON比较，nil表示不存在)时保存val，返回是否保存
+func (s *SharedData) CompareAndSet(key string, expected, val interface{}, ttl time.Duration) bool {
+	s.st.mu.Lock()
+	defer s.st.mu.Unlock()
+	var current interface{}
+	if e := s.entry(key); e != nil {
+		current = e.Value
+	}
+	if !jsonEqual(current, expected) {
+		return false
+	}
+	s.st.write(&journalRecord{Op: "set", NS: s.ns, Key: key, Value: val, Expire: expireAt(ttl)})
+	return true
+}
+
+func jsonEqual(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	da, err1 := json.Marshal(a)
+	db, err2 := json.Marshal(b)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	var va, vb interface{}
+	json.Unmarshal(da, &va)
+	json.Unmarshal(db, &vb)
+	return reflect.DeepEqual(va, vb)
+}
+
+// TTL 剩余的有效时间，不过期时返回-1，不存在时返回-2(同redis)
+func (s *SharedData) TTL(key string) time.Duration {
+	s.st.mu.RLock()
+	defer s.st.mu.RUnlock()
+	e := s.entry(key)
+	switch {
+	case e == nil:
+		return -2
+	case e.Expire == 0:
+		return -1
+	}
+	return time.Duration(e.Expire-nowMillis()) * time.Millisecond
+}
+
+// Expire 修改过期时间(ttl<=0为不过期)，键不存在时返回false
+func (s *SharedData) Expire(key string, ttl time.Duration) bool {
+	s.st.mu.Lock()
+	defer s.st.mu.Unlock()
+	e := s.entry(key)
+	if e == nil {
+		return false
+	}
+	s.st.write(&journalRecord{Op: "set", NS: s.ns, Key: key, Value: e.Value, Expire: expireAt(ttl)})
+	return true
 }
 
 func (s *SharedData) Delete(key string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	delete(s.data, key)
+	s.st.mu.Lock()
+	defer s.st.mu.Unlock()
+	if s.st.spaces[s.ns][key] != nil {
+		s.st.write(&journalRecord{Op: "del", NS: s.ns, Key: key})
+	}
+}
+
+// Clear 删除命名空间中的全部数据
+func (s *SharedData) Clear() {
+	s.st.mu.Lock()
+	defer s.st.mu.Unlock()
+	if s.st.spaces[s.ns] != nil {
+		s.st.write(&journalRecord{Op: "clear", NS: s.ns})
+	}
 }
 
 func (s *SharedData) Keys() []string {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	keys := make([]string, 0, len(s.data))
-	for k := range s.data {
-		keys = append(keys, k)
+	s.st.mu.RLock()
+	defer s.st.mu.RUnlock()
+	now := nowMillis()
+	keys := make([]string, 0, len(s.st.spaces[s.ns]))
+	for k, e := range s.st.spaces[s.ns] {
+		if !e.expired(now) {
+			keys = append(keys, k)
+		}
 	}
+	sort.Strings(keys)
 	return keys
 }
 
@@ -169,3 +282,103 @@ func WrapSharedData(vm *goja.Runtime, sd *SharedData) goja.Value {
 	}
 	return v
 }
+
+// bindStorage 注入 bot.storage(global命名空间) 和 bot.store(namespace)
+func bindStorage(rt *goja.Runtime, botMap map[string]interface{}) {
+	bindStorageOf(rt, botMap, store)
+}
+
+// bindStorageOf 使用指定的存储注入 bot.storage 和 bot.store(脚本测试使用内存中的存储)
+func bindStorageOf(rt *goja.Runtime, botMap map[string]interface{}, st *Storage) {
+	botMap["storage"] = WrapSharedData(rt, st.Namespace(GlobalNamespace))
+	// bot.store() 当前脚本的命名空间(脚本文件名，不含后缀)，bot.store('name') 指定命名空间
+	botMap["store"] = rt.ToValue(func(call goja.FunctionCall) goja.Value {
+		ns := ""
+		if arg := call.Argument(0); !goja.IsUndefined(arg) && !goja.IsNull(arg) {
+			ns = arg.String()
+		} else if sb := sandboxOf(rt); sb != nil {
+			ns = sb.scriptName()
+			ns = strings.TrimSuffix(ns, filepath.Ext(ns))
+		}
+		if ns == "" {
+			ns = GlobalNamespace
+		}
+		return storeObject(rt, st.Namespace(ns))
+	})
+}
+
+// 秒数参数转为时间，未传时为0
+func ttlArg(v goja.Value) time.Duration {
+	if goja.IsUndefined(v) || goja.IsNull(v) {
+		return 0
+	}
+	return time.Duration(v.ToFloat() * float64(time.Second))
+}
+
+// bot.store() 返回的对象
+func storeObject(rt *goja.Runtime, sd *SharedData) *goja.Object {
+	obj := rt.NewObject()
+	obj.Set("namespace", sd.ns)
+	// get(key, defaultValue) 不存在或已过期时返回defaultValue
+	obj.Set("get", func(call goja.FunctionCall) goja.Value {
+		if v, ok := sd.Lookup(call.Argument(0).String()); ok {
+			return rt.ToValue(v)
+		}
+		return call.Argument(1)
+	})
+	// set(key, value, ttl) ttl秒后过期(省略时不过期)，value为undefined时删除
+	obj.Set("set", func(call goja.FunctionCall) goja.Value {
+		key, value := call.Argument(0).String(), call.Argument(1)
+		if goja.IsUndefined(value) {
+			sd.Delete(key)
+		} else {
+			sd.SetTTL(key, value.Export(), ttlArg(call.Argument(2)))
+		}
+		return goja.Undefined()
+	})
+	obj.Set("has", sd.Has)
+	obj.Set("delete", sd.Delete)
+	obj.Set("remove", sd.Delete)
+	obj.Set("keys", sd.Keys)
+	obj.Set("clear", sd.Clear)
+	// all() 全部键值
+	obj.Set("all", func() map[string]interface{} {
+		all := make(map[string]interface{})
+		for _, k := range sd.Keys() {
+			if v, ok := sd.Lookup(k); ok {
+				all[k] = v
+			}
+		}
+		return all
+	})
+	// ttl(key) 剩余秒数，不过期为-1，不存在为-2
+	obj.Set("ttl", func(key string) float64 {
+		ttl := sd.TTL(key)
+		if ttl < 0 {
+			return float64(ttl)
+		}
+		return ttl.Seconds()
+	})
+	// expire(key, ttl) 修改过期时间(ttl省略或<=0为不过期)
+	obj.Set("expire", func(call goja.FunctionCall) goja.Value {
+		return rt.ToValue(sd.Expire(call.Argument(0).String(), ttlArg(call.Argument(1))))
+	})
+	// cas(key, expected, value, ttl) 当前值等于expected(不存在用null)时才保存，返回是否保存
+	obj.Set("cas", func(call goja.FunctionCall) goja.Value {
+		ok := sd.CompareAndSet(call.Argument(0).String(), call.Argument(1).Export(), call.Argument(2).Export(), ttlArg(call.Argument(3)))
+		return rt.ToValue(ok)
+	})
+	// incr(key, delta, ttl) 计数器，delta默认为1，返回新值
+	obj.Set("incr", func(call goja.FunctionCall) goja.Value {
+		delta := 1.0
+		if arg := call.Argument(1); !goja.IsUndefined(arg) && !goja.IsNull(arg) {
+			delta = arg.ToFloat()
+		}
+		n, err := sd.Incr(call.Argument(0).String(), delta, ttlArg(call.Argument(2)))
+		if err != nil {
+			panic(rt.NewTypeError(err.Error()))
+		}
+		return rt.ToValue(n)
+	})
+	return obj
+}
@@ -0,0 +1,285 @@
+package jsengine
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"ninego/log"
+	"xiaobot/jsengine/console"
+
+	"github.com/dop251/goja"
+)
+
+/*
+事件脚本
+	程序目录下 scripts/hooks/*.js 在启动时按文件名顺序加载，文件修改后自动重新加载。
+	脚本中用 bot.on 注册事件处理函数：
+	bot.on('query', function(e) { if (e.query == '开灯') { bot.action('...'); e.handled = true; } }, {priority: 10});
+	priority越大越先执行(默认0)，e.stopPropagation() 或返回false 停止后续处理函数。
+	所有事件脚本在同一个VM中运行(各文件的代码在独立的函数作用域中)，处理函数按所在文件的权限执行。
+*/
+
+// 事件脚本目录(相对程序目录)
+var HookDir = filepath.Join("scripts", "hooks")
+
+// 事件
+const (
+	EventQuery             = "query"              //收到提问 {query}，e.handled=true 不再交给AI
+	EventAnswer            = "answer"             //AI回答后、播放前 {query, answer, spoken}，可改写e.answer(或返回字符串)
+	EventConversationStart = "conversation:start" //开始持续对话 {query}
+	EventConversationEnd   = "conversation:end"   //结束持续对话 {query}
+	EventMusicTrack        = "music:track"        //开始播放一首音乐 {name, path, url, duration}
+	EventScheduleFired     = "schedule:fired"     //定时任务触发 {filename, name}
+	EventStartup           = "startup"            //程序启动 {}
+	EventDialog            = "dialog"             //多轮对话结束 {name, slots, status}，status: done/cancel/timeout/failed
+)
+
+type hookHandler struct {
+	event    string
+	priority int
+	order    int
+	fn       goja.Callable
+	value    goja.Value //用于bot.off比较
+	file     string
+	perms    *Permissions
+}
+
+// HookEvent 事件处理结果，Data为处理函数修改后的事件数据
+type HookEvent struct {
+	Name    string
+	Data    map[string]interface{}
+	Handled bool //处理函数设置了 e.handled = true
+	Stopped bool //处理函数停止了后续处理
+}
+
+type hooks struct {
+	mu       sync.Mutex
+	engine   *Engine
+	handlers []*hookHandler
+	file     string //正在加载/执行的事件脚本
+	perms    *Permissions
+	loaded   time.Time //加载时scripts/hooks的最后修改时间
+	checked  time.Time //上次检查时间
+}
+
+var Hooks = &hooks{}
+
+// 事件脚本目录的绝对路径
+func hookDirPath() string {
+	if filepath.IsAbs(HookDir) {
+		return HookDir
+	}
+	return filepath.Join(GetExecutableDir(), HookDir)
+}
+
+// Emit 按优先级依次调用事件的处理函数，没有事件脚本时直接返回
+func (h *hooks) Emit(name string, data map[string]interface{}) *HookEvent {
+	ev := &HookEvent{Name: name, Data: data}
+	if ev.Data == nil {
+		ev.Data = make(map[string]interface{})
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("Recovered from panic:", r)
+		}
+	}()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.refresh()
+
+	var list []*hookHandler
+	for _, hd := range h.handlers {
+		if hd.event == name {
+			list = append(list, hd)
+		}
+	}
+	if len(list) == 0 {
+		return ev
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].priority != list[j].priority {
+			return list[i].priority > list[j].priority
+		}
+		return list[i].order < list[j].order
+	})
+
+	engine := h.engine
+	rt := engine.Runtime
+	obj := rt.NewObject()
+	for k, v := range ev.Data {
+		obj.Set(k, v)
+	}
+	obj.Set("type", name)
+	obj.Set("handled", false)
+	obj.Set("stopPropagation", func(goja.FunctionCall) goja.Value {
+		ev.Stopped = true
+		return goja.Undefined()
+	})
+
+	for _, hd := range list {
+		h.file, h.perms = hd.file, hd.perms
+		engine.sandbox.grant(filepath.Base(hd.file), hd.perms)
+		result, err := engine.CallFunction(hd.fn, obj)
+		if err != nil {
+			log.Printf("[事件] %s 处理出错(%s): %v\n", name, filepath.Base(hd.file), err)
+		} else if s, ok := result.(string); ok && name == EventAnswer {
+			obj.Set("answer", s)
+		} else if b, ok := result.(bool); ok && !b {
+			ev.Stopped = true
+		}
+		if engine.expired {
+			log.Println("[事件] 事件脚本执行超时，重新加载")
+			h.load()
+			break
+		}
+		if ev.Stopped {
+			break
+		}
+	}
+	h.file, h.perms = "", nil
+	engine.sandbox.grant("", nil)
+
+	for k := range ev.Data {
+		if v := obj.Get(k); v != nil {
+			ev.Data[k] = v.Export()
+		}
+	}
+	ev.Handled = obj.Get("handled").ToBoolean()
+	return ev
+}
+
+// Reload 重新加载事件脚本
+func (h *hooks) Reload() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.load()
+	h.checked = time.Now()
+}
+
+// 首次使用或 scripts/hooks 有修改时重新加载(最多每2秒检查一次)
+func (h *hooks) refresh() {
+	if !h.checked.IsZero() && time.Since(h.checked) < 2*time.Second {
+		return
+	}
+	first := h.checked.IsZero()
+	h.checked = time.Now()
+	if first || libModTime(hookDirPath()).After(h.loaded) {
+		if !first {
+			log.Println("事件脚本已修改，重新加载", hookDirPath())
+		}
+		h.load()
+	}
+}
+
+func (h *hooks) load() {
+	if h.engine != nil {
+		h.engine.loop.Terminate()
+		h.engine.closeStreams()
+		sandboxes.Delete(h.engine.Runtime)
+		h.engine = nil
+	}
+	h.handlers = nil
+
+	dir := hookDirPath()
+	h.loaded = libModTime(dir)
+	files, _ := filepath.Glob(filepath.Join(dir, "*.js"))
+	if len(files) == 0 {
+		return
+	}
+	sort.Strings(files)
+
+	h.engine = h.newEngine()
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			log.Error("读取事件脚本失败:", err)
+			continue
+		}
+		perms, err := ScriptPermissions(string(content), file)
+		if err != nil {
+			log.Error("脚本权限声明有误，使用默认权限:", err)
+		}
+		if perms == nil {
+			perms = DefaultPermissions[ContextHook]
+		}
+		h.file, h.perms = file, perms
+		h.engine.sandbox.grant(filepath.Base(file), perms)
+		if _, err := h.engine.RunString("(function() {" + string(content) + "\n})()"); err != nil {
+			log.Printf("[事件] 加载%s出错: %v\n", filepath.Base(file), err)
+		}
+		if h.engine.expired {
+			log.Printf("[事件] 加载%s超时，停止加载事件脚本\n", filepath.Base(file))
+			h.handlers = nil
+			break
+		}
+	}
+	h.file, h.perms = "", nil
+	h.engine.sandbox.grant("", nil)
+	log.Printf("加载事件脚本%d个，处理函数%d个\n", len(files), len(h.handlers))
+}
+
+// 事件脚本使用的VM(不放入vmPool，处理函数在多次事件间保留)
+func (h *hooks) newEngine() *Engine {
+	eng := NewEngine(nil)
+	eng.timeout = timeoutOf(ContextHook)
+	enableRequire(eng.Runtime)
+	console.Enable(eng.Runtime)
+
+	botMap := eng.sandbox.guardBotMap(BotfuncMap)
+	bindStorage(eng.Runtime, botMap)
+	RegisterBotMap(eng.Runtime, botMap)
+	bot := eng.Runtime.Get("bot").ToObject(eng.Runtime)
+	bot.Set("on", h.on(eng.Runtime))
+	bot.Set("off", h.off)
+	return eng
+}
+
+// bot.on(event, fn, {priority: n}) 或 bot.on(event, fn, n)
+func (h *hooks) on(rt *goja.Runtime) func(call goja.FunctionCall) goja.Value {
+	return func(call goja.FunctionCall) goja.Value {
+		event := call.Argument(0).String()
+		fn, ok := goja.AssertFunction(call.Argument(1))
+		if !ok {
+			panic(rt.NewTypeError("bot.on: 第二个参数须为函数"))
+		}
+		priority := 0
+		if opt := call.Argument(2); !goja.IsUndefined(opt) && !goja.IsNull(opt) {
+			if o, ok := opt.(*goja.Object); ok {
+				if p := o.Get("priority"); p != nil {
+					priority = int(p.ToInteger())
+				}
+			} else {
+				priority = int(opt.ToInteger())
+			}
+		}
+		h.handlers = append(h.handlers, &hookHandler{
+			event:    event,
+			priority: priority,
+			order:    len(h.handlers),
+			fn:       fn,
+			value:    call.Argument(1),
+			file:     h.file,
+			perms:    h.perms,
+		})
+		return goja.Undefined()
+	}
+}
+
+// bot.off(event) 删除事件的全部处理函数，bot.off(event, fn) 删除指定的处理函数
+func (h *hooks) off(call goja.FunctionCall) goja.Value {
+	event := call.Argument(0).String()
+	fn := call.Argument(1)
+	handlers := h.handlers[:0]
+	for _, hd := range h.handlers {
+		if hd.event == event && (goja.IsUndefined(fn) || hd.value.SameAs(fn)) {
+			continue
+		}
+		handlers = append(handlers, hd)
+	}
+	h.handlers = handlers
+	return goja.Undefined()
+}
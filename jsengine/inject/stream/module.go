@@ -0,0 +1,488 @@
+package stream
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ninego/log"
+
+	"github.com/GopeedLab/gopeed/pkg/download/engine/util"
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/eventloop"
+	"github.com/gorilla/websocket"
+)
+
+/*
+流式网络访问，在事件循环中异步执行：
+	fetch()          响应的body为ReadableStream，可用getReader().read()逐块读取(如SSE)
+	new WebSocket()  兼容浏览器的WebSocket客户端
+网络操作在独立的goroutine中进行，结果通过RunOnLoop回到事件循环；
+请求或连接未结束时保持事件循环运行(同setInterval)，VM回收时由closeAll关闭。
+*/
+
+//go:embed stream.js
+var shimScript string
+
+const (
+	chunkSize    = 32 * 1024
+	writeTimeout = 10 * time.Second
+	closeTimeout = 3 * time.Second
+	keepAlive    = 24 * 60 * 60 * 1000 //保持事件循环运行的空定时器间隔(毫秒)
+)
+
+type module struct {
+	runtime   *goja.Runtime
+	loop      *eventloop.EventLoop
+	transport *http.Transport
+	proxy     func(r *http.Request) (*url.URL, error)
+	allowHost func(host string) bool
+
+	setInterval   goja.Callable
+	clearInterval goja.Callable
+	noop          goja.Value
+
+	handles map[*handle]struct{} //未结束的请求和连接(只在事件循环中访问)
+}
+
+// 未结束的请求或连接
+type handle struct {
+	m      *module
+	ref    goja.Value //保持事件循环运行的定时器
+	done   bool
+	once   sync.Once
+	closer func()
+}
+
+// Enable 注入流式fetch和WebSocket(须在fetch polyfill之后调用)，allowHost为访问主机的权限检查(为nil时不限制)
+// 返回的closeAll关闭VM中未结束的请求和连接，须在事件循环停止后调用
+func Enable(runtime *goja.Runtime, loop *eventloop.EventLoop, proxyHandler func(r *http.Request) (*url.URL, error), allowHost func(host string) bool) (closeAll func(), err error) {
+	if proxyHandler == nil {
+		proxyHandler = http.ProxyFromEnvironment
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxyHandler
+	m := &module{
+		runtime:   runtime,
+		loop:      loop,
+		transport: transport,
+		proxy:     proxyHandler,
+		allowHost: allowHost,
+		handles:   make(map[*handle]struct{}),
+	}
+	var ok bool
+	if m.setInterval, ok = goja.AssertFunction(runtime.Get("setInterval")); !ok {
+		return nil, errors.New("setInterval is not defined")
+	}
+	if m.clearInterval, ok = goja.AssertFunction(runtime.Get("clearInterval")); !ok {
+		return nil, errors.New("clearInterval is not defined")
+	}
+	m.noop = runtime.ToValue(func() {})
+
+	native := runtime.NewObject()
+	native.Set("fetch", m.fetch)
+	native.Set("connect", m.connect)
+	native.Set("utf8", func(buf goja.ArrayBuffer) string {
+		return string(buf.Bytes())
+	})
+	shim, err := runtime.RunString(shimScript)
+	if err != nil {
+		return nil, err
+	}
+	fn, ok := goja.AssertFunction(shim)
+	if !ok {
+		return nil, errors.New("stream shim is not a function")
+	}
+	if _, err := fn(nil, native); err != nil {
+		return nil, err
+	}
+	return m.closeAll, nil
+}
+
+// 检查是否允许访问该地址的主机
+func (m *module) allowed(u *url.URL) bool {
+	return m.allowHost == nil || m.allowHost(u.Hostname())
+}
+
+// 登记请求或连接(在事件循环中调用)
+func (m *module) open(closer func()) *handle {
+	h := &handle{m: m, closer: closer}
+	m.handles[h] = struct{}{}
+	return h
+}
+
+// hold 保持事件循环运行，直到unhold或release
+func (h *handle) hold() {
+	if h.ref == nil && !h.done {
+		h.ref, _ = h.m.setInterval(nil, h.m.noop, h.m.runtime.ToValue(keepAlive))
+	}
+}
+
+func (h *handle) unhold() {
+	if h.ref != nil {
+		h.m.clearInterval(nil, h.ref)
+		h.ref = nil
+	}
+}
+
+// release 请求或连接已结束(在事件循环中调用)
+func (h *handle) release() {
+	h.unhold()
+	h.done = true
+	delete(h.m.handles, h)
+}
+
+// close 关闭底层连接，可在任意goroutine中多次调用
+func (h *handle) close() {
+	h.once.Do(h.closer)
+}
+
+// post 在事件循环中执行fn(handle已结束时跳过)，事件循环已停止时关闭连接并返回false
+func (h *handle) post(fn func() error) bool {
+	ok := h.m.loop.RunOnLoop(func(*goja.Runtime) {
+		if h.done {
+			return
+		}
+		if err := fn(); err != nil {
+			log.Println("[stream] 回调出错:", err)
+		}
+	})
+	if !ok {
+		h.close()
+	}
+	return ok
+}
+
+func (m *module) closeAll() {
+	for h := range m.handles {
+		h.release()
+		h.close()
+	}
+}
+
+// 回调的错误参数
+func (m *module) errorValue(err error) goja.Value {
+	if err == nil {
+		return goja.Null()
+	}
+	return m.runtime.ToValue(err.Error())
+}
+
+// ------------------------------
+// fetch
+// ------------------------------
+
+// __native.fetch(method, url, headers, body, redirect, callback(err, head)) 返回 {abort()}
+// head: {status, statusText, url, redirected, headers: [[name, value]...], body}
+func (m *module) fetch(call goja.FunctionCall) goja.Value {
+	rt := m.runtime
+	method := strings.ToUpper(call.Argument(0).String())
+	u, err := url.Parse(call.Argument(1).String())
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		util.ThrowTypeError(rt, "Failed to fetch: invalid URL "+call.Argument(1).String())
+	}
+	if !m.allowed(u) {
+		util.ThrowTypeError(rt, "Failed to fetch: 没有访问 "+u.Hostname()+" 的权限")
+	}
+	var body io.Reader
+	switch data := call.Argument(3).Export().(type) {
+	case string:
+		body = strings.NewReader(data)
+	case goja.ArrayBuffer:
+		body = strings.NewReader(string(data.Bytes()))
+	}
+	redirect := call.Argument(4).String()
+	cb, ok := goja.AssertFunction(call.Argument(5))
+	if !ok {
+		util.ThrowTypeError(rt, "callback is not a function")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		cancel()
+		util.ThrowTypeError(rt, "Failed to fetch: "+err.Error())
+	}
+	if headers, ok := call.Argument(2).Export().(map[string]interface{}); ok {
+		for k, v := range headers {
+			req.Header.Set(k, fmt.Sprint(v))
+		}
+	}
+	client := &http.Client{
+		Transport: m.transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			switch {
+			case redirect == "manual":
+				return http.ErrUseLastResponse
+			case redirect == "error":
+				return errors.New("redirect failed")
+			case len(via) > 20:
+				return errors.New("too many redirects")
+			case !m.allowed(req.URL):
+				return errors.New("redirect denied")
+			}
+			return nil
+		},
+	}
+
+	var resp *http.Response
+	var mu sync.Mutex
+	h := m.open(func() {
+		cancel()
+		mu.Lock()
+		if resp != nil {
+			resp.Body.Close()
+		}
+		mu.Unlock()
+	})
+	h.hold()
+	go func() {
+		r, err := client.Do(req)
+		mu.Lock()
+		resp = r
+		mu.Unlock()
+		h.post(func() error {
+			h.unhold()
+			if err != nil {
+				h.release()
+				h.close()
+				_, err := cb(nil, m.errorValue(err))
+				return err
+			}
+			_, err := cb(nil, goja.Null(), m.head(h, u.String(), r))
+			return err
+		})
+	}()
+
+	abort := rt.NewObject()
+	abort.Set("abort", func() {
+		if !h.done {
+			h.release()
+			h.close()
+		}
+	})
+	return abort
+}
+
+// 响应头和可逐块读取的body
+func (m *module) head(h *handle, rawurl string, resp *http.Response) *goja.Object {
+	rt := m.runtime
+	var headers []interface{}
+	for k, values := range resp.Header {
+		for _, v := range values {
+			headers = append(headers, []interface{}{k, v})
+		}
+	}
+	body := rt.NewObject()
+	// read(callback(err, chunk)) 读取下一块，结束时chunk为null
+	body.Set("read", func(cb goja.Callable) {
+		if h.done {
+			cb(nil, goja.Null(), goja.Null())
+			return
+		}
+		h.hold()
+		go func() {
+			buf := make([]byte, chunkSize)
+			n, err := resp.Body.Read(buf)
+			h.post(func() error {
+				h.unhold()
+				if n > 0 {
+					_, err := cb(nil, goja.Null(), rt.ToValue(rt.NewArrayBuffer(buf[:n])))
+					return err
+				}
+				h.release()
+				h.close()
+				if err == io.EOF {
+					err = nil
+				}
+				_, e := cb(nil, m.errorValue(err), goja.Null())
+				return e
+			})
+		}()
+	})
+	// readAll(callback(err, buffer)) 读取剩余的全部内容
+	body.Set("readAll", func(cb goja.Callable) {
+		if h.done {
+			cb(nil, goja.Null(), rt.ToValue(rt.NewArrayBuffer(nil)))
+			return
+		}
+		h.hold()
+		go func() {
+			data, err := io.ReadAll(resp.Body)
+			h.post(func() error {
+				h.release()
+				h.close()
+				if err != nil {
+					_, e := cb(nil, m.errorValue(err))
+					return e
+				}
+				_, e := cb(nil, goja.Null(), rt.ToValue(rt.NewArrayBuffer(data)))
+				return e
+			})
+		}()
+	})
+	body.Set("cancel", func() {
+		if !h.done {
+			h.release()
+			h.close()
+		}
+	})
+
+	obj := rt.NewObject()
+	obj.Set("status", resp.StatusCode)
+	obj.Set("statusText", strings.TrimSpace(strings.TrimPrefix(resp.Status, strconv.Itoa(resp.StatusCode))))
+	obj.Set("url", resp.Request.URL.String())
+	obj.Set("redirected", resp.Request.URL.String() != rawurl)
+	obj.Set("headers", headers)
+	obj.Set("body", body)
+	return obj
+}
+
+// ------------------------------
+// WebSocket
+// ------------------------------
+
+// __native.connect(url, protocols, onopen(protocol), onmessage(data, binary), onclose(code, reason, wasClean, error))
+// 返回 {send(data), close(code, reason)}
+func (m *module) connect(call goja.FunctionCall) goja.Value {
+	rt := m.runtime
+	rawurl := call.Argument(0).String()
+	u, err := url.Parse(rawurl)
+	if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") {
+		util.ThrowTypeError(rt, "Failed to construct 'WebSocket': The URL '"+rawurl+"' is invalid.")
+	}
+	if !m.allowed(u) {
+		util.ThrowTypeError(rt, "Failed to construct 'WebSocket': 没有访问 "+u.Hostname()+" 的权限")
+	}
+	var protocols []string
+	rt.ExportTo(call.Argument(1), &protocols)
+	onopen, _ := goja.AssertFunction(call.Argument(2))
+	onmessage, _ := goja.AssertFunction(call.Argument(3))
+	onclose, _ := goja.AssertFunction(call.Argument(4))
+	if onopen == nil || onmessage == nil || onclose == nil {
+		util.ThrowTypeError(rt, "callback is not a function")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var conn *websocket.Conn
+	var mu sync.Mutex //保护conn和写操作
+	h := m.open(func() {
+		cancel()
+		mu.Lock()
+		if conn != nil {
+			conn.Close()
+		}
+		mu.Unlock()
+	})
+	h.hold() //连接期间保持事件循环运行
+
+	// 连接结束
+	finish := func(code int, reason string, clean bool, err error) {
+		h.post(func() error {
+			h.release()
+			h.close()
+			errValue := goja.Null()
+			if err != nil && !clean {
+				errValue = m.errorValue(err)
+			}
+			_, e := onclose(nil, rt.ToValue(code), rt.ToValue(reason), rt.ToValue(clean), errValue)
+			return e
+		})
+	}
+	dialer := &websocket.Dialer{
+		Proxy:            m.proxy,
+		HandshakeTimeout: 30 * time.Second,
+		Subprotocols:     protocols,
+	}
+	go func() {
+		c, _, err := dialer.DialContext(ctx, u.String(), nil)
+		if err != nil {
+			finish(websocket.CloseAbnormalClosure, "", false, err)
+			return
+		}
+		mu.Lock()
+		conn = c
+		mu.Unlock()
+		if ctx.Err() != nil { //连接期间已关闭
+			c.Close()
+			return
+		}
+		if !h.post(func() error {
+			_, err := onopen(nil, rt.ToValue(c.Subprotocol()))
+			return err
+		}) {
+			return
+		}
+		for {
+			mt, data, err := c.ReadMessage()
+			if err != nil {
+				var ce *websocket.CloseError
+				if errors.As(err, &ce) {
+					finish(ce.Code, ce.Text, true, nil)
+				} else {
+					finish(websocket.CloseAbnormalClosure, "", false, err)
+				}
+				return
+			}
+			if !h.post(func() error {
+				var value goja.Value
+				if mt == websocket.BinaryMessage {
+					value = rt.ToValue(rt.NewArrayBuffer(data))
+				} else {
+					value = rt.ToValue(string(data))
+				}
+				_, err := onmessage(nil, value, rt.ToValue(mt == websocket.BinaryMessage))
+				return err
+			}) {
+				return
+			}
+		}
+	}()
+
+	socket := rt.NewObject()
+	// send(data) data为字符串或ArrayBuffer
+	socket.Set("send", func(data goja.Value) {
+		mt, payload := websocket.TextMessage, []byte(data.String())
+		if buf, ok := data.Export().(goja.ArrayBuffer); ok {
+			mt, payload = websocket.BinaryMessage, buf.Bytes()
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if conn == nil {
+			util.ThrowTypeError(rt, "WebSocket is not open")
+		}
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if err := conn.WriteMessage(mt, payload); err != nil {
+			log.Println("[WebSocket] 发送失败:", err)
+		}
+	})
+	// close(code, reason) 发送关闭帧，等待对方关闭，超时后断开
+	socket.Set("close", func(code int, reason string) {
+		if h.done {
+			return
+		}
+		mu.Lock()
+		c := conn
+		mu.Unlock()
+		if c == nil {
+			finish(websocket.CloseAbnormalClosure, "", false, errors.New("WebSocket is closed before the connection is established"))
+			cancel()
+			return
+		}
+		msg := websocket.FormatCloseMessage(code, reason)
+		if err := c.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeTimeout)); err != nil {
+			h.close()
+			return
+		}
+		time.AfterFunc(closeTimeout, h.close)
+	})
+	return socket
+}
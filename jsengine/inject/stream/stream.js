@@ -0,0 +1,258 @@
+// 流式fetch和WebSocket，native由module.go提供
+(function (native) {
+	var g = globalThis;
+
+	// TextDecoder支持{stream: true}：保留末尾不完整的UTF-8字符，与下一块拼接后再解码
+	if (typeof TextDecoder === 'function') {
+		var decode = TextDecoder.prototype.decode;
+		var toBytes = function (input) {
+			if (input === undefined || input === null) return new Uint8Array(0);
+			if (input instanceof ArrayBuffer) return new Uint8Array(input);
+			return new Uint8Array(input.buffer, input.byteOffset, input.byteLength);
+		};
+		TextDecoder.prototype.decode = function (input, options) {
+			var bytes = toBytes(input);
+			var pending = this.__pending;
+			this.__pending = null;
+			if (pending) {
+				var merged = new Uint8Array(pending.length + bytes.length);
+				merged.set(pending);
+				merged.set(bytes, pending.length);
+				bytes = merged;
+			}
+			if (options && options.stream) {
+				var cut = bytes.length;
+				for (var i = bytes.length - 1; i >= 0 && i >= bytes.length - 4; i--) {
+					var b = bytes[i];
+					if ((b & 0xc0) === 0x80) continue; //后续字节
+					var need = b >= 0xf0 ? 4 : b >= 0xe0 ? 3 : b >= 0xc0 ? 2 : 1;
+					if (bytes.length - i < need) cut = i;
+					break;
+				}
+				if (cut < bytes.length) {
+					this.__pending = bytes.slice(cut);
+					bytes = bytes.subarray(0, cut);
+				}
+			}
+			return decode.call(this, bytes);
+		};
+	}
+
+	function abortError() {
+		var err = new Error('The operation was aborted.');
+		err.name = 'AbortError';
+		return err;
+	}
+
+	// ReadableStream(只支持getReader)
+	function BodyStream(body) {
+		this._body = body;
+		this._last = Promise.resolve();
+		this.locked = false;
+		this.done = false;
+	}
+	BodyStream.prototype.getReader = function () {
+		if (this.locked) throw new TypeError('ReadableStream is locked');
+		this.locked = true;
+		return new BodyReader(this);
+	};
+	BodyStream.prototype.cancel = function () {
+		this.done = true;
+		this._body.cancel();
+		return Promise.resolve();
+	};
+
+	function BodyReader(stream) {
+		this._stream = stream;
+		var self = this;
+		this.closed = new Promise(function (resolve, reject) {
+			self._resolveClosed = resolve;
+			self._rejectClosed = reject;
+		});
+		this.closed.catch(function () {});
+	}
+	// read() 返回 Promise<{value: Uint8Array, done}>，多次调用按顺序读取
+	BodyReader.prototype.read = function () {
+		var self = this, stream = this._stream;
+		var p = stream._last.then(function () {
+			return new Promise(function (resolve, reject) {
+				if (stream.done) return resolve({value: undefined, done: true});
+				stream._body.read(function (err, chunk) {
+					if (err) {
+						stream.done = true;
+						self._rejectClosed(new TypeError(err));
+						return reject(new TypeError(err));
+					}
+					if (chunk === null) {
+						stream.done = true;
+						self._resolveClosed();
+						return resolve({value: undefined, done: true});
+					}
+					resolve({value: new Uint8Array(chunk), done: false});
+				});
+			});
+		});
+		stream._last = p.catch(function () {});
+		return p;
+	};
+	BodyReader.prototype.cancel = function () {
+		this._resolveClosed();
+		return this._stream.cancel();
+	};
+	BodyReader.prototype.releaseLock = function () {
+		this._stream.locked = false;
+	};
+
+	function makeResponse(head) {
+		var response = new Response(null, {status: head.status, statusText: head.statusText, headers: head.headers});
+		var stream = new BodyStream(head.body);
+		var used = false;
+		Object.defineProperties(response, {
+			url: {value: head.url},
+			redirected: {value: head.redirected},
+			body: {value: stream},
+			bodyUsed: {get: function () { return used || stream.locked; }}
+		});
+		var consume = function () {
+			if (used || stream.locked) return Promise.reject(new TypeError('Already read'));
+			used = true;
+			stream.locked = true;
+			return new Promise(function (resolve, reject) {
+				head.body.readAll(function (err, buf) {
+					stream.done = true;
+					if (err) reject(new TypeError(err));
+					else resolve(buf);
+				});
+			});
+		};
+		response.arrayBuffer = consume;
+		response.text = function () {
+			return consume().then(native.utf8);
+		};
+		response.json = function () {
+			return response.text().then(JSON.parse);
+		};
+		response.blob = function () {
+			return consume().then(function (buf) {
+				return new Blob([buf], {type: response.headers.get('content-type') || ''});
+			});
+		};
+		response.clone = function () {
+			throw new TypeError('Failed to execute \'clone\' on \'Response\': streamed response cannot be cloned');
+		};
+		return response;
+	}
+
+	// fetch：Request对象、FormData/Blob等请求体仍使用原fetch(一次读取全部响应)
+	var originalFetch = g.fetch;
+	g.fetch = function (input, init) {
+		init = init || {};
+		if (typeof Request === 'function' && input instanceof Request) return originalFetch.apply(this, arguments);
+		var headers = {};
+		new Headers(init.headers || {}).forEach(function (value, name) {
+			headers[name] = value;
+		});
+		var body = init.body, data = null;
+		if (body !== undefined && body !== null) {
+			if (typeof body === 'string') {
+				data = body;
+				if (!headers['content-type']) headers['content-type'] = 'text/plain;charset=UTF-8';
+			} else if (typeof URLSearchParams === 'function' && body instanceof URLSearchParams) {
+				data = body.toString();
+				if (!headers['content-type']) headers['content-type'] = 'application/x-www-form-urlencoded;charset=UTF-8';
+			} else if (body instanceof ArrayBuffer) {
+				data = body;
+			} else if (ArrayBuffer.isView(body)) {
+				data = body.buffer.slice(body.byteOffset, body.byteOffset + body.byteLength);
+			} else {
+				return originalFetch.apply(this, arguments);
+			}
+		}
+		var url = String(input), signal = init.signal;
+		return new Promise(function (resolve, reject) {
+			if (signal && signal.aborted) return reject(abortError());
+			var request = native.fetch(init.method || 'GET', url, headers, data, init.redirect || 'follow', function (err, head) {
+				if (err) return reject(new TypeError('Failed to fetch: ' + err));
+				resolve(makeResponse(head));
+			});
+			if (signal && typeof signal.addEventListener === 'function') {
+				signal.addEventListener('abort', function () {
+					request.abort();
+					reject(abortError());
+				});
+			}
+		});
+	};
+
+	// WebSocket
+	function WebSocket(url, protocols) {
+		if (!(this instanceof WebSocket)) throw new TypeError('Failed to construct \'WebSocket\': Please use the \'new\' operator');
+		var self = this;
+		if (typeof protocols === 'string') protocols = [protocols];
+		this.url = String(url);
+		this.readyState = WebSocket.CONNECTING;
+		this.protocol = '';
+		this.extensions = '';
+		this.bufferedAmount = 0;
+		this.binaryType = 'blob';
+		this.onopen = this.onmessage = this.onerror = this.onclose = null;
+		this._listeners = {};
+		this._socket = native.connect(this.url, protocols || [],
+			function (protocol) {
+				if (self.readyState !== WebSocket.CONNECTING) return;
+				self.readyState = WebSocket.OPEN;
+				self.protocol = protocol;
+				self._dispatch({type: 'open'});
+			},
+			function (data, binary) {
+				if (self.readyState !== WebSocket.OPEN) return;
+				if (binary && self.binaryType === 'blob') data = new Blob([data]);
+				self._dispatch({type: 'message', data: data, origin: self.url});
+			},
+			function (code, reason, wasClean, error) {
+				self.readyState = WebSocket.CLOSED;
+				if (error) self._dispatch({type: 'error', message: error});
+				self._dispatch({type: 'close', code: code, reason: reason, wasClean: wasClean});
+			});
+	}
+	['CONNECTING', 'OPEN', 'CLOSING', 'CLOSED'].forEach(function (name, value) {
+		WebSocket[name] = value;
+		WebSocket.prototype[name] = value;
+	});
+	WebSocket.prototype.send = function (data) {
+		if (this.readyState === WebSocket.CONNECTING) throw new Error('InvalidStateError: Still in CONNECTING state.');
+		if (this.readyState !== WebSocket.OPEN) return;
+		if (ArrayBuffer.isView(data)) data = data.buffer.slice(data.byteOffset, data.byteOffset + data.byteLength);
+		else if (!(data instanceof ArrayBuffer)) data = String(data);
+		this._socket.send(data);
+	};
+	WebSocket.prototype.close = function (code, reason) {
+		if (this.readyState === WebSocket.CLOSING || this.readyState === WebSocket.CLOSED) return;
+		this.readyState = WebSocket.CLOSING;
+		this._socket.close(code === undefined ? 1000 : code, reason === undefined ? '' : String(reason));
+	};
+	WebSocket.prototype.addEventListener = function (type, listener) {
+		var list = this._listeners[type] || (this._listeners[type] = []);
+		if (typeof listener === 'function' && list.indexOf(listener) < 0) list.push(listener);
+	};
+	WebSocket.prototype.removeEventListener = function (type, listener) {
+		var list = this._listeners[type];
+		if (list && list.indexOf(listener) >= 0) list.splice(list.indexOf(listener), 1);
+	};
+	// 依次调用on<type>和监听函数，出错时不影响其他监听函数，最后抛出第一个错误
+	WebSocket.prototype._dispatch = function (event) {
+		event.target = event.currentTarget = this;
+		var handlers = (this._listeners[event.type] || []).slice();
+		if (typeof this['on' + event.type] === 'function') handlers.unshift(this['on' + event.type]);
+		var first = null;
+		for (var i = 0; i < handlers.length; i++) {
+			try {
+				handlers[i].call(this, event);
+			} catch (e) {
+				if (first === null) first = e;
+			}
+		}
+		if (first !== null) throw first;
+	};
+	g.WebSocket = WebSocket;
+})
@@ -4,12 +4,23 @@ import (
 	"github.com/dop251/goja"
 )
 
-func Enable(runtime *goja.Runtime) error {
+// Enable 注入wol/command/shell，allow为命令权限检查(为nil时不限制)
+func Enable(runtime *goja.Runtime, allow func(cmdstr string) bool) error {
 	if err := runtime.Set("wol", wakeOnLAN); err != nil {
 		return err
 	}
-	if err := runtime.Set("command", command); err != nil {
+	if err := runtime.Set("command", func(cmdstr string) error {
+		if allow != nil && !allow(cmdstr) {
+			return errDenied(cmdstr)
+		}
+		return command(cmdstr)
+	}); err != nil {
 		return err
 	}
-	return runtime.Set("shell", shell)
+	return runtime.Set("shell", func(cmdstr string) error {
+		if allow != nil && !allow(cmdstr) {
+			return errDenied(cmdstr)
+		}
+		return shell(cmdstr)
+	})
 }
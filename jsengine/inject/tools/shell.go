@@ -46,6 +46,10 @@ func shell(cmdstr string) error {
 	return nil
 }
 
+func errDenied(cmdstr string) error {
+	return fmt.Errorf("权限不足，不允许执行: %s", cmdstr)
+}
+
 // 单条命令执行
 func command(cmdstr string) error {
 	log.Println(cmdstr)
@@ -131,6 +131,7 @@ type XMLHttpRequest struct {
 	responseHeaders http.Header
 	aborted         bool
 	client          *req.Client
+	allowHost       func(host string) bool
 
 	WithCredentials bool                  `json:"withCredentials"`
 	Upload          *XMLHttpRequestUpload `json:"upload"`
@@ -164,6 +165,11 @@ func (xhr *XMLHttpRequest) SetRequestHeader(key, value string) {
 }
 
 func (xhr *XMLHttpRequest) Send(data goja.Value) {
+	if !xhr.allowed(xhr.url) {
+		xhr.Upload.callOnerror()
+		xhr.callOnerror()
+		return
+	}
 	setFingerprint(xhr.client)
 
 	d := xhr.parseData(data)
@@ -245,6 +251,9 @@ func (xhr *XMLHttpRequest) Send(data goja.Value) {
 		if len(via) > 20 {
 			return errors.New("too many redirects")
 		}
+		if !xhr.allowed(req.URL.String()) {
+			return errors.New("redirect denied")
+		}
 		return nil
 	})
 
@@ -297,6 +306,18 @@ func (xhr *XMLHttpRequest) Send(data goja.Value) {
 	}
 }
 
+// 检查是否允许访问该地址的主机
+func (xhr *XMLHttpRequest) allowed(rawurl string) bool {
+	if xhr.allowHost == nil {
+		return true
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return false
+	}
+	return xhr.allowHost(u.Hostname())
+}
+
 func (xhr *XMLHttpRequest) Abort() {
 	xhr.doReadystatechange(0)
 	xhr.aborted = true
@@ -361,7 +382,8 @@ func (xhr *XMLHttpRequest) parseData(data goja.Value) any {
 	return data.String()
 }
 
-func Enable(runtime *goja.Runtime, proxyHandler func(r *http.Request) (*url.URL, error)) error {
+// Enable 注入XMLHttpRequest，allowHost为访问主机的权限检查(为nil时不限制)
+func Enable(runtime *goja.Runtime, proxyHandler func(r *http.Request) (*url.URL, error), allowHost func(host string) bool) error {
 	progressEvent := runtime.ToValue(func(call goja.ConstructorCall) *goja.Object {
 		if len(call.Arguments) < 1 {
 			util.ThrowTypeError(runtime, "Failed to construct 'ProgressEvent': 1 argument required, but only 0 present.")
@@ -381,7 +403,8 @@ func Enable(runtime *goja.Runtime, proxyHandler func(r *http.Request) (*url.URL,
 		}
 
 		instance := &XMLHttpRequest{
-			client: client,
+			client:    client,
+			allowHost: allowHost,
 			Upload: &XMLHttpRequestUpload{
 				EventProp: &EventProp{
 					eventListeners: make(map[string]func(event *ProgressEvent)),
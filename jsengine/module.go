@@ -0,0 +1,132 @@
+package jsengine
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"ninego/log"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/require"
+)
+
+/*
+require() 公共模块
+	require('http')			//先查内置模块(bot/storage/miot/schedule)，再查 scripts/lib/http.js 或 scripts/lib/http/index.js
+	require('./a.js')		//相对路径(相对当前模块所在目录)
+编译后的模块缓存在注册表中，scripts/lib 下的文件修改后自动重新加载。
+*/
+
+// 公共模块目录(相对程序目录)
+var ScriptLibDir = filepath.Join("scripts", "lib")
+
+// 内置模块的方法，如 require('miot').action(...)
+var ModulefuncMap = map[string]map[string]interface{}{
+	"miot":     {},
+	"schedule": {},
+}
+
+var modules = struct {
+	sync.Mutex
+	registry   *require.Registry
+	generation int
+	loaded     time.Time //加载时scripts/lib的最后修改时间
+	checked    time.Time //上次检查时间
+}{}
+
+// 模块目录的绝对路径
+func scriptLibPath() string {
+	if filepath.IsAbs(ScriptLibDir) {
+		return ScriptLibDir
+	}
+	return filepath.Join(GetExecutableDir(), ScriptLibDir)
+}
+
+// 返回当前的模块注册表及版本号，scripts/lib有修改时重建注册表(丢弃已编译的缓存)
+func moduleRegistry() (*require.Registry, int) {
+	modules.Lock()
+	defer modules.Unlock()
+	if modules.registry != nil && time.Since(modules.checked) < 2*time.Second {
+		return modules.registry, modules.generation
+	}
+	modules.checked = time.Now()
+	modified := libModTime(scriptLibPath())
+	if modules.registry == nil || modified.After(modules.loaded) {
+		if modules.registry != nil {
+			log.Println("公共模块已修改，重新加载", scriptLibPath())
+		}
+		modules.registry = newModuleRegistry()
+		modules.generation++
+		modules.loaded = modified
+	}
+	return modules.registry, modules.generation
+}
+
+// ReloadModules 丢弃已编译的模块，下次require时重新加载
+func ReloadModules() {
+	modules.Lock()
+	modules.registry = nil
+	modules.Unlock()
+}
+
+func newModuleRegistry() *require.Registry {
+	registry := require.NewRegistry(require.WithGlobalFolders(scriptLibPath()))
+	registry.RegisterNativeModule("bot", func(rt *goja.Runtime, module *goja.Object) {
+		module.Set("exports", rt.Get("bot"))
+	})
+	registry.RegisterNativeModule("storage", func(rt *goja.Runtime, module *goja.Object) {
+		module.Set("exports", rt.Get("bot").ToObject(rt).Get("storage"))
+	})
+	for name, funcs := range ModulefuncMap {
+		name, funcs := name, funcs
+		registry.RegisterNativeModule(name, func(rt *goja.Runtime, module *goja.Object) {
+			sb := sandboxOf(rt)
+			exports := rt.NewObject()
+			for fname, fn := range funcs {
+				if capability, ok := moduleCapability[name]; ok && sb != nil && !moduleReadOnly[name+"."+fname] {
+					fn = sb.guardFunc(capability, name+"."+fname, fn)
+				}
+				exports.Set(fname, adaptToGoja(rt, fn))
+			}
+			module.Set("exports", exports)
+		})
+	}
+	return registry
+}
+
+// 目录下文件的最后修改时间
+func libModTime(dir string) time.Time {
+	var last time.Time
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+		return nil
+	})
+	return last
+}
+
+// enableRequire 在VM中启用require()，模块注册表重建后自动切换到新的注册表
+// 返回的函数清除已加载的模块实例，下次require时重新执行模块代码
+func enableRequire(rt *goja.Runtime) (reset func()) {
+	var current *require.RequireModule
+	var requireFunc func(call goja.FunctionCall) goja.Value
+	generation := 0
+	requireFunc = func(call goja.FunctionCall) goja.Value {
+		registry, gen := moduleRegistry()
+		if current == nil || gen != generation {
+			current = registry.Enable(rt)
+			rt.Set("require", requireFunc) //Enable会覆盖全局require
+			generation = gen
+		}
+		value, err := current.Require(call.Argument(0).String())
+		if err != nil {
+			panic(rt.NewGoError(err))
+		}
+		return value
+	}
+	rt.Set("require", requireFunc)
+	return func() { current = nil }
+}
@@ -0,0 +1,182 @@
+package jsengine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"xiaobot/gcron"
+)
+
+/*
+提示词模板
+	AI提示词(配置 prompt、人设)可以用模板语法 {{...}}，每次问AI时填入当时的值：
+		{{date}}             2026年10月19日
+		{{time}}             15:04
+		{{weekday}}          星期一
+		{{lunar}}            农历九月初十
+		{{solarterm}}        今天的节气(不是节气时为空)
+		{{holiday}}          今天的节假日名称，或 工作日、周末、调休上班
+		{{city}}             家所在的城市(配置 city，默认北京)
+		{{device}}           音箱的名称
+		{{schedules}}        今天还没执行的定时任务，如 18:30 关窗帘；21:00 提醒睡觉
+		{{storage "key"}}    bot.storage 中的值，{{storage "ns" "key"}} 为 bot.store('ns') 中的值
+		{{.query}}           本次的提问(脚本中为传入的变量)
+	还可以用 {{if}}、{{range}} 等Go模板语法，如 {{if eq holiday "工作日"}}主人今天要上班{{end}}。
+	脚本中用 bot.renderPrompt(text, vars) 渲染模板，网页配置页面可以预览提示词。
+*/
+
+// 解析过的模板最多缓存的个数，超过时清空(脚本、网页预览的模板也会解析)
+const maxPromptTemplates = 64
+
+var (
+	promptVarsMu sync.RWMutex
+	promptVars   = map[string]string{"city": "北京"} //city、device
+
+	promptTemplatesMu sync.Mutex
+	promptTemplates   = map[string]*template.Template{} //模板文字 -> 模板
+)
+
+// SetPromptVar 设置提示词模板中的 city、device
+func SetPromptVar(name, value string) {
+	promptVarsMu.Lock()
+	defer promptVarsMu.Unlock()
+	promptVars[name] = value
+}
+
+func promptVar(name string) string {
+	promptVarsMu.RLock()
+	defer promptVarsMu.RUnlock()
+	return promptVars[name]
+}
+
+var weekdayNames = []string{"星期日", "星期一", "星期二", "星期三", "星期四", "星期五", "星期六"}
+
+// 模板中的函数(每次渲染时按当时的时间和数据计算)
+func promptFuncs(now time.Time, st *Storage) template.FuncMap {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	return template.FuncMap{
+		"date":    func() string { return now.Format("2006年1月2日") },
+		"time":    func() string { return now.Format("15:04") },
+		"weekday": func() string { return weekdayNames[now.Weekday()] },
+		"lunar": func() string {
+			lunar := gcron.SolarToLunar(today)
+			month := gcron.LunarMonthStr(lunar.Month) + "月"
+			if lunar.IsLeap {
+				month = "闰" + month
+			}
+			return "农历" + month + gcron.LunarDayStr(lunar.Day)
+		},
+		"solarterm": func() string { return gcron.GetSolarTermByDate(today) },
+		"holiday":   func() string { return holidayStatus(today) },
+		"city":      func() string { return promptVar("city") },
+		"device":    func() string { return promptVar("device") },
+		"schedules": func() string { return upcomingSchedules(now) },
+		"storage": func(keys ...string) interface{} {
+			switch len(keys) {
+			case 1:
+				return st.Namespace(GlobalNamespace).Get(keys[0])
+			case 2:
+				return st.Namespace(keys[0]).Get(keys[1])
+			}
+			return nil
+		},
+	}
+}
+
+// 节假日名称，或 工作日、周末、调休上班
+func holidayStatus(day time.Time) string {
+	date := day.Format("2006-01-02")
+	for _, h := range gcron.HolidayList(day.Year()) {
+		if h.Date == date {
+			if h.IsOffDay {
+				return h.Name
+			}
+			return "调休上班"
+		}
+	}
+	if gcron.IsWorkday(day) {
+		return "工作日"
+	}
+	return "周末"
+}
+
+// 今天还没执行的定时任务(按执行时间排序)
+func upcomingSchedules(now time.Time) string {
+	end := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+	type upcoming struct {
+		next time.Time
+		name string
+	}
+	list := []upcoming{}
+	// 读取任务的状态时持有锁，避免与网页、脚本修改任务同时进行
+	Schedules.mu.RLock()
+	for _, job := range Schedules.Jobs {
+		if !job.IsActive || job.Schedule == nil {
+			continue
+		}
+		next := job.Schedule.NextTime
+		if next.Before(now) || !next.Before(end) {
+			continue
+		}
+		list = append(list, upcoming{next, job.Name})
+	}
+	Schedules.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].next.Before(list[j].next) })
+	items := make([]string, 0, len(list))
+	for _, u := range list {
+		items = append(items, u.next.Format("15:04")+" "+u.name)
+	}
+	return strings.Join(items, "；")
+}
+
+// RenderPrompt 渲染提示词模板，没有 {{ 时原样返回
+func RenderPrompt(text string, vars map[string]interface{}) (string, error) {
+	return renderPromptAt(text, vars, time.Now(), store)
+}
+
+// 按指定的时间和存储渲染(脚本测试使用假时钟和内存中的存储)
+func renderPromptAt(text string, vars map[string]interface{}, now time.Time, st *Storage) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	// 复制模板后换成本次的函数(时间等)
+	t, err := promptTemplate(text, now, st)
+	if err != nil {
+		return text, err
+	}
+	if vars == nil {
+		vars = map[string]interface{}{}
+	}
+	var b strings.Builder
+	if err := t.Funcs(promptFuncs(now, st)).Execute(&b, vars); err != nil {
+		return text, fmt.Errorf("提示词模板出错: %w", err)
+	}
+	return strings.ReplaceAll(b.String(), "<no value>", ""), nil
+}
+
+// 解析模板(有缓存)，返回可以换函数的副本
+func promptTemplate(text string, now time.Time, st *Storage) (*template.Template, error) {
+	promptTemplatesMu.Lock()
+	defer promptTemplatesMu.Unlock()
+	tmpl, ok := promptTemplates[text]
+	if !ok {
+		t, err := template.New("prompt").Option("missingkey=zero").Funcs(promptFuncs(now, st)).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("提示词模板有误: %w", err)
+		}
+		if len(promptTemplates) >= maxPromptTemplates {
+			promptTemplates = map[string]*template.Template{}
+		}
+		promptTemplates[text] = t
+		tmpl = t
+	}
+	return tmpl.Clone()
+}
+
+func init() {
+	BotfuncMap["renderPrompt"] = RenderPrompt //模板有误时抛出异常
+}
@@ -3,6 +3,7 @@ package jsengine
 import (
 	"net/http"
 	"ninego/log"
+	"path/filepath"
 
 	"github.com/dop251/goja"
 )
@@ -16,9 +17,9 @@ func Exec_queryJS(query, customJSScript string) (handled bool, err error) {
 
 	handled = false
 	// 创建 JS 虚拟机
-	engine := vmPool.Get().(*Engine)
+	engine := getEngine(ContextQuery, filepath.Join(GetExecutableDir(), "query.bot"), customJSScript)
 	// 将 VM 放回池中以供将来重用
-	defer vmPool.Put(engine)
+	defer putEngine(engine)
 
 	// 将 Go 对象注入 JS 全局作用域
 	engine.Runtime.Set("query", query)
@@ -0,0 +1,162 @@
+package jsengine
+
+import (
+	"errors"
+	"fmt"
+
+	"ninego/log"
+	"xiaobot/jsengine/console"
+
+	"github.com/dop251/goja"
+)
+
+/*
+网页调试控制台(/repl)
+	每个网页连接一个独立的VM(拥有全部权限，注入bot)，输入的代码在同一个VM中依次执行，变量和定时器在多次执行间保留；
+	console输出带调用位置(repl3:2 为第3次输入的第2行)发送到网页。
+	结果为Promise时等待完成后再显示。每次执行和定时器回调的时间限制见 Timeouts["repl"]。
+*/
+
+// ReplMessage 调试控制台与网页之间的消息
+type ReplMessage struct {
+	Type   string `json:"type"`             //网页发送: eval；返回: result/error/console
+	ID     int    `json:"id,omitempty"`     //eval的序号，结果中原样返回
+	Code   string `json:"code,omitempty"`   //要执行的代码
+	Level  string `json:"level,omitempty"`  //console的级别(log/info/warn/error...)
+	Source string `json:"source,omitempty"` //console的调用位置
+	Text   string `json:"text,omitempty"`   //结果、错误或console输出
+}
+
+// Repl 调试控制台的会话
+type Repl struct {
+	engine *Engine
+	send   func(msg *ReplMessage)
+}
+
+// NewRepl 创建会话，输出通过send发送(在VM的事件循环中调用)
+func NewRepl(send func(msg *ReplMessage)) *Repl {
+	r := &Repl{send: send}
+	eng := NewEngine(nil)
+	enableRequire(eng.Runtime)
+	console.EnableOutput(eng.Runtime, func(level, source, text string) {
+		send(&ReplMessage{Type: "console", Level: level, Source: source, Text: text})
+	})
+
+	botMap := eng.sandbox.guardBotMap(BotfuncMap)
+	bindStorage(eng.Runtime, botMap)
+	RegisterBotMap(eng.Runtime, botMap)
+	guardTimers(eng.Runtime, ContextRepl, func(err error) {
+		send(&ReplMessage{Type: "console", Level: "error", Text: "定时器回调出错: " + errorText(err)})
+	})
+	eng.sandbox.grant(ContextRepl, DefaultPermissions[ContextRepl])
+	eng.loop.Start()
+	r.engine = eng
+	return r
+}
+
+// Eval 在会话的VM中执行代码，结果异步返回；会话已关闭时返回false
+func (r *Repl) Eval(id int, code string) bool {
+	return r.engine.loop.RunOnLoop(func(rt *goja.Runtime) {
+		limit := timeoutOf(ContextRepl)
+		w := startWatchdog(rt, limit, nil)
+		value, err := rt.RunScript(fmt.Sprintf("repl%d", id), code)
+		if w.stop() {
+			rt.ClearInterrupt()
+			var ie *goja.InterruptedError
+			if errors.As(err, &ie) {
+				err = &TimeoutError{Limit: limit}
+			}
+		}
+		if err != nil {
+			r.send(&ReplMessage{Type: "error", ID: id, Text: errorText(err)})
+			return
+		}
+		if p, ok := value.Export().(*goja.Promise); ok && p.State() == goja.PromiseStatePending {
+			r.await(id, value.ToObject(rt))
+			return
+		}
+		r.result(id, rt, value)
+	})
+}
+
+// 等待Promise完成后返回结果
+func (r *Repl) await(id int, promise *goja.Object) {
+	rt := r.engine.Runtime
+	then, ok := goja.AssertFunction(promise.Get("then"))
+	if !ok {
+		r.result(id, rt, promise)
+		return
+	}
+	then(promise, rt.ToValue(func(value goja.Value) {
+		r.send(&ReplMessage{Type: "result", ID: id, Text: inspect(rt, value)})
+	}), rt.ToValue(func(reason goja.Value) {
+		r.send(&ReplMessage{Type: "error", ID: id, Text: "Uncaught (in promise) " + inspect(rt, reason)})
+	}))
+}
+
+func (r *Repl) result(id int, rt *goja.Runtime, value goja.Value) {
+	if p, ok := value.Export().(*goja.Promise); ok {
+		if p.State() == goja.PromiseStateRejected {
+			r.send(&ReplMessage{Type: "error", ID: id, Text: "Uncaught (in promise) " + inspect(rt, p.Result())})
+			return
+		}
+		value = p.Result()
+	}
+	r.send(&ReplMessage{Type: "result", ID: id, Text: inspect(rt, value)})
+}
+
+// Close 结束会话：中断正在执行的代码，取消定时器，关闭未结束的请求和连接
+func (r *Repl) Close() {
+	r.engine.Runtime.Interrupt(errReplClosed)
+	r.engine.loop.Terminate()
+	r.engine.closeStreams()
+	sandboxes.Delete(r.engine.Runtime)
+	log.Println("调试控制台会话已结束")
+}
+
+var errReplClosed = errors.New("调试控制台已关闭")
+
+// 错误信息，脚本异常带调用栈
+func errorText(err error) string {
+	var ex *goja.Exception
+	if errors.As(err, &ex) {
+		return ex.String()
+	}
+	return err.Error()
+}
+
+// 显示JS值：字符串加引号，对象转为格式化的JSON，Error显示调用栈
+func inspect(rt *goja.Runtime, value goja.Value) string {
+	if value == nil || goja.IsUndefined(value) {
+		return "undefined"
+	}
+	if goja.IsNull(value) {
+		return "null"
+	}
+	if _, ok := goja.AssertFunction(value); ok {
+		name := value.ToObject(rt).Get("name")
+		if name == nil || name.String() == "" {
+			return "[Function (anonymous)]"
+		}
+		return "[Function: " + name.String() + "]"
+	}
+	obj, ok := value.(*goja.Object)
+	if !ok {
+		if s, ok := value.Export().(string); ok {
+			return fmt.Sprintf("%q", s)
+		}
+		return value.String()
+	}
+	if obj.ClassName() == "Error" {
+		if stack := obj.Get("stack"); stack != nil && stack.String() != "" {
+			return stack.String()
+		}
+		return obj.String()
+	}
+	if stringify, ok := goja.AssertFunction(rt.Get("JSON").ToObject(rt).Get("stringify")); ok {
+		if s, err := stringify(nil, obj, goja.Null(), rt.ToValue(2)); err == nil && !goja.IsUndefined(s) {
+			return s.String()
+		}
+	}
+	return obj.String()
+}
@@ -0,0 +1,355 @@
+package jsengine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"ninego/log"
+
+	"github.com/dop251/goja"
+)
+
+/*
+脚本权限
+	脚本同名的 .perm.json 文件(如 welcome.bot -> welcome.perm.json)，或脚本头部注释：
+	// @permissions {"shell":["ping"],"network":["api.example.com","*.qq.com"],"files":true,"bot":true,"schedule":true}
+	都没有时按脚本类型使用 DefaultPermissions。
+*/
+
+// Permissions 脚本可使用的能力
+type Permissions struct {
+	Shell    []string `json:"shell,omitempty"`    //允许执行的命令名, "*"=全部
+	Network  []string `json:"network,omitempty"`  //允许访问的主机, "*"=全部, "*.example.com"=子域名
+	Files    bool     `json:"files,omitempty"`    //读写数据目录(DataDir)下的文件
+	Bot      bool     `json:"bot,omitempty"`      //控制音箱(tts/action/playurl/miot...)
+	Schedule bool     `json:"schedule,omitempty"` //修改定时任务
+}
+
+// 脚本类型
+const (
+	ContextQuery     = "query"     //query.bot
+	ContextTask      = "task"      //任务脚本(/task/{action})
+	ContextSchedule  = "schedule"  //定时任务脚本
+	ContextCondition = "condition" //定时任务的执行条件(可由网页提交测试)
+	ContextHook      = "hook"      //事件脚本(scripts/hooks/*.js)
+	ContextApp       = "app"       //Web应用脚本(scripts/apps/*.js)
+	ContextRepl      = "repl"      //网页调试控制台(/repl)
+	ContextTest      = "test"      //脚本测试(*.test.js)
+)
+
+// 能力
+const (
+	capShell    = "shell"
+	capNetwork  = "network"
+	capFiles    = "files"
+	capBot      = "bot"
+	capSchedule = "schedule"
+)
+
+var AllPermissions = &Permissions{Shell: []string{"*"}, Network: []string{"*"}, Files: true, Bot: true, Schedule: true}
+
+// 各类脚本未声明权限时的默认权限
+// 任务脚本和Web应用可被局域网内任意设备调用，默认只能控制音箱
+var DefaultPermissions = map[string]*Permissions{
+	ContextQuery:     AllPermissions,
+	ContextSchedule:  AllPermissions,
+	ContextHook:      AllPermissions,
+	ContextRepl:      AllPermissions,
+	ContextTask:      {Bot: true},
+	ContextApp:       {Bot: true},
+	ContextCondition: ReadOnlyPermissions,
+}
+
+// ReadOnlyPermissions 只读：不能执行命令、访问网络、读写文件、控制音箱和修改定时任务
+var ReadOnlyPermissions = &Permissions{}
+
+// 脚本读写文件的数据目录(相对程序目录)
+var DataDir = "data"
+
+// bot方法需要的能力
+var botCapability = map[string]string{
+	"tts":         capBot,
+	"action":      capBot,
+	"playurl":     capBot,
+	"stopspeaker": capBot,
+	"wakeup":      capBot,
+	"dialog":      capBot,
+	"setPersona":  capBot,
+	"readFile":    capFiles,
+	"writeFile":   capFiles,
+}
+
+// require内置模块需要的能力(只读方法除外)
+var moduleCapability = map[string]string{
+	"miot":     capBot,
+	"schedule": capSchedule,
+}
+
+var moduleReadOnly = map[string]bool{
+	"schedule.preview": true,
+	"schedule.list":    true,
+	"schedule.get":     true,
+	"miot.devices":     true,
+	"miot.spec":        true,
+	"miot.get":         true,
+	"miot.getVolume":   true,
+}
+
+var permissionsComment = regexp.MustCompile(`(?m)^\s*//\s*@permissions\s+(\{.*\})\s*$`)
+
+// ScriptPermissions 读取脚本声明的权限：同名.perm.json优先，其次是脚本中的 // @permissions 注释，都没有时返回nil
+func ScriptPermissions(script, filename string) (*Permissions, error) {
+	if filename != "" {
+		sidecar := strings.TrimSuffix(filename, filepath.Ext(filename)) + ".perm.json"
+		if content, err := os.ReadFile(sidecar); err == nil {
+			var p Permissions
+			if err := json.Unmarshal(content, &p); err != nil {
+				return nil, fmt.Errorf("%s: %w", sidecar, err)
+			}
+			return &p, nil
+		}
+	}
+	if m := permissionsComment.FindStringSubmatch(script); m != nil {
+		var p Permissions
+		if err := json.Unmarshal([]byte(m[1]), &p); err != nil {
+			return nil, fmt.Errorf("@permissions: %w", err)
+		}
+		return &p, nil
+	}
+	return nil, nil
+}
+
+var requireMiot = regexp.MustCompile(`require\(\s*['"]miot['"]\s*\)`)
+
+// ScriptRisk 执行任务脚本前需要口头确认的操作：有shell权限时为“执行命令”，使用require('miot')时为“控制设备”，都没有时返回空
+func ScriptRisk(script, filename string) string {
+	perms, _ := ScriptPermissions(script, filename)
+	if perms == nil {
+		perms = DefaultPermissions[ContextTask]
+	}
+	switch {
+	case len(perms.Shell) > 0:
+		return "执行命令"
+	case requireMiot.MatchString(script):
+		return "控制设备"
+	}
+	return ""
+}
+
+// 每个VM的权限状态，在getEngine时按本次运行的脚本设置
+type sandbox struct {
+	mu    sync.RWMutex
+	name  string
+	perms *Permissions
+}
+
+var sandboxes sync.Map // *goja.Runtime -> *sandbox
+
+func sandboxOf(rt *goja.Runtime) *sandbox {
+	if sb, ok := sandboxes.Load(rt); ok {
+		return sb.(*sandbox)
+	}
+	return nil
+}
+
+func (s *sandbox) grant(name string, perms *Permissions) {
+	s.mu.Lock()
+	s.name, s.perms = name, perms
+	s.mu.Unlock()
+}
+
+// 当前运行的脚本名
+func (s *sandbox) scriptName() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.name
+}
+
+// allow 检查能力，拒绝时记录日志
+func (s *sandbox) allow(capability, detail string) bool {
+	s.mu.RLock()
+	name, p := s.name, s.perms
+	s.mu.RUnlock()
+
+	ok := false
+	if p != nil {
+		switch capability {
+		case capShell:
+			ok = allowCommand(p.Shell, detail)
+		case capNetwork:
+			ok = allowHost(p.Network, detail)
+		case capFiles:
+			ok = p.Files
+		case capBot:
+			ok = p.Bot
+		case capSchedule:
+			ok = p.Schedule
+		}
+	}
+	if !ok {
+		log.Printf("[权限] 脚本%s无%s权限，已拒绝: %s\n", name, capability, detail)
+	}
+	return ok
+}
+
+// 命令中的每一段(管道、;、&&分隔)的命令名都须在列表中；含换行(shell也按换行分隔命令)、重定向或命令替换时须为"*"
+func allowCommand(list []string, cmdstr string) bool {
+	for _, c := range list {
+		if c == "*" {
+			return true
+		}
+	}
+	if strings.ContainsAny(cmdstr, "<>`\n\r") || strings.Contains(cmdstr, "$(") {
+		return false
+	}
+	segments := strings.FieldsFunc(cmdstr, func(r rune) bool { return r == '|' || r == ';' || r == '&' })
+	if len(segments) == 0 {
+		return false
+	}
+	for _, seg := range segments {
+		fields := strings.Fields(seg)
+		if len(fields) == 0 {
+			continue
+		}
+		name := strings.TrimSuffix(filepath.Base(fields[0]), ".exe")
+		found := false
+		for _, c := range list {
+			if strings.EqualFold(c, name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func allowHost(list []string, host string) bool {
+	host = strings.ToLower(host)
+	for _, h := range list {
+		h = strings.ToLower(h)
+		switch {
+		case h == "*" || h == host:
+			return true
+		case strings.HasPrefix(h, "*.") && strings.HasSuffix(host, h[1:]):
+			return true
+		}
+	}
+	return false
+}
+
+// guardFunc 返回同类型的函数，调用前检查能力，拒绝时返回零值
+func (s *sandbox) guardFunc(capability, name string, fn interface{}) interface{} {
+	fnVal := reflect.ValueOf(fn)
+	if fnVal.Kind() != reflect.Func {
+		return fn
+	}
+	fnType := fnVal.Type()
+	return reflect.MakeFunc(fnType, func(args []reflect.Value) []reflect.Value {
+		if !s.allow(capability, name) {
+			results := make([]reflect.Value, fnType.NumOut())
+			for i := range results {
+				results[i] = reflect.Zero(fnType.Out(i))
+				if fnType.Out(i) == reflect.TypeOf((*error)(nil)).Elem() {
+					results[i] = reflect.ValueOf(fmt.Errorf("权限不足: %s", name))
+				}
+			}
+			return results
+		}
+		return fnVal.Call(args)
+	}).Interface()
+}
+
+// guardBotMap 按botCapability包装需要权限的bot方法
+func (s *sandbox) guardBotMap(botMap map[string]interface{}) map[string]interface{} {
+	guarded := make(map[string]interface{}, len(botMap))
+	for name, fn := range botMap {
+		if capability, ok := botCapability[name]; ok {
+			fn = s.guardFunc(capability, "bot."+name, fn)
+		}
+		guarded[name] = fn
+	}
+	return guarded
+}
+
+// getEngine 从池中取出VM并按脚本设置权限和执行时间限制，用完须调用putEngine(恢复初始状态后放回池中)
+func getEngine(context, filename, script string) *Engine {
+	engine := vmPool.Get().(*Engine)
+	perms, err := ScriptPermissions(script, filename)
+	if err != nil {
+		log.Error("脚本权限声明有误，使用默认权限:", err)
+	}
+	if perms == nil {
+		perms = DefaultPermissions[context]
+	}
+	name := filepath.Base(filename)
+	if filename == "" {
+		name = context
+	}
+	engine.sandbox.grant(name, perms)
+	engine.timeout = timeoutOf(context)
+	return engine
+}
+
+func putEngine(engine *Engine) {
+	engine.closeStreams()
+	engine.sandbox.grant("", nil)
+	engine.timeout = 0
+	if !engine.expired && !engine.resetState() {
+		log.Println("脚本修改了无法恢复的全局状态，丢弃该VM")
+		engine.expired = true
+	}
+	if engine.expired {
+		sandboxes.Delete(engine.Runtime)
+		return
+	}
+	vmPool.Put(engine)
+}
+
+// ------------------------------
+// 数据目录下的文件读写
+// ------------------------------
+
+func init() {
+	BotfuncMap["readFile"] = readDataFile
+	BotfuncMap["writeFile"] = writeDataFile
+}
+
+// 数据目录下的文件路径(不能跳出数据目录)
+func dataFilePath(name string) string {
+	dir := DataDir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(GetExecutableDir(), dir)
+	}
+	return filepath.Join(dir, filepath.Clean("/"+name))
+}
+
+func readDataFile(name string) string {
+	content, err := os.ReadFile(dataFilePath(name))
+	if err != nil {
+		log.Error("readFile:", err)
+		return ""
+	}
+	return string(content)
+}
+
+func writeDataFile(name, content string) bool {
+	path := dataFilePath(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Error("writeFile:", err)
+		return false
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		log.Error("writeFile:", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,270 @@
+package jsengine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"ninego/log"
+	"xiaobot/gcron"
+)
+
+/*
+在脚本中管理定时任务 require('schedule')
+	var schedule = require('schedule');
+	var filename = schedule.create({name: '起床闹钟', time: '7:00', job_cycle: 1, script: "bot.tts('该起床了', true);"});
+	schedule.create({name: '喝水提醒', time: '10:00', job_cycle: 2, cycle_details: [1,2,3,4,5], bot: 'drink'}); //执行 drink.bot
+	schedule.update(filename, {time: '7:30'});
+	schedule.activate(filename, false);
+	schedule.remove(filename);
+任务对象的字段同定时任务的配置文件(clockNNNN.json)，另外可用：
+	time    触发时间，"7:00" 或 "2025-01-01 7:00"(只有时分时为今天，一次性任务已过时为明天)
+	active  是否启用(is_active)，新建时默认启用
+	script  任务执行的脚本内容(保存为同名.job文件)
+	bot     执行的任务脚本名(如 drink 或 drink.bot)，每次执行时读取该文件
+*/
+
+func init() {
+	ModulefuncMap["schedule"]["list"] = Schedules.jsList
+	ModulefuncMap["schedule"]["get"] = Schedules.jsGet
+	ModulefuncMap["schedule"]["create"] = Schedules.jsCreate
+	ModulefuncMap["schedule"]["update"] = Schedules.jsUpdate
+	ModulefuncMap["schedule"]["activate"] = Schedules.jsActivate
+	ModulefuncMap["schedule"]["remove"] = Schedules.jsRemove
+}
+
+// 任务的脚本文件名 clockNNNN.json -> clockNNNN.job
+func jobScriptFile(filename string) string {
+	return strings.TrimSuffix(filename, ".json") + ".job"
+}
+
+// Save 保存任务配置和脚本(script为空时不修改脚本)，新任务(Filename为空)加入调度
+func (s *schedules) Save(job *gcron.CronJob, script string) error {
+	if job.BotScript != "" {
+		job.BotScript = strings.TrimSuffix(job.BotScript, ".bot")
+		if !gcron.IsExist(gcron.BotScriptPath(job.BotScript)) {
+			return fmt.Errorf("任务脚本不存在: %s.bot", job.BotScript)
+		}
+	}
+	isNew := job.Filename == ""
+	if isNew && job.BotScript == "" && strings.TrimSpace(script) == "" {
+		return fmt.Errorf("没有要执行的脚本")
+	}
+	if !isNew && s.Get(job.Filename) == nil {
+		return fmt.Errorf("找不到任务 %s", job.Filename)
+	}
+	if err := gcron.Save(job); err != nil {
+		return err
+	}
+	if script != "" {
+		if err := os.WriteFile(jobScriptFile(job.Filename), []byte(script), 0666); err != nil {
+			return err
+		}
+	}
+	if isNew {
+		s.Add(job)
+		return nil
+	}
+
+	old := s.Get(job.Filename)
+	s.Update(job)
+	if old.Schedule != nil {
+		old.Schedule.RunScript = script //为空时下次执行重新读取.job
+	}
+	if job.IsActive != (old.Schedule != nil) {
+		flag := 0
+		if job.IsActive {
+			flag = 1
+		}
+		s.SetActive(job.Filename, flag)
+	}
+	return nil
+}
+
+// Delete 删除任务及其配置和脚本文件
+func (s *schedules) Delete(filename string) error {
+	if s.Get(filename) == nil {
+		return fmt.Errorf("找不到任务 %s", filename)
+	}
+	if fnjob := jobScriptFile(filename); gcron.IsExist(fnjob) {
+		if err := os.Remove(fnjob); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	s.Remove(filename)
+	return nil
+}
+
+// 按JS对象修改任务配置，返回修改后的任务和要保存的脚本
+func jobFromObject(base *gcron.CronJob, obj map[string]interface{}) (*gcron.CronJob, string, error) {
+	fields := make(map[string]interface{})
+	data, _ := json.Marshal(base)
+	json.Unmarshal(data, &fields)
+
+	var script, clock string
+	for k, v := range obj {
+		switch k {
+		case "script":
+			script, _ = v.(string)
+		case "time":
+			clock, _ = v.(string)
+		case "active":
+			fields["is_active"] = v
+		case "filename":
+		default:
+			fields[k] = v
+		}
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, "", err
+	}
+	job := &gcron.CronJob{}
+	if err := json.Unmarshal(data, job); err != nil {
+		return nil, "", fmt.Errorf("任务配置有误: %w", err)
+	}
+	job.Filename = base.Filename
+	job.StartTime = job.StartTime.Local()
+	job.EndDate = job.EndDate.Local()
+	if clock != "" {
+		start, err := parseJobTime(clock, job.JobCycle == 0)
+		if err != nil {
+			return nil, "", err
+		}
+		job.StartTime = start
+	}
+	return job, script, nil
+}
+
+// 解析 "7:00"、"2025-01-01 7:00"，只有时分时为今天(一次性任务已过时为明天)
+func parseJobTime(value string, once bool) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02T15:04"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			now := time.Now()
+			start := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.Local)
+			if once && !start.After(now) {
+				start = start.AddDate(0, 0, 1)
+			}
+			return start, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("时间格式有误: %s", value)
+}
+
+// 任务转为JS对象(配置字段+描述、启用状态、下次执行时间)
+func jobObject(job *gcron.CronJob) map[string]interface{} {
+	obj := make(map[string]interface{})
+	data, _ := json.Marshal(job)
+	json.Unmarshal(data, &obj)
+	obj["description"] = job.ParseScheduleDescription()
+	obj["active"] = job.Schedule != nil
+	if job.Schedule != nil && !job.Schedule.NextTime.IsZero() {
+		obj["next"] = job.Schedule.NextTime.Format(time.RFC3339)
+	}
+	return obj
+}
+
+// schedule.list() 全部任务(按触发时刻排序)
+func (s *schedules) jsList() []map[string]interface{} {
+	list := s.List()
+	result := make([]map[string]interface{}, 0, len(list))
+	for _, job := range list {
+		result = append(result, jobObject(job))
+	}
+	return result
+}
+
+// schedule.get(filename) 任务配置及脚本内容，不存在时返回null
+func (s *schedules) jsGet(filename string) map[string]interface{} {
+	job := s.Get(filename)
+	if job == nil {
+		return nil
+	}
+	obj := jobObject(job)
+	if content, err := os.ReadFile(jobScriptFile(filename)); err == nil {
+		obj["script"] = string(content)
+	}
+	return obj
+}
+
+// schedule.create(obj) 新建任务，返回任务文件名，失败时返回空字符串
+func (s *schedules) jsCreate(obj interface{}) string {
+	fields, _ := obj.(map[string]interface{})
+	if fields == nil {
+		log.Error("schedule.create: 参数须为对象")
+		return ""
+	}
+	if _, ok := fields["is_active"]; !ok {
+		if _, ok := fields["active"]; !ok {
+			fields["is_active"] = true
+		}
+	}
+	job, script, err := jobFromObject(&gcron.CronJob{StartTime: time.Now(), Name: "定时任务"}, fields)
+	if err == nil {
+		err = s.Save(job, script)
+	}
+	if err != nil {
+		log.Error("schedule.create:", err)
+		return ""
+	}
+	log.Printf("脚本新建定时任务: %s(%s)\n", job.Name, job.Filename)
+	return job.Filename
+}
+
+// schedule.update(filename, obj) 修改任务(只修改obj中的字段)
+func (s *schedules) jsUpdate(filename string, obj interface{}) bool {
+	fields, _ := obj.(map[string]interface{})
+	job := s.Get(filename)
+	if job == nil || fields == nil {
+		log.Error("schedule.update: 找不到任务或参数不是对象", filename)
+		return false
+	}
+	if _, ok := fields["is_active"]; !ok {
+		if _, ok := fields["active"]; !ok {
+			fields["is_active"] = job.Schedule != nil
+		}
+	}
+	updated, script, err := jobFromObject(job, fields)
+	if err == nil {
+		err = s.Save(updated, script)
+	}
+	if err != nil {
+		log.Error("schedule.update:", err)
+		return false
+	}
+	return true
+}
+
+// schedule.activate(filename, active) 启用/停用任务，返回任务是否生效(已过期的任务返回false)
+func (s *schedules) jsActivate(filename string, active bool) bool {
+	if s.Get(filename) == nil {
+		log.Error("schedule.activate: 找不到任务", filename)
+		return false
+	}
+	flag := 0
+	if active {
+		flag = 1
+	}
+	return s.SetActive(filename, flag) == 1
+}
+
+// schedule.remove(filename) 删除任务
+func (s *schedules) jsRemove(filename string) bool {
+	if err := s.Delete(filename); err != nil {
+		log.Error("schedule.remove:", err)
+		return false
+	}
+	log.Println("脚本删除定时任务:", filename)
+	return true
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"sync"
 	"time"
 	"xiaobot/gcron"
 
@@ -26,10 +27,10 @@ func (e DefaultScriptExecutor) Execute(script string, name string) error {
 	}()
 	global.Update("runcount", +1)
 
-	// 创建 JS 虚拟机
-	engine := vmPool.Get().(*Engine)
+	// 创建 JS 虚拟机(name为脚本文件名，用于读取权限声明)
+	engine := getEngine(ContextSchedule, name, script)
 	// 将 VM 放回池中以供将来重用
-	defer vmPool.Put(engine)
+	defer putEngine(engine)
 
 	// 将 Go 对象注入 JS 全局作用域
 	//engine.Runtime.Set("timestr", timeStr)
@@ -47,17 +48,59 @@ func (e DefaultScriptExecutor) Execute(script string, name string) error {
 	return nil
 }
 
+// Fired 定时任务触发(满足执行条件)时通知事件脚本
+func (e DefaultScriptExecutor) Fired(filename, name string) {
+	go Hooks.Emit(EventScheduleFired, map[string]interface{}{"filename": filename, "name": name})
+}
+
+// Evaluate 计算定时任务的执行条件(JS表达式)，可使用bot对象(如bot.storage)；
+// 表达式可由网页提交，使用只读权限(ContextCondition)执行
+func (e DefaultScriptExecutor) Evaluate(expr string) (met bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	engine := getEngine(ContextCondition, "", "")
+	defer putEngine(engine)
+
+	value, err := engine.RunString("!!(" + expr + "\n)")
+	if err != nil {
+		if evalErr, ok := err.(*goja.Exception); ok {
+			return false, fmt.Errorf("%s", evalErr.String())
+		}
+		return false, err
+	}
+	met, _ = value.(bool)
+	return met, nil
+}
+
 var Schedules = &schedules{
 	Jobs: make(map[string]*gcron.CronJob),
 	Cron: gcron.New(),
 }
 
+func init() {
+	BotfuncMap["previewSchedule"] = Schedules.Preview
+	ModulefuncMap["schedule"]["preview"] = Schedules.Preview
+}
+
 type schedules struct {
+	mu   sync.RWMutex //Jobs 会被定时器、网页、事件脚本等协程同时访问
 	Jobs map[string]*gcron.CronJob
 	Cron *gcron.Cron
 }
 
+// Get 按文件名取任务，不存在时返回nil
+func (s *schedules) Get(filename string) *gcron.CronJob {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Jobs[filename]
+}
+
 func (s *schedules) Update(task *gcron.CronJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	job := s.Jobs[task.Filename]
 	if job == nil {
 		log.Printf("错误：找不到任务 %s\n", task.Filename)
@@ -66,7 +109,7 @@ func (s *schedules) Update(task *gcron.CronJob) {
 
 	// 修复：更新任务配置
 	job.Assign(task)
-	log.Printf("更新任务: %+v\n", s.Jobs[task.Filename])
+	log.Printf("更新任务: %+v\n", job)
 
 	// 如果任务有调度计划，需要重置调度器
 	if job.Schedule != nil {
@@ -76,6 +119,8 @@ func (s *schedules) Update(task *gcron.CronJob) {
 }
 
 func (s *schedules) Add(task *gcron.CronJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	// 添加任务到任务列表
 	s.Jobs[task.Filename] = task
 
@@ -92,6 +137,8 @@ func (s *schedules) Add(task *gcron.CronJob) {
 }
 
 func (s *schedules) Remove(filename string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	job := s.Jobs[filename]
 	if job == nil {
 		return
@@ -107,12 +154,25 @@ func (s *schedules) Remove(filename string) {
 	delete(s.Jobs, filename)
 }
 
+// Refresh 节假日等外部数据变化后，重新计算所有启用任务的下次执行时间
+func (s *schedules) Refresh() {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, job := range s.Jobs {
+		if job.Schedule != nil {
+			s.Cron.Reset(job.Schedule)
+		}
+	}
+}
+
 // sortMapByTime()
 func (s *schedules) List() []*gcron.CronJob {
 	var pairs []*gcron.CronJob
+	s.mu.RLock()
 	for _, v := range s.Jobs {
 		pairs = append(pairs, v)
 	}
+	s.mu.RUnlock()
 
 	// 按值升序排序
 	sort.Slice(pairs, func(i, j int) bool {
@@ -126,6 +186,8 @@ func (s *schedules) List() []*gcron.CronJob {
 }
 
 func (s *schedules) SetActive(id string, flag int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	job := s.Jobs[id]
 	if job == nil {
 		log.Printf("错误：找不到任务 %s\n", id)
@@ -186,6 +248,25 @@ func (s *schedules) SetActive(id string, flag int) int {
 	}
 }
 
+// Preview 预览任务之后的count次触发时间（供js调用: bot.previewSchedule(filename, count)）
+func (s *schedules) Preview(filename string, count int) []map[string]interface{} {
+	job := s.Get(filename)
+	if job == nil {
+		return nil
+	}
+	list := job.Preview(time.Now(), time.Time{}, count)
+	result := make([]map[string]interface{}, 0, len(list))
+	for _, o := range list {
+		result = append(result, map[string]interface{}{
+			"time":        o.Time.Format(time.RFC3339),
+			"skipped":     o.Skipped,
+			"reason":      o.Reason,
+			"conditional": o.Conditional,
+		})
+	}
+	return result
+}
+
 func (s *schedules) FetchFromExecDir() error {
 	// 使用Glob函数匹配所有.json文件，支持通配符
 	filePaths, err := filepath.Glob(GetExecutableDir() + "/clock*.json")
@@ -198,21 +279,28 @@ func (s *schedules) FetchFromExecDir() error {
 			if task == nil {
 				continue
 			}
+			s.mu.Lock()
 			s.Jobs[task.Filename] = task //s.Add(task)
+			s.mu.Unlock()
 			log.Println(filePath)
 		}
+		s.mu.RLock()
 		log.Debug("fetch jobs ->", len(s.Jobs), s.Jobs)
+		s.mu.RUnlock()
 	}
 	return err
 }
 
 func (s *schedules) Start() {
+	s.mu.RLock()
 	for _, task := range s.Jobs {
 		if task.IsActive {
 			s.Cron.Schedule(task, DefaultScriptExecutor{})
 		}
 	}
+	s.mu.RUnlock()
 	s.Cron.Start()
+	gcron.HolidaysChanged = s.Refresh //启动后节假日数据下载完成时重新计算
 }
 
 func (s *schedules) Stop() {
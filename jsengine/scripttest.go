@@ -0,0 +1,289 @@
+package jsengine
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"xiaobot/gcron"
+	"xiaobot/jsengine/console"
+
+	"github.com/dop251/goja"
+)
+
+/*
+脚本测试
+	程序目录下的 *.test.js 是脚本的测试，命令行 xiaobot test-scripts 或网页“脚本测试”运行：
+	test('早上好', function () {
+		var r = run('query.bot', {query: '早上好'});
+		assert.equal(r.handled, true);
+		assert.said('早上好');
+	});
+	每个测试文件在独立的VM中运行：bot 为模拟对象(记录调用，不控制音箱)，fetch 只返回 mock.fetch 注册的响应，
+	setTimeout/setInterval/Date 使用假时钟(clock.tick 推进)，bot.storage/bot.store 使用内存中的存储(每个测试前清空)。
+	测试文件没有任何权限(require('miot')、require('schedule')、执行命令等会被拒绝)。
+	结果为JSON(TestReport)，见 js脚本引擎.md 的“脚本测试”。
+*/
+
+//go:embed scripttest.js
+var scriptTestHarness string
+
+// TestCase 一个测试的结果
+type TestCase struct {
+	Name     string `json:"name"`
+	Passed   bool   `json:"passed"`
+	Skipped  bool   `json:"skipped,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Duration int64  `json:"duration_ms"`
+}
+
+// TestFile 一个测试文件的结果
+type TestFile struct {
+	File     string     `json:"file"`
+	Tests    []TestCase `json:"tests"`
+	Passed   int        `json:"passed"`
+	Failed   int        `json:"failed"`
+	Skipped  int        `json:"skipped"`
+	Error    string     `json:"error,omitempty"`   //测试文件无法运行(语法错误、超时等)
+	Console  []string   `json:"console,omitempty"` //console的输出
+	Duration int64      `json:"duration_ms"`
+}
+
+// TestReport 全部测试文件的结果，OK为没有失败的测试和出错的文件
+type TestReport struct {
+	OK       bool        `json:"ok"`
+	Files    []*TestFile `json:"files"`
+	Passed   int         `json:"passed"`
+	Failed   int         `json:"failed"`
+	Skipped  int         `json:"skipped"`
+	Duration int64       `json:"duration_ms"`
+}
+
+const scriptTestSuffix = ".test.js"
+
+// FindScriptTests 程序目录下的测试文件名(按名称排序)
+func FindScriptTests() []string {
+	files, _ := filepath.Glob(filepath.Join(GetExecutableDir(), "*"+scriptTestSuffix))
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, filepath.Base(file))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RunScriptTests 运行测试文件(文件名或路径，为空时运行程序目录下的全部测试)
+func RunScriptTests(files ...string) *TestReport {
+	start := time.Now()
+	if len(files) == 0 {
+		files = FindScriptTests()
+	}
+	report := &TestReport{Files: []*TestFile{}}
+	for _, file := range files {
+		result := RunScriptTest(file)
+		report.Files = append(report.Files, result)
+		report.Passed += result.Passed
+		report.Failed += result.Failed
+		report.Skipped += result.Skipped
+		if result.Error != "" {
+			report.Failed++
+		}
+	}
+	report.OK = report.Failed == 0
+	report.Duration = time.Since(start).Milliseconds()
+	return report
+}
+
+// 测试文件和被测脚本的路径，相对路径在程序目录下查找
+func scriptTestPath(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	if _, err := os.Stat(name); err == nil && strings.ContainsAny(name, `/\`) {
+		return name
+	}
+	return filepath.Join(GetExecutableDir(), name)
+}
+
+// RunScriptTest 运行一个测试文件
+func RunScriptTest(file string) *TestFile {
+	start := time.Now()
+	result := &TestFile{File: filepath.Base(file), Tests: []TestCase{}}
+	defer func() {
+		result.Duration = time.Since(start).Milliseconds()
+	}()
+	path := scriptTestPath(file)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	eng := NewEngine(nil)
+	defer sandboxes.Delete(eng.Runtime)
+	defer eng.closeStreams()
+	enableRequire(eng.Runtime)
+	console.EnableOutput(eng.Runtime, func(level, source, text string) {
+		line := fmt.Sprintf("[%s] %s", level, text)
+		if source != "" {
+			line += " (" + source + ")"
+		}
+		result.Console = append(result.Console, line)
+	})
+	eng.sandbox.grant(result.File, &Permissions{})
+	eng.timeout = timeoutOf(ContextTest)
+
+	runAll, err := setupScriptTest(eng, filepath.Dir(path))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if _, err := eng.runLoop(func(rt *goja.Runtime) (goja.Value, error) {
+		return rt.RunScript(result.File, string(content))
+	}); err != nil {
+		result.Error = errorText(err)
+		return result
+	}
+	value, err := eng.CallFunction(runAll)
+	if err != nil {
+		result.Error = errorText(err)
+		return result
+	}
+	text, ok := value.(string)
+	if !ok {
+		result.Error = "测试未完成(有未完成的Promise)"
+		return result
+	}
+	if err := json.Unmarshal([]byte(text), &result.Tests); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	for _, t := range result.Tests {
+		switch {
+		case t.Skipped:
+			result.Skipped++
+		case t.Passed:
+			result.Passed++
+		default:
+			result.Failed++
+		}
+	}
+	return result
+}
+
+// 注入测试环境，返回运行全部测试的函数；dir为测试文件所在目录(被测脚本的目录)
+func setupScriptTest(eng *Engine, dir string) (goja.Callable, error) {
+	rt := eng.Runtime
+	st := NewStorage("", "") //只在内存中
+	botMap := map[string]interface{}{}
+	bindStorageOf(rt, botMap, st)
+
+	native := rt.NewObject()
+	native.Set("storage", botMap["storage"])
+	native.Set("store", botMap["store"])
+	native.Set("resetStorage", func() {
+		st.mu.Lock()
+		st.spaces = make(map[string]map[string]*storageEntry)
+		st.mu.Unlock()
+	})
+	// 当前运行的脚本名(bot.store()的默认命名空间)
+	native.Set("setScript", func(name string) {
+		eng.sandbox.grant(name, &Permissions{})
+	})
+	// 读取被测脚本：query.bot、任务脚本(.bot)、定时任务(clock*.json 执行其 bot 或同名 .job 脚本)、.job
+	native.Set("load", func(name string) (map[string]interface{}, error) {
+		return loadTestTarget(dir, name)
+	})
+	// 编译脚本，行号与脚本文件一致；query.bot 的 handled 通过 result.handled 读取
+	native.Set("compile", func(name, source string) (goja.Value, error) {
+		wrapper := "(function (__result, query, req, res) { var handled = false; " +
+			"Object.defineProperty(__result, 'handled', {get: function () { return handled; }, enumerable: true}); " +
+			source + "\n})"
+		return rt.RunScript(name, wrapper)
+	})
+	// 从假时钟的时间预览定时任务的触发时间
+	native.Set("preview", func(filename string, from int64, count int) ([]map[string]interface{}, error) {
+		job := Schedules.Get(filename)
+		if job == nil {
+			if job = gcron.Load(filepath.Join(dir, filename)); job == nil {
+				return nil, errors.New("找不到定时任务: " + filename)
+			}
+		}
+		list := job.Preview(time.UnixMilli(from), time.Time{}, count)
+		result := make([]map[string]interface{}, 0, len(list))
+		for _, o := range list {
+			result = append(result, map[string]interface{}{
+				"time":        o.Time.Format(time.RFC3339),
+				"skipped":     o.Skipped,
+				"reason":      o.Reason,
+				"conditional": o.Conditional,
+			})
+		}
+		return result, nil
+	})
+
+	// 按假时钟的时间和测试的存储渲染提示词模板
+	native.Set("renderPrompt", func(text string, vars map[string]interface{}, now int64) (string, error) {
+		return renderPromptAt(text, vars, time.UnixMilli(now), st)
+	})
+
+	setup, err := rt.RunScript("scripttest.js", scriptTestHarness)
+	if err != nil {
+		return nil, err
+	}
+	fn, ok := goja.AssertFunction(setup)
+	if !ok {
+		return nil, errors.New("scripttest.js 有误")
+	}
+	runAll, err := fn(nil, native)
+	if err != nil {
+		return nil, err
+	}
+	if runAll, ok := goja.AssertFunction(runAll); ok {
+		return runAll, nil
+	}
+	return nil, errors.New("scripttest.js 有误")
+}
+
+// 被测脚本的名称、类型(query/task/schedule)和内容
+func loadTestTarget(dir, name string) (map[string]interface{}, error) {
+	base := filepath.Base(name)
+	kind := ContextTask
+	switch {
+	case base == "query.bot":
+		kind = ContextQuery
+	case strings.HasSuffix(base, ".job"):
+		kind = ContextSchedule
+	case strings.HasSuffix(base, ".json"):
+		job := gcron.Load(filepath.Join(dir, base))
+		if job == nil {
+			return nil, errors.New("找不到定时任务: " + base)
+		}
+		script := strings.TrimSuffix(base, ".json") + ".job"
+		if job.BotScript != "" {
+			script = job.BotScript + ".bot"
+		}
+		target, err := loadTestTarget(dir, script)
+		if err == nil {
+			target["kind"] = ContextSchedule //定时执行时没有 req/res
+		}
+		return target, err
+	case !strings.HasSuffix(base, ".bot"):
+		base += ".bot"
+	}
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, filepath.Dir(name), base)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"name": base, "kind": kind, "source": string(content)}, nil
+}
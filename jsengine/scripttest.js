@@ -0,0 +1,602 @@
+// 脚本测试(*.test.js)的运行环境：模拟的bot、假时钟、fetch桩和断言，由 scripttest.go 注入
+(function (native) {
+	'use strict';
+
+	var realSetTimeout = setTimeout, realClearTimeout = clearTimeout;
+	var RealDate = Date;
+	var TEST_TIMEOUT = 5000; //异步测试的最长等待时间(真实时间，毫秒)
+
+	// ------------------------------
+	// 断言
+	// ------------------------------
+
+	class AssertionError extends Error {
+		constructor(message) {
+			super(message);
+			this.name = 'AssertionError';
+		}
+	}
+
+	function show(value) {
+		if (typeof value === 'string') return JSON.stringify(value);
+		if (typeof value === 'function') return '[Function' + (value.name ? ': ' + value.name : '') + ']';
+		if (value instanceof RegExp) return String(value);
+		try {
+			var s = JSON.stringify(value);
+			return s === undefined ? String(value) : s;
+		} catch (e) {
+			return String(value);
+		}
+	}
+
+	function fail(message, defaultMessage) {
+		throw new AssertionError(message || defaultMessage);
+	}
+
+	function deepEqual(a, b) {
+		if (a === b) return true;
+		if (typeof a === 'number' && typeof b === 'number') return a !== a && b !== b;
+		if (a === null || b === null || typeof a !== 'object' || typeof b !== 'object') return false;
+		if (a instanceof RealDate || b instanceof RealDate) {
+			return a instanceof RealDate && b instanceof RealDate && a.getTime() === b.getTime();
+		}
+		if (Array.isArray(a) !== Array.isArray(b)) return false;
+		var ka = Object.keys(a), kb = Object.keys(b);
+		if (ka.length !== kb.length) return false;
+		for (var i = 0; i < ka.length; i++) {
+			if (!Object.prototype.hasOwnProperty.call(b, ka[i]) || !deepEqual(a[ka[i]], b[ka[i]])) return false;
+		}
+		return true;
+	}
+
+	// 错误是否符合期望：构造函数、正则(匹配message)、字符串(包含于message)
+	function errorMatches(err, expected) {
+		if (expected === undefined) return true;
+		var message = err && err.message !== undefined ? String(err.message) : String(err);
+		if (typeof expected === 'function') return err instanceof expected;
+		if (expected instanceof RegExp) return expected.test(message);
+		return message.indexOf(String(expected)) >= 0;
+	}
+
+	function assert(value, message) {
+		if (!value) fail(message, '期望为真值，实际为 ' + show(value));
+	}
+	assert.ok = assert;
+	assert.fail = function (message) {
+		fail(message, '断言失败');
+	};
+	assert.equal = function (actual, expected, message) {
+		if (actual !== expected) fail(message, '期望 ' + show(expected) + '，实际为 ' + show(actual));
+	};
+	assert.notEqual = function (actual, expected, message) {
+		if (actual === expected) fail(message, '期望不等于 ' + show(expected));
+	};
+	assert.deepEqual = function (actual, expected, message) {
+		if (!deepEqual(actual, expected)) fail(message, '期望 ' + show(expected) + '，实际为 ' + show(actual));
+	};
+	assert.match = function (actual, re, message) {
+		if (typeof actual !== 'string' || !re.test(actual)) fail(message, show(actual) + ' 不匹配 ' + String(re));
+	};
+	// 字符串包含子串，或数组包含(深比较)元素
+	assert.includes = function (actual, expected, message) {
+		var found = false;
+		if (typeof actual === 'string') {
+			found = actual.indexOf(expected) >= 0;
+		} else if (Array.isArray(actual)) {
+			found = actual.some(function (item) { return deepEqual(item, expected); });
+		}
+		if (!found) fail(message, show(actual) + ' 不包含 ' + show(expected));
+	};
+	assert.throws = function (fn, expected, message) {
+		try {
+			fn();
+		} catch (err) {
+			if (!errorMatches(err, expected)) fail(message, '抛出的错误不符合期望: ' + show(err && err.message));
+			return err;
+		}
+		fail(message, '期望抛出错误');
+	};
+	assert.rejects = async function (promise, expected, message) {
+		try {
+			await (typeof promise === 'function' ? promise() : promise);
+		} catch (err) {
+			if (!errorMatches(err, expected)) fail(message, '拒绝的错误不符合期望: ' + show(err && err.message));
+			return err;
+		}
+		fail(message, '期望Promise被拒绝');
+	};
+	// bot方法(或fetch)的调用次数，未指定times时至少调用一次
+	assert.called = function (name, times, message) {
+		var n = calls(name).length;
+		if (times === undefined ? n === 0 : n !== times) {
+			fail(message, 'bot.' + name + ' 期望调用' + (times === undefined ? '' : times + '次') + '，实际调用' + n + '次');
+		}
+	};
+	assert.notCalled = function (name, message) {
+		assert.called(name, 0, message);
+	};
+	// 音箱播报过的文字包含text(字符串或正则)
+	assert.said = function (text, message) {
+		var list = said();
+		var ok = list.some(function (s) { return text instanceof RegExp ? text.test(s) : s.indexOf(text) >= 0; });
+		if (!ok) fail(message, '期望播报 ' + show(text) + '，实际播报 ' + show(list));
+	};
+
+	// ------------------------------
+	// 调用记录和模拟
+	// ------------------------------
+
+	var callLog = [];
+	var baseMocks = {askAI: [], fetch: [], bot: {}, files: {}};
+	var testMocks = null; //测试中注册的模拟，测试结束后清除
+
+	function mocks() {
+		return testMocks || baseMocks;
+	}
+
+	function record(name, args) {
+		callLog.push({name: name, args: Array.prototype.slice.call(args), time: clockNow});
+	}
+
+	// calls('tts') 返回每次调用的参数数组；calls() 返回全部调用 [{name, args, time}]
+	function calls(name) {
+		if (name === undefined) return callLog.slice();
+		return callLog.filter(function (c) { return c.name === name; }).map(function (c) { return c.args; });
+	}
+
+	// 音箱播报过的文字
+	function said() {
+		return calls('tts').map(function (args) { return String(args[0]); });
+	}
+
+	// 字符串(包含)、正则、函数
+	function matches(pattern, value) {
+		if (typeof pattern === 'function') return !!pattern(value);
+		if (pattern instanceof RegExp) return pattern.test(value);
+		return String(value).indexOf(String(pattern)) >= 0;
+	}
+
+	// 先查找测试中注册的，再查找文件顶层注册的，后注册的优先
+	function findMock(kind, value) {
+		var lists = testMocks ? [testMocks[kind], baseMocks[kind]] : [baseMocks[kind]];
+		for (var i = 0; i < lists.length; i++) {
+			for (var j = lists[i].length - 1; j >= 0; j--) {
+				if (matches(lists[i][j].pattern, value)) return lists[i][j];
+			}
+		}
+		return null;
+	}
+
+	function botOverride(name) {
+		if (testMocks && testMocks.bot[name]) return testMocks.bot[name];
+		return baseMocks.bot[name];
+	}
+
+	function mockFile(name) {
+		if (testMocks && name in testMocks.files) return testMocks.files[name];
+		return baseMocks.files[name];
+	}
+
+	var mock = {
+		// mock.askAI('天气', '晴，25度')、mock.askAI(/翻译/, function (q) { ... })
+		askAI: function (pattern, reply) {
+			mocks().askAI.push({pattern: pattern, reply: reply});
+		},
+		// mock.fetch('https://api.example.com/weather', {json: {...}})，响应可为 {status, headers, body|json, error} 或 function (url, init)
+		fetch: function (pattern, response) {
+			mocks().fetch.push({pattern: pattern, response: response});
+		},
+		// mock.bot('idle', function () { return 600; }) 替换或增加bot方法
+		bot: function (name, fn) {
+			mocks().bot[name] = fn;
+		},
+		// mock.file('list.txt', '内容') bot.readFile 读取的内容
+		file: function (name, content) {
+			mocks().files[name] = String(content);
+		}
+	};
+
+	// 模拟的bot：记录每次调用，可用 mock.bot 替换
+	function botMethod(name, impl) {
+		return function () {
+			record(name, arguments);
+			var override = botOverride(name);
+			return (override || impl).apply(null, arguments);
+		};
+	}
+
+	function noop() {}
+
+	var bot = {
+		tts: botMethod('tts', function () { return true; }),
+		action: botMethod('action', noop),
+		playurl: botMethod('playurl', noop),
+		stopspeaker: botMethod('stopspeaker', noop),
+		wakeup: botMethod('wakeup', noop),
+		wait: botMethod('wait', noop),
+		monitor: botMethod('monitor', noop),
+		askAI: botMethod('askAI', function (query) {
+			var m = findMock('askAI', query);
+			if (!m) return '';
+			return typeof m.reply === 'function' ? m.reply(query) : String(m.reply);
+		}),
+		// bot.sleep 不真正等待，把假时钟向前拨
+		sleep: botMethod('sleep', function (sec) {
+			clock.tick((+sec || 0) * 1000);
+		}),
+		elapsed: botMethod('elapsed', function (text) {
+			return Math.ceil(String(text || '').length / 4);
+		}),
+		idle: botMethod('idle', function () { return -1; }),
+		dialog: botMethod('dialog', noop),			//只记录调用，assert.called('dialog') 检查开始的对话
+		persona: botMethod('persona', function () { return mocks().persona || ''; }),
+		setPersona: botMethod('setPersona', function (name) {
+			mocks().persona = name === '默认' ? '' : String(name || '');
+		}),
+		readFile: botMethod('readFile', function (name) {
+			var content = mockFile(name);
+			return content === undefined ? '' : content;
+		}),
+		writeFile: botMethod('writeFile', function (name, content) {
+			mocks().files[name] = String(content);
+			return true;
+		}),
+		previewSchedule: botMethod('previewSchedule', function (filename, count) {
+			return native.preview(filename, clockNow, count || 0);
+		}),
+		renderPrompt: botMethod('renderPrompt', function (text, vars) {
+			return native.renderPrompt(String(text), vars || {}, clockNow);
+		}),
+		storage: native.storage,
+		store: native.store
+	};
+	var botProxy = new Proxy(bot, {
+		get: function (target, name) {
+			if (name in target) return target[name];
+			var override = typeof name === 'string' && botOverride(name);
+			if (!override) return undefined;
+			return function () {
+				record(name, arguments);
+				return override.apply(null, arguments);
+			};
+		}
+	});
+
+	// ------------------------------
+	// fetch 桩：未模拟的请求会失败，测试不会访问网络
+	// ------------------------------
+
+	async function fakeFetch(input, init) {
+		init = init || {};
+		var url = typeof input === 'string' ? input : String(input && input.url !== undefined ? input.url : input);
+		var method = (init.method || (input && input.method) || 'GET').toUpperCase();
+		record('fetch', [url, init]);
+		var m = findMock('fetch', url);
+		if (!m) throw new TypeError('未模拟的请求: ' + method + ' ' + url + '，请使用 mock.fetch(url, 响应)');
+		var resp = typeof m.response === 'function' ? await m.response(url, init) : m.response;
+		resp = resp || {};
+		if (resp.error) throw new TypeError(String(resp.error));
+		var headers = Object.assign({}, resp.headers || {});
+		var body = resp.body;
+		if (resp.json !== undefined) {
+			body = JSON.stringify(resp.json);
+			if (!Object.keys(headers).some(function (k) { return k.toLowerCase() === 'content-type'; })) {
+				headers['Content-Type'] = 'application/json';
+			}
+		}
+		var response = new Response(body === undefined || body === null ? null : String(body), {
+			status: resp.status || 200,
+			statusText: resp.statusText || '',
+			headers: headers
+		});
+		try {
+			Object.defineProperty(response, 'url', {value: url});
+		} catch (e) {}
+		return response;
+	}
+
+	// ------------------------------
+	// 假时钟：setTimeout/setInterval/Date 使用模拟的时间，clock.tick(毫秒) 触发到期的定时器
+	// ------------------------------
+
+	var clockStart = RealDate.now();
+	var clockNow = clockStart, timers = [], timerSeq = 0;
+
+	function addTimer(fn, delay, args, repeat) {
+		if (typeof fn !== 'function') return 0;
+		delay = Math.max(0, +delay || 0);
+		var id = ++timerSeq;
+		timers.push({id: id, at: clockNow + delay, fn: fn, args: args, interval: repeat ? Math.max(1, delay) : 0});
+		return id;
+	}
+
+	function removeTimer(id) {
+		timers = timers.filter(function (t) { return t.id !== id; });
+	}
+
+	// 到期时间不晚于limit的最早的定时器
+	function nextTimer(limit) {
+		var next = null;
+		timers.forEach(function (t) {
+			if (t.at <= limit && (!next || t.at < next.at || (t.at === next.at && t.id < next.id))) next = t;
+		});
+		return next;
+	}
+
+	function fire(t) {
+		clockNow = Math.max(clockNow, t.at);
+		if (t.interval) {
+			t.at += t.interval;
+		} else {
+			removeTimer(t.id);
+		}
+		t.fn.apply(null, t.args);
+	}
+
+	function toMillis(time) {
+		if (time instanceof RealDate) return time.getTime();
+		if (typeof time === 'number') return time;
+		var ms = new RealDate(time).getTime();
+		if (ms !== ms) throw new TypeError('无效的时间: ' + time);
+		return ms;
+	}
+
+	// 执行排队中的Promise回调(等待一次真实的事件循环)
+	function flush() {
+		return new Promise(function (resolve) { realSetTimeout(resolve, 0); });
+	}
+
+	var clock = {
+		now: function () { return clockNow; },
+		// clock.set('2025-01-01 07:00') 设置当前时间，不触发定时器
+		set: function (time) {
+			clockNow = toMillis(time);
+			return clockNow;
+		},
+		// 时间前进ms毫秒，按顺序同步触发到期的定时器，返回触发的次数
+		tick: function (ms) {
+			var target = clockNow + Math.max(0, +ms || 0), count = 0, t;
+			while ((t = nextTimer(target))) {
+				fire(t);
+				count++;
+			}
+			clockNow = target;
+			return count;
+		},
+		// 同tick，每次触发后执行排队中的Promise回调，用于 await 定时器的异步代码：await clock.tickAsync(1000)
+		tickAsync: async function (ms) {
+			var target = clockNow + Math.max(0, +ms || 0), count = 0, t;
+			await flush();
+			while ((t = nextTimer(target))) {
+				fire(t);
+				count++;
+				await flush();
+			}
+			clockNow = target;
+			return count;
+		},
+		// 触发全部定时器(setInterval最多触发limit次)
+		runAll: function (limit) {
+			limit = limit || 1000;
+			var count = 0, t;
+			while ((t = nextTimer(Infinity))) {
+				if (count++ >= limit) throw new Error('定时器触发超过' + limit + '次，可能有未清除的setInterval');
+				fire(t);
+			}
+			return count;
+		},
+		pending: function () { return timers.length; },
+		reset: function () {
+			timers = [];
+			clockNow = clockStart;
+		}
+	};
+
+	class FakeDate extends RealDate {
+		constructor() {
+			if (arguments.length === 0) {
+				super(clockNow);
+			} else {
+				super(...arguments);
+			}
+		}
+		static now() {
+			return clockNow;
+		}
+	}
+
+	// ------------------------------
+	// 执行被测脚本
+	// ------------------------------
+
+	function valuesOf(obj) {
+		var result = {};
+		Object.keys(obj || {}).forEach(function (k) {
+			result[k] = Array.isArray(obj[k]) ? obj[k].map(String) : [String(obj[k])];
+		});
+		return result;
+	}
+
+	function parseQuery(url) {
+		var result = {};
+		var i = url.indexOf('?');
+		if (i < 0) return result;
+		url.slice(i + 1).split('&').forEach(function (pair) {
+			if (!pair) return;
+			var j = pair.indexOf('=');
+			var k = decodeURIComponent((j < 0 ? pair : pair.slice(0, j)).replace(/\+/g, ' '));
+			var v = j < 0 ? '' : decodeURIComponent(pair.slice(j + 1).replace(/\+/g, ' '));
+			(result[k] = result[k] || []).push(v);
+		});
+		return result;
+	}
+
+	// 任务脚本的 req/res，与 /task/{action} 调用时的方法相同
+	function taskContext(name, options, result) {
+		var action = name.replace(/\.bot$/, '');
+		var url = options.url || '/task/' + action;
+		var body = options.body === undefined ? '' : (typeof options.body === 'string' ? options.body : JSON.stringify(options.body));
+		var headers = {};
+		Object.keys(options.headers || {}).forEach(function (k) {
+			var key = k.toLowerCase().replace(/(^|-)([a-z])/g, function (m, p, c) { return p + c.toUpperCase(); });
+			headers[key] = valuesOf({v: options.headers[k]}).v;
+		});
+		var req = {
+			method: function () { return (options.method || 'GET').toUpperCase(); },
+			url: function () { return url; },
+			headers: function () { return headers; },
+			body: function () { return body; },
+			params: function () { return Object.assign({action: action}, options.params || {}); },
+			query: function () { return options.query ? valuesOf(options.query) : parseQuery(url); }
+		};
+		var done = false;
+		var res = {
+			status: function (code) {
+				if (!done) result.status = code;
+			},
+			send: function (text) {
+				if (done) return;
+				done = true;
+				result.status = result.status || 200;
+				result.body = String(text);
+				if (!result.headers['Content-Type']) result.headers['Content-Type'] = 'text/plain; charset=utf-8';
+			},
+			json: function (data) {
+				if (done) return;
+				done = true;
+				result.status = result.status || 200;
+				result.body = JSON.stringify(data);
+				result.json = JSON.parse(result.body);
+				result.headers['Content-Type'] = 'application/json; charset=utf-8';
+			},
+			set: function (key, value) {
+				if (!done) result.headers[key] = String(value);
+			},
+			redirect: function (location) {
+				if (done) return;
+				done = true;
+				result.status = 302;
+				result.redirect = location;
+				result.headers['Location'] = location;
+			}
+		};
+		return {req: req, res: res};
+	}
+
+	// run('query.bot', {query: '早上好'})、run('welcome.bot', {method: 'POST', body: {...}})、run('clock0001.json')
+	function run(name, options) {
+		options = options || {};
+		var script = native.load(name);
+		native.setScript(script.name);
+		var result = {file: script.name, kind: script.kind, handled: false, status: 0, body: undefined, json: undefined, headers: {}, redirect: undefined};
+		var fn = native.compile(script.name, script.source);
+		var query = script.kind === 'query' ? String(options.query === undefined ? '' : options.query) : undefined;
+		var ctx = script.kind === 'task' ? taskContext(script.name, options, result) : {};
+		fn(result, query, ctx.req, ctx.res);
+		return result;
+	}
+
+	// ------------------------------
+	// 测试
+	// ------------------------------
+
+	var tests = [], beforeHooks = [], afterHooks = [];
+
+	function test(name, fn, timeout) {
+		tests.push({name: String(name), fn: fn, timeout: timeout || TEST_TIMEOUT});
+	}
+	test.skip = function (name) {
+		tests.push({name: String(name), skip: true});
+	};
+
+	function beforeEach(fn) {
+		beforeHooks.push(fn);
+	}
+
+	function afterEach(fn) {
+		afterHooks.push(fn);
+	}
+
+	function withTimeout(promise, ms) {
+		var timer;
+		return Promise.race([
+			promise,
+			new Promise(function (resolve, reject) {
+				timer = realSetTimeout(function () {
+					reject(new Error('测试超过' + ms + '毫秒未完成(等待定时器的代码请使用 clock.tickAsync)'));
+				}, ms);
+			})
+		]).finally(function () { realClearTimeout(timer); });
+	}
+
+	// 错误信息，调用栈中去掉测试环境自身的位置
+	function errorText(err) {
+		if (err && err.stack) {
+			return String(err.stack).split('\n').filter(function (line) {
+				return line.indexOf('scripttest.js:') < 0;
+			}).join('\n').trim();
+		}
+		if (err && err.message !== undefined) return String(err.message);
+		return String(err);
+	}
+
+	async function runTest(t) {
+		callLog = [];
+		testMocks = {askAI: [], fetch: [], bot: {}, files: {}};
+		clock.reset();
+		native.resetStorage();
+		native.setScript('');
+		try {
+			for (var i = 0; i < beforeHooks.length; i++) await beforeHooks[i]();
+			await withTimeout(Promise.resolve().then(function () { return t.fn(); }), t.timeout);
+			for (var j = 0; j < afterHooks.length; j++) await afterHooks[j]();
+		} finally {
+			testMocks = null;
+			timers = [];
+		}
+	}
+
+	async function runAll() {
+		var results = [];
+		for (var i = 0; i < tests.length; i++) {
+			var t = tests[i];
+			if (t.skip) {
+				results.push({name: t.name, passed: false, skipped: true, duration_ms: 0});
+				continue;
+			}
+			var start = RealDate.now();
+			try {
+				await runTest(t);
+				results.push({name: t.name, passed: true, duration_ms: RealDate.now() - start});
+			} catch (err) {
+				results.push({name: t.name, passed: false, error: errorText(err), duration_ms: RealDate.now() - start});
+			}
+		}
+		return JSON.stringify(results);
+	}
+
+	Object.assign(globalThis, {
+		bot: botProxy,
+		fetch: fakeFetch,
+		Date: FakeDate,
+		setTimeout: function (fn, delay) { return addTimer(fn, delay, Array.prototype.slice.call(arguments, 2), false); },
+		setInterval: function (fn, delay) { return addTimer(fn, delay, Array.prototype.slice.call(arguments, 2), true); },
+		setImmediate: function (fn) { return addTimer(fn, 0, Array.prototype.slice.call(arguments, 1), false); },
+		clearTimeout: removeTimer,
+		clearInterval: removeTimer,
+		clearImmediate: removeTimer,
+		test: test,
+		beforeEach: beforeEach,
+		afterEach: afterEach,
+		assert: assert,
+		AssertionError: AssertionError,
+		mock: mock,
+		calls: calls,
+		said: said,
+		clock: clock,
+		flush: flush,
+		run: run
+	});
+
+	return runAll;
+})
@@ -0,0 +1,407 @@
+package jsengine
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"ninego/log"
+)
+
+/*
+bot.storage 的数据存储
+	数据按命名空间保存(bot.storage 为 global，bot.store() 默认为脚本名)，每个键可设置过期时间。
+	botdata.storage  快照(写入临时文件后fsync、改名，不会写坏)
+	botdata.journal  日志：每次写入立即追加一行(进程崩溃或被kill不丢数据)，每秒fsync一次
+	启动时读取快照并重放日志；日志超过 journalCompactSize 条或程序退出时合并为新的快照并清空日志。
+	快照读取出错时改名为 botdata.storage.corrupt 保留，仍重放并打开日志；无法改名时不再合并，以免覆盖原来的快照。
+*/
+
+const (
+	GlobalNamespace    = "global" //bot.storage 的命名空间
+	journalCompactSize = 1000
+	storageVersion     = 2
+)
+
+// 存储中的值，Expire为过期时间(Unix毫秒)，0为不过期
+type storageEntry struct {
+	Value  interface{} `json:"value"`
+	Expire int64       `json:"expire,omitempty"`
+}
+
+func (e *storageEntry) expired(now int64) bool {
+	return e.Expire > 0 && e.Expire <= now
+}
+
+// 日志中的一次写入
+type journalRecord struct {
+	Op     string      `json:"op"` //set/del/clear
+	NS     string      `json:"ns"`
+	Key    string      `json:"key,omitempty"`
+	Value  interface{} `json:"value,omitempty"`
+	Expire int64       `json:"expire,omitempty"`
+}
+
+// 快照文件的格式(旧版本为只有键值的对象，读取到global中)
+type storageSnapshot struct {
+	Version int                                 `json:"__storage_version"`
+	Data    map[string]map[string]*storageEntry `json:"data"`
+}
+
+// Storage 带命名空间和过期时间的持久化存储
+type Storage struct {
+	mu          sync.RWMutex
+	spaces      map[string]map[string]*storageEntry
+	file        string   //快照文件
+	journalFile string   //日志文件
+	journal     *os.File //未打开时只保存在内存中
+	records     int      //日志条数
+	dirty       bool     //日志有未fsync的写入
+	broken      bool     //快照读取出错且无法改名保留，不合并快照
+	started     bool
+}
+
+func NewStorage(file, journalFile string) *Storage {
+	return &Storage{
+		spaces:      make(map[string]map[string]*storageEntry),
+		file:        file,
+		journalFile: journalFile,
+	}
+}
+
+func nowMillis() int64 {
+	return time.Now().UnixMilli()
+}
+
+// 过期时间，ttl<=0为不过期
+func expireAt(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return time.Now().Add(ttl).UnixMilli()
+}
+
+// Load 读取快照并重放日志，然后合并为新的快照，启动后台的fsync和清理
+// 快照有误时改名为.corrupt后继续(返回读取快照的错误)，之后的写入仍记录到日志
+func (s *Storage) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var loadErr error
+	if data, err := os.ReadFile(s.file); err == nil {
+		spaces, err := parseSnapshot(data)
+		if err != nil {
+			loadErr = fmt.Errorf("读取%s出错: %w", s.file, err)
+			if err := os.Rename(s.file, s.file+".corrupt"); err != nil {
+				log.Error("保留有误的存储快照出错，不再保存快照:", err)
+				s.broken = true
+			} else {
+				log.Printf("存储快照有误，已改名为 %s.corrupt\n", s.file)
+			}
+		} else {
+			s.spaces = spaces
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if err := s.replayJournal(); err != nil {
+		return err
+	}
+	if !s.broken {
+		if err := s.compact(); err != nil {
+			return err
+		}
+	}
+	if !s.started {
+		s.started = true
+		go s.background()
+	}
+	return loadErr
+}
+
+// 解析快照，兼容旧版本的 {key: value}
+func parseSnapshot(data []byte) (map[string]map[string]*storageEntry, error) {
+	var snap storageSnapshot
+	if err := json.Unmarshal(data, &snap); err == nil && snap.Version >= storageVersion {
+		if snap.Data == nil {
+			snap.Data = make(map[string]map[string]*storageEntry)
+		}
+		for ns, entries := range snap.Data {
+			if entries == nil {
+				delete(snap.Data, ns)
+			}
+		}
+		return snap.Data, nil
+	}
+	var flat map[string]interface{}
+	if err := json.Unmarshal(data, &flat); err != nil {
+		return nil, err
+	}
+	global := make(map[string]*storageEntry, len(flat))
+	for k, v := range flat {
+		global[k] = &storageEntry{Value: v}
+	}
+	return map[string]map[string]*storageEntry{GlobalNamespace: global}, nil
+}
+
+// 重放日志，最后一行不完整(写入时崩溃)时丢弃
+func (s *Storage) replayJournal() error {
+	f, err := os.OpenFile(s.journalFile, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			if len(bytes.TrimSpace(line)) > 0 {
+				log.Println("存储日志的最后一行不完整，已丢弃")
+			}
+			break
+		}
+		var rec journalRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			log.Println("存储日志有误，忽略之后的内容:", err)
+			break
+		}
+		s.apply(&rec)
+	}
+	s.journal = f
+	return nil
+}
+
+// 执行一次写入
+func (s *Storage) apply(rec *journalRecord) {
+	switch rec.Op {
+	case "set":
+		space := s.spaces[rec.NS]
+		if space == nil {
+			space = make(map[string]*storageEntry)
+			s.spaces[rec.NS] = space
+		}
+		space[rec.Key] = &storageEntry{Value: rec.Value, Expire: rec.Expire}
+	case "del":
+		if space := s.spaces[rec.NS]; space != nil {
+			delete(space, rec.Key)
+			if len(space) == 0 {
+				delete(s.spaces, rec.NS)
+			}
+		}
+	case "clear":
+		delete(s.spaces, rec.NS)
+	}
+}
+
+// 执行写入并追加到日志(已持有写锁)
+func (s *Storage) write(rec *journalRecord) {
+	s.apply(rec)
+	if s.journal == nil {
+		return
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		log.Error("存储的值无法保存:", rec.NS, rec.Key, err)
+		return
+	}
+	if _, err := s.journal.Write(append(line, '\n')); err != nil {
+		log.Error("写入存储日志出错:", err)
+		return
+	}
+	s.records++
+	s.dirty = true
+}
+
+// 保存快照并清空日志(已持有写锁)
+func (s *Storage) compact() error {
+	if s.broken {
+		return fmt.Errorf("%s读取出错，不覆盖快照", s.file)
+	}
+	data, err := json.Marshal(&storageSnapshot{Version: storageVersion, Data: s.spaces})
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.file, data); err != nil {
+		return err
+	}
+	if s.journal != nil {
+		if err := s.journal.Truncate(0); err != nil {
+			return err
+		}
+		s.journal.Sync()
+	}
+	s.records, s.dirty = 0, false
+	return nil
+}
+
+// 写入临时文件、fsync后改名，保证文件完整
+func writeFileAtomic(file string, data []byte) error {
+	dir := filepath.Dir(file)
+	tmp, err := os.CreateTemp(dir, filepath.Base(file)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return err
+	}
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// 每秒fsync日志，每分钟清理过期的键，日志过长时合并
+func (s *Storage) background() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for i := 1; ; i++ {
+		<-ticker.C
+		s.mu.Lock()
+		if i%60 == 0 {
+			s.sweep()
+		}
+		if s.records >= journalCompactSize && !s.broken {
+			if err := s.compact(); err != nil {
+				log.Error("保存存储快照出错:", err)
+			}
+		} else if s.dirty && s.journal != nil {
+			s.journal.Sync()
+			s.dirty = false
+		}
+		s.mu.Unlock()
+	}
+}
+
+// 删除过期的键(已持有写锁，过期时间已在日志中，不需要记录)
+func (s *Storage) sweep() {
+	now := nowMillis()
+	for ns, space := range s.spaces {
+		for k, e := range space {
+			if e.expired(now) {
+				delete(space, k)
+			}
+		}
+		if len(space) == 0 {
+			delete(s.spaces, ns)
+		}
+	}
+}
+
+// Save 保存快照并清空日志
+func (s *Storage) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.compact()
+}
+
+// Namespace 命名空间的读写接口
+func (s *Storage) Namespace(ns string) *SharedData {
+	return &SharedData{st: s, ns: ns}
+}
+
+// Namespaces 有数据的命名空间(按名称排序)
+func (s *Storage) Namespaces() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := nowMillis()
+	list := make([]string, 0, len(s.spaces))
+	for ns, space := range s.spaces {
+		for _, e := range space {
+			if !e.expired(now) {
+				list = append(list, ns)
+				break
+			}
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// StorageItem 导出和网页显示用的键值
+type StorageItem struct {
+	Namespace string      `json:"ns"`
+	Key       string      `json:"key"`
+	Value     interface{} `json:"value"`
+	Expire    int64       `json:"expire,omitempty"` //过期时间(Unix毫秒)
+}
+
+// Items 命名空间中未过期的键值，ns为空时返回全部(按命名空间和键排序)
+func (s *Storage) Items(ns string) []StorageItem {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := nowMillis()
+	items := []StorageItem{}
+	for name, space := range s.spaces {
+		if ns != "" && name != ns {
+			continue
+		}
+		for k, e := range space {
+			if !e.expired(now) {
+				items = append(items, StorageItem{Namespace: name, Key: k, Value: e.Value, Expire: e.Expire})
+			}
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Namespace != items[j].Namespace {
+			return items[i].Namespace < items[j].Namespace
+		}
+		return items[i].Key < items[j].Key
+	})
+	return items
+}
+
+// Export 导出全部未过期的数据(快照格式)
+func (s *Storage) Export() ([]byte, error) {
+	data := make(map[string]map[string]*storageEntry)
+	for _, item := range s.Items("") {
+		if data[item.Namespace] == nil {
+			data[item.Namespace] = make(map[string]*storageEntry)
+		}
+		data[item.Namespace][item.Key] = &storageEntry{Value: item.Value, Expire: item.Expire}
+	}
+	return json.MarshalIndent(&storageSnapshot{Version: storageVersion, Data: data}, "", "  ")
+}
+
+// Import 导入快照格式(或旧版的{key: value})的数据，replace为true时先清空全部数据；返回导入的键数
+func (s *Storage) Import(data []byte, replace bool) (int, error) {
+	spaces, err := parseSnapshot(data)
+	if err != nil {
+		return 0, errors.New("数据格式有误: " + err.Error())
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if replace {
+		for ns := range s.spaces {
+			s.write(&journalRecord{Op: "clear", NS: ns})
+		}
+	}
+	count, now := 0, nowMillis()
+	for ns, space := range spaces {
+		for k, e := range space {
+			if e == nil || e.expired(now) {
+				continue
+			}
+			s.write(&journalRecord{Op: "set", NS: ns, Key: k, Value: e.Value, Expire: e.Expire})
+			count++
+		}
+	}
+	return count, s.compact()
+}
@@ -6,6 +6,7 @@ import (
 
 	"net/http"
 	"net/url"
+	"path/filepath"
 
 	//"sync"
 
@@ -72,6 +73,18 @@ func (j *JSRequest) GetQuery() url.Values {
 	return j.req.URL.Query()
 }
 
+// JS 中的 req 对象
+func (j *JSRequest) object() map[string]interface{} {
+	return map[string]interface{}{
+		"method":  j.GetMethod,  // JS: req.method() → 返回请求方法
+		"url":     j.GetURL,     // JS: req.url() → 返回完整 URL
+		"headers": j.GetHeaders, // JS: req.headers() → 返回请求头 map
+		"body":    j.GetBody,    // JS: req.body() → 返回请求体字符串
+		"params":  j.GetParams,  // JS: req.params() → 返回路由参数 map
+		"query":   j.GetQuery,   // JS: req.query() → 返回查询参数 map
+	}
+}
+
 // ------------------------------
 // 2. 响应对象封装（对应 JS 的 res）
 // ------------------------------
@@ -186,14 +199,7 @@ func injectJSContext(vm *goja.Runtime, w http.ResponseWriter, r *http.Request) e
 	}
 
 	// 4. 将 Go 对象注入 JS 全局作用域，映射为 req/res
-	if err := vm.Set("req", map[string]interface{}{
-		"method":  jsReq.GetMethod,  // JS: req.method() → 返回请求方法
-		"url":     jsReq.GetURL,     // JS: req.url() → 返回完整 URL
-		"headers": jsReq.GetHeaders, // JS: req.headers() → 返回请求头 map
-		"body":    jsReq.GetBody,    // JS: req.body() → 返回请求体字符串
-		"params":  jsReq.GetParams,  // JS: req.params() → 返回路由参数 map
-		"query":   jsReq.GetQuery,   // JS: req.query() → 返回查询参数 map
-	}); err != nil {
+	if err := vm.Set("req", jsReq.object()); err != nil {
 		return err
 	}
 
@@ -224,9 +230,9 @@ func Do_Action(w http.ResponseWriter, r *http.Request, customJSScript *string) (
 	global.Update("runcount", +1)
 
 	// 1. 创建 JS 虚拟机
-	engine := vmPool.Get().(*Engine)
+	engine := getEngine(ContextTask, filepath.Join(GetExecutableDir(), r.PathValue("action")+".bot"), *customJSScript)
 	// 将 VM 放回池中以供将来重用
-	defer vmPool.Put(engine)
+	defer putEngine(engine)
 	// 2. 注入 req/res 到 JS 环境
 	if err = injectJSContext(engine.Runtime, w, r); err != nil {
 		http.Error(w, "JS 环境初始化失败: "+err.Error(), http.StatusInternalServerError)
@@ -240,6 +246,11 @@ func Do_Action(w http.ResponseWriter, r *http.Request, customJSScript *string) (
 			log.Error("JS 脚本错误: "+evalErr.String(), http.StatusInternalServerError)
 			return
 		}
+		if IsTimeout(err) {
+			http.Error(w, "JS 执行超时: "+err.Error(), http.StatusGatewayTimeout)
+			log.Error("JS 执行超时: "+err.Error(), http.StatusGatewayTimeout)
+			return
+		}
 		http.Error(w, "JS 执行失败: "+err.Error(), http.StatusInternalServerError)
 		log.Error("JS 执行失败: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -0,0 +1,108 @@
+package jsengine
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// 适配器脚本(*.adapter)
+const ContextAdapter = "adapter"
+
+// 各类脚本的最长执行时间(包括等待Promise和定时器)，0=不限制
+var Timeouts = map[string]time.Duration{
+	ContextQuery:     10 * time.Second,
+	ContextTask:      60 * time.Second,
+	ContextSchedule:  5 * time.Minute,
+	ContextCondition: 5 * time.Second,
+	ContextHook:      10 * time.Second,
+	ContextApp:       10 * time.Second,
+	ContextRepl:      30 * time.Second,
+	ContextTest:      60 * time.Second,
+	ContextAdapter:   5 * time.Second,
+}
+
+var timeoutsMu sync.RWMutex
+
+// TimeoutError 脚本执行超时
+type TimeoutError struct {
+	Limit time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("脚本执行超时(超过%s)", e.Limit)
+}
+
+// IsTimeout 判断是否为脚本执行超时错误
+func IsTimeout(err error) bool {
+	var te *TimeoutError
+	return errors.As(err, &te)
+}
+
+// SetTimeouts 按配置修改执行时间限制(秒)，未配置的保持默认值
+func SetTimeouts(seconds map[string]int) {
+	timeoutsMu.Lock()
+	defer timeoutsMu.Unlock()
+	for context, sec := range seconds {
+		if sec < 0 {
+			sec = 0
+		}
+		Timeouts[context] = time.Duration(sec) * time.Second
+	}
+}
+
+func timeoutOf(context string) time.Duration {
+	timeoutsMu.RLock()
+	defer timeoutsMu.RUnlock()
+	return Timeouts[context]
+}
+
+// watchdog 超时后中断VM，stop返回是否已超时
+type watchdog struct {
+	timer    *time.Timer
+	fired    chan struct{}
+	timedOut atomic.Bool
+}
+
+// startWatchdog 超过limit时调用Runtime.Interrupt中断脚本，并执行onTimeout(如停止事件循环)
+func startWatchdog(rt *goja.Runtime, limit time.Duration, onTimeout func()) *watchdog {
+	w := &watchdog{fired: make(chan struct{})}
+	if limit <= 0 {
+		return w
+	}
+	w.timer = time.AfterFunc(limit, func() {
+		defer close(w.fired)
+		w.timedOut.Store(true)
+		rt.Interrupt(&TimeoutError{Limit: limit})
+		if onTimeout != nil {
+			onTimeout()
+		}
+	})
+	return w
+}
+
+// stop 停止计时，已触发时等待中断完成
+func (w *watchdog) stop() bool {
+	if w.timer != nil && !w.timer.Stop() {
+		<-w.fired
+	}
+	return w.timedOut.Load()
+}
+
+// runWithTimeout 在限定时间内执行不使用事件循环的VM(适配器)
+func runWithTimeout(rt *goja.Runtime, limit time.Duration, fn func() (goja.Value, error)) (goja.Value, error) {
+	w := startWatchdog(rt, limit, nil)
+	result, err := fn()
+	if w.stop() {
+		rt.ClearInterrupt()
+		var ie *goja.InterruptedError
+		if errors.As(err, &ie) {
+			return nil, &TimeoutError{Limit: limit}
+		}
+	}
+	return result, err
+}
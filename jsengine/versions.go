@@ -0,0 +1,439 @@
+package jsengine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ninego/log"
+)
+
+/*
+脚本的历史版本
+	网页保存 query.bot、任务脚本(.bot)、定时任务脚本(.job) 时保存一个版本：versions/脚本名/版本号.json
+	版本号为保存时间加内容哈希的前8位(如 20250501-073000.123-1a2b3c4d)，按名称排序即按时间排序。
+	内容与最新版本相同时不重复保存；脚本第一次保存时，先把保存前的文件内容作为一个版本(可回退到修改前)。
+	超过保留个数或天数的旧版本在保存时删除，最新的版本总是保留。
+*/
+
+// VersionDir 历史版本目录(相对程序目录)
+var VersionDir = "versions"
+
+// ScriptVersion 脚本的一个版本
+type ScriptVersion struct {
+	ID      string    `json:"id"`
+	Script  string    `json:"script"`
+	Time    time.Time `json:"time"`
+	Author  string    `json:"author"` //保存者(网页客户端地址或指定的作者)
+	Hash    string    `json:"hash"`   //内容的sha256
+	Size    int       `json:"size"`
+	Content string    `json:"content,omitempty"`
+}
+
+type scriptVersions struct {
+	mu   sync.Mutex
+	keep int //每个脚本最多保留的版本数，0=不限制
+	days int //保留天数，0=不限制
+}
+
+var Versions = &scriptVersions{keep: 50}
+
+// SetVersionRetention 按配置修改保留策略：{"keep": 个数, "days": 天数}，未配置的保持默认值
+func SetVersionRetention(retention map[string]int) {
+	Versions.mu.Lock()
+	defer Versions.mu.Unlock()
+	if keep, ok := retention["keep"]; ok && keep >= 0 {
+		Versions.keep = keep
+	}
+	if days, ok := retention["days"]; ok && days >= 0 {
+		Versions.days = days
+	}
+}
+
+// 可保存版本的脚本：程序目录下的 .bot/.job 文件名
+func checkScriptName(script string) error {
+	if script == "" || script != filepath.Base(script) || strings.HasPrefix(script, ".") {
+		return errors.New("脚本名有误: " + script)
+	}
+	switch filepath.Ext(script) {
+	case ".bot", ".job":
+		return nil
+	}
+	return errors.New("不支持的脚本类型: " + script)
+}
+
+func versionDirPath(script string) string {
+	dir := VersionDir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(GetExecutableDir(), dir)
+	}
+	return filepath.Join(dir, script)
+}
+
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// Save 保存脚本的新版本；previous为保存前的文件内容(没有该脚本的版本时先保存它)，内容与最新版本相同时返回最新版本
+func (v *scriptVersions) Save(script, content, author, previous string) (*ScriptVersion, error) {
+	if err := checkScriptName(script); err != nil {
+		return nil, err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	ids, err := v.ids(script)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 && previous != "" && previous != content {
+		if _, err := v.write(script, previous, "保存前的文件", time.Now().Add(-time.Millisecond)); err != nil {
+			return nil, err
+		}
+	} else if len(ids) > 0 {
+		if latest, err := v.read(script, ids[len(ids)-1]); err == nil && latest.Hash == contentHash(content) {
+			latest.Content = ""
+			return latest, nil
+		}
+	}
+	ver, err := v.write(script, content, author, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	v.prune(script)
+	return ver, nil
+}
+
+func (v *scriptVersions) write(script, content, author string, t time.Time) (*ScriptVersion, error) {
+	hash := contentHash(content)
+	ver := &ScriptVersion{
+		ID:      t.Format("20060102-150405.000") + "-" + hash[:8],
+		Script:  script,
+		Time:    t,
+		Author:  author,
+		Hash:    hash,
+		Size:    len(content),
+		Content: content,
+	}
+	dir := versionDirPath(script)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(ver, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, ver.ID+".json"), data); err != nil {
+		return nil, err
+	}
+	log.Printf("保存脚本版本 %s %s (%s)\n", script, ver.ID, author)
+	ver.Content = ""
+	return ver, nil
+}
+
+// 脚本的全部版本号(从旧到新)
+func (v *scriptVersions) ids(script string) ([]string, error) {
+	entries, err := os.ReadDir(versionDirPath(script))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	ids := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			ids = append(ids, strings.TrimSuffix(e.Name(), ".json"))
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (v *scriptVersions) read(script, id string) (*ScriptVersion, error) {
+	if id == "" || id != filepath.Base(id) {
+		return nil, errors.New("版本号有误: " + id)
+	}
+	data, err := os.ReadFile(filepath.Join(versionDirPath(script), id+".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s 没有版本 %s", script, id)
+		}
+		return nil, err
+	}
+	var ver ScriptVersion
+	if err := json.Unmarshal(data, &ver); err != nil {
+		return nil, fmt.Errorf("版本 %s 已损坏: %w", id, err)
+	}
+	return &ver, nil
+}
+
+// 按保留策略删除旧版本(已持有锁)，最新的版本总是保留
+func (v *scriptVersions) prune(script string) {
+	ids, err := v.ids(script)
+	if err != nil || len(ids) <= 1 {
+		return
+	}
+	remove := 0
+	if v.keep > 0 && len(ids) > v.keep {
+		remove = len(ids) - v.keep
+	}
+	if v.days > 0 {
+		cutoff := time.Now().AddDate(0, 0, -v.days).Format("20060102-150405.000")
+		for remove < len(ids)-1 && ids[remove] < cutoff {
+			remove++
+		}
+	}
+	for _, id := range ids[:remove] {
+		if err := os.Remove(filepath.Join(versionDirPath(script), id+".json")); err != nil {
+			log.Error("删除脚本旧版本出错:", err)
+		}
+	}
+	if remove > 0 {
+		log.Printf("删除脚本 %s 的旧版本%d个\n", script, remove)
+	}
+}
+
+// List 脚本的版本(从新到旧，不含内容)
+func (v *scriptVersions) List(script string) ([]*ScriptVersion, error) {
+	if err := checkScriptName(script); err != nil {
+		return nil, err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	ids, err := v.ids(script)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*ScriptVersion, 0, len(ids))
+	for i := len(ids) - 1; i >= 0; i-- {
+		ver, err := v.read(script, ids[i])
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		ver.Content = ""
+		list = append(list, ver)
+	}
+	return list, nil
+}
+
+// Scripts 有历史版本的脚本名
+func (v *scriptVersions) Scripts() []string {
+	dir := versionDirPath("")
+	entries, _ := os.ReadDir(dir)
+	list := []string{}
+	for _, e := range entries {
+		if e.IsDir() && checkScriptName(e.Name()) == nil {
+			list = append(list, e.Name())
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Get 读取版本(含内容)
+func (v *scriptVersions) Get(script, id string) (*ScriptVersion, error) {
+	if err := checkScriptName(script); err != nil {
+		return nil, err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.read(script, id)
+}
+
+// Diff 两个版本的差异(统一diff格式)，id为空或"current"时为当前的文件内容current
+func (v *scriptVersions) Diff(script, from, to, current string) (string, error) {
+	content := func(id string) (string, string, error) {
+		if id == "" || id == "current" {
+			return current, script + " (当前)", nil
+		}
+		ver, err := v.Get(script, id)
+		if err != nil {
+			return "", "", err
+		}
+		return ver.Content, script + "@" + ver.ID, nil
+	}
+	a, nameA, err := content(from)
+	if err != nil {
+		return "", err
+	}
+	b, nameB, err := content(to)
+	if err != nil {
+		return "", err
+	}
+	return UnifiedDiff(nameA, nameB, a, b, 3), nil
+}
+
+// ------------------------------
+// 文本差异
+// ------------------------------
+
+// 逐行比较的一个操作：' '相同、'-'删除、'+'增加
+type diffLine struct {
+	op   byte
+	text string
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.Split(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// 最短编辑路径(Myers算法)
+func diffLines(a, b []string) []diffLine {
+	n, m := len(a), len(b)
+	total := n + m
+	if total == 0 {
+		return nil
+	}
+	offset := total + 1
+	v := make([]int, 2*total+3)
+	var trace [][]int
+	for d := 0; d <= total; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, a, b, offset, d)
+			}
+		}
+	}
+	return nil
+}
+
+func backtrack(trace [][]int, a, b []string, offset, dEnd int) []diffLine {
+	var result []diffLine
+	x, y := len(a), len(b)
+	for d := dEnd; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			result = append(result, diffLine{' ', a[x]})
+		}
+		if x == prevX {
+			y--
+			result = append(result, diffLine{'+', b[y]})
+		} else {
+			x--
+			result = append(result, diffLine{'-', a[x]})
+		}
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		result = append(result, diffLine{' ', a[x]})
+	}
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return result
+}
+
+// UnifiedDiff 统一diff格式的差异，context为每处修改前后显示的相同行数；内容相同时返回空字符串
+func UnifiedDiff(nameA, nameB, a, b string, context int) string {
+	lines := diffLines(splitLines(a), splitLines(b))
+	changed := false
+	for _, l := range lines {
+		if l.op != ' ' {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return ""
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", nameA, nameB)
+	for i := 0; i < len(lines); {
+		if lines[i].op == ' ' {
+			i++
+			continue
+		}
+		// 一段修改：向前后扩展context行，相距不超过2*context的修改合并
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for j := i; j < len(lines); j++ {
+			if lines[j].op != ' ' {
+				end = j
+			} else if j-end > 2*context {
+				break
+			}
+		}
+		end += context + 1
+		if end > len(lines) {
+			end = len(lines)
+		}
+		// 起始行号
+		lineA, lineB := 1, 1
+		for _, l := range lines[:start] {
+			if l.op != '+' {
+				lineA++
+			}
+			if l.op != '-' {
+				lineB++
+			}
+		}
+		countA, countB := 0, 0
+		for _, l := range lines[start:end] {
+			if l.op != '+' {
+				countA++
+			}
+			if l.op != '-' {
+				countB++
+			}
+		}
+		if countA == 0 {
+			lineA--
+		}
+		if countB == 0 {
+			lineB--
+		}
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", lineA, countA, lineB, countB)
+		for _, l := range lines[start:end] {
+			sb.WriteByte(l.op)
+			sb.WriteString(l.text)
+			sb.WriteByte('\n')
+		}
+		i = end
+	}
+	return sb.String()
+}
@@ -10,6 +10,7 @@ import (
 
 	"xiaobot/jsengine/console"
 	"xiaobot/jsengine/inject/file"
+	"xiaobot/jsengine/inject/stream"
 	"xiaobot/jsengine/inject/tools"
 	"xiaobot/jsengine/inject/vm"
 	"xiaobot/jsengine/inject/xhr"
@@ -20,7 +21,6 @@ import (
 
 	"github.com/dop251/goja"
 	"github.com/dop251/goja_nodejs/eventloop"
-	"github.com/dop251/goja_nodejs/require"
 	gojaurl "github.com/dop251/goja_nodejs/url"
 )
 
@@ -31,14 +31,17 @@ var vmPool = sync.Pool{
 	New: func() interface{} {
 		eng := NewEngine(nil)
 
-		registry := new(require.Registry)
-		registry.Enable(eng.Runtime)
+		eng.resetModules = enableRequire(eng.Runtime)
 
 		console.Enable(eng.Runtime)
 
 		// ... 设置其他全局值 ...
-		BotfuncMap["storage"] = WrapSharedData(eng.Runtime, global) //&global
-		RegisterBotMap(eng.Runtime, BotfuncMap)                     //eng.Runtime.Set("bot", &Jsbot)
+		botMap := eng.sandbox.guardBotMap(BotfuncMap)
+		bindStorage(eng.Runtime, botMap)    //bot.storage、bot.store()
+		RegisterBotMap(eng.Runtime, botMap) //eng.Runtime.Set("bot", &Jsbot)
+
+		// 记录初始状态，每次运行后恢复
+		eng.reset = snapshotBaseline(eng)
 
 		return eng
 	},
@@ -50,6 +53,13 @@ type Engine struct {
 	loop *eventloop.EventLoop
 
 	Runtime *goja.Runtime
+	sandbox *sandbox      //本次运行的脚本权限
+	timeout time.Duration //本次运行的时间限制，0=不限制
+	expired bool          //执行超时或无法恢复初始状态，不能再放回池中
+
+	reset        goja.Callable //恢复初始状态(见baseline.go)
+	resetModules func()        //清除require缓存的模块实例
+	closeConns   func()        //关闭未结束的流式请求和WebSocket连接
 }
 
 // RunString executes the script and returns the go type value
@@ -61,12 +71,8 @@ func (e *Engine) RunString(script string) (value any, err error) {
 		}
 	}()
 
-	var result goja.Value
-	e.loop.Run(func(runtime *goja.Runtime) {
-		result, err = runtime.RunString(script)
-		if err == nil {
-			go e.await(result)
-		}
+	result, err := e.runLoop(func(runtime *goja.Runtime) (goja.Value, error) {
+		return runtime.RunString(script)
 	})
 	if err != nil {
 		return
@@ -83,20 +89,15 @@ func (e *Engine) CallFunction(fn goja.Callable, args ...any) (value any, err err
 		}
 	}()
 
-	var result goja.Value
-	e.loop.Run(func(runtime *goja.Runtime) {
+	result, err := e.runLoop(func(runtime *goja.Runtime) (goja.Value, error) {
 		if args == nil {
-			result, err = fn(nil)
-		} else {
-			var jsArgs []goja.Value
-			for _, arg := range args {
-				jsArgs = append(jsArgs, runtime.ToValue(arg))
-			}
-			result, err = fn(nil, jsArgs...)
+			return fn(nil)
 		}
-		if err == nil {
-			go e.await(result)
+		var jsArgs []goja.Value
+		for _, arg := range args {
+			jsArgs = append(jsArgs, runtime.ToValue(arg))
 		}
+		return fn(nil, jsArgs...)
 	})
 	if err != nil {
 		return
@@ -104,9 +105,31 @@ func (e *Engine) CallFunction(fn goja.Callable, args ...any) (value any, err err
 	return resolveResult(result)
 }
 
+// runLoop 在事件循环中执行fn并等待Promise/定时器完成。
+// 超过e.timeout时中断脚本、取消未执行的定时器，返回*TimeoutError
+func (e *Engine) runLoop(fn func(runtime *goja.Runtime) (goja.Value, error)) (result goja.Value, err error) {
+	done := make(chan struct{})
+	w := startWatchdog(e.Runtime, e.timeout, e.loop.StopNoWait)
+	e.loop.Run(func(runtime *goja.Runtime) {
+		result, err = fn(runtime)
+		if err == nil {
+			go e.await(result, done)
+		}
+	})
+	close(done)
+	if w.stop() {
+		e.loop.Terminate() //清除未执行的定时器
+		e.Runtime.ClearInterrupt()
+		e.expired = true
+		return nil, &TimeoutError{Limit: e.timeout}
+	}
+	return
+}
+
 // loop.Run will hang if the script result has a non-stop code, such as setInterval.
 // This method will stop the event loop when the promise result is resolved.
-func (e *Engine) await(value any) {
+// done is closed when loop.Run returns, so a never-resolving promise won't leak the goroutine.
+func (e *Engine) await(value any, done <-chan struct{}) {
 	if value == nil {
 		return
 	}
@@ -120,7 +143,11 @@ func (e *Engine) await(value any) {
 
 			// check promise state every 100 milliseconds, until it is resolved
 			for {
-				time.Sleep(time.Millisecond * 100)
+				select {
+				case <-done:
+					return
+				case <-time.After(time.Millisecond * 100):
+				}
 				if p.State() == goja.PromiseStatePending {
 					continue
 				}
@@ -137,20 +164,31 @@ func (e *Engine) Close() {
 	e.loop.StopNoWait()
 }
 
+// closeStreams 关闭脚本未关闭的流式请求和WebSocket连接(事件循环停止后调用)
+func (e *Engine) closeStreams() {
+	if e.closeConns != nil {
+		e.closeConns()
+	}
+}
+
 func NewEngine(proxy ProxyHandler) *Engine {
 	loop := eventloop.NewEventLoop()
 	engine := &Engine{
-		loop: loop,
+		loop:    loop,
+		sandbox: &sandbox{},
 	}
 	loop.Run(func(runtime *goja.Runtime) {
 		engine.Runtime = runtime
+		sandboxes.Store(runtime, engine.sandbox)
 		runtime.SetFieldNameMapper(goja.TagFieldNameMapper("json", true))
 		vm.Enable(runtime)
 		gojaurl.Enable(runtime)
 		//if err := gojaerror.Enable(runtime); err != nil {
 		//	return
 		//}
-		if err := tools.Enable(runtime); err != nil {
+		if err := tools.Enable(runtime, func(cmdstr string) bool {
+			return engine.sandbox.allow(capShell, cmdstr)
+		}); err != nil {
 			return
 		}
 		if err := file.Enable(runtime); err != nil {
@@ -159,12 +197,21 @@ func NewEngine(proxy ProxyHandler) *Engine {
 		//if err := data.Enable(runtime); err != nil {
 		//	return
 		//}
-		if err := xhr.Enable(runtime, proxy); err != nil {
+		if err := xhr.Enable(runtime, proxy, func(host string) bool {
+			return engine.sandbox.allow(capNetwork, host)
+		}); err != nil {
 			return
 		}
 		if _, err := runtime.RunString(polyfillScript); err != nil {
 			return
 		}
+		closeConns, err := stream.Enable(runtime, loop, proxy, func(host string) bool {
+			return engine.sandbox.allow(capNetwork, host)
+		})
+		if err != nil {
+			return
+		}
+		engine.closeConns = closeConns
 		// polyfill global
 		if err := runtime.Set("global", runtime.GlobalObject()); err != nil {
 			return
@@ -184,6 +231,8 @@ func NewEngine(proxy ProxyHandler) *Engine {
 
 func Run(script string) (value any, err error) {
 	engine := NewEngine(nil)
+	engine.sandbox.grant("", AllPermissions)
+	defer engine.closeStreams()
 	return engine.RunString(script)
 }
 
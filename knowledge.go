@@ -0,0 +1,64 @@
+package xiaobot
+
+import (
+	"fmt"
+	"strings"
+
+	"ninego/log"
+	"xiaobot/jarvis"
+	"xiaobot/knowledge"
+)
+
+/*
+本地知识库
+	配置 knowledge_path 为资料目录(家电说明书、作息表、学校通知、菜谱等 .txt、.md、.html 文件)，
+	程序建立本地全文索引(BM25，不需要联网)，文件修改后自动重新索引。
+	问AI时检索和提问最相关的段落(knowledge_top 条，默认3，-1为不附加)附加到系统提示词，并注明出处，AI依据资料回答时说明出处。
+	网页 /knowledge/search?query=xx 查看检索的结果，/knowledge/reindex(POST) 立即检查文件变化并重新索引。
+*/
+
+const (
+	defaultKnowledgeTop = 3
+	knowledgeMinMatch   = 0.25 //提问中的词在段落中出现的比例(按IDF加权，资料中没有的词也计入)低于此值，或得分不到最相关段落的一半时不附加
+)
+
+var knowledgeTop int //配置 knowledge_top
+
+func init() {
+	jarvis.AddPromptContext(knowledgePrompt)
+}
+
+// SearchKnowledge 知识库中和提问相关的段落(已去掉相关度太低的)
+func SearchKnowledge(query string) []knowledge.Hit {
+	n := knowledgeTop
+	if n == 0 {
+		n = defaultKnowledgeTop
+	}
+	if n < 0 {
+		return nil
+	}
+	hits := []knowledge.Hit{}
+	for _, hit := range knowledge.Default.Search(query, n) {
+		if hit.Match >= knowledgeMinMatch && (len(hits) == 0 || hit.Score >= hits[0].Score/2) {
+			hits = append(hits, hit)
+		}
+	}
+	return hits
+}
+
+// 附加到系统提示词的资料段落
+func knowledgePrompt(query string) string {
+	hits := SearchKnowledge(query)
+	if len(hits) == 0 {
+		return ""
+	}
+	lines := []string{"以下是家里资料库中和提问相关的内容，回答时优先依据这些内容，并简短说明出处(如“根据洗衣机说明书”)，资料中没有的不要编造："}
+	sources := make([]string, 0, len(hits))
+	for i, hit := range hits {
+		source := hit.Source()
+		lines = append(lines, fmt.Sprintf("[%d] 出处：%s\n%s", i+1, source, hit.Text))
+		sources = append(sources, source)
+	}
+	log.Println("知识库:", strings.Join(sources, "、"))
+	return strings.Join(lines, "\n")
+}
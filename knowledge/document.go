@@ -0,0 +1,152 @@
+package knowledge
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// 索引的文件类型
+var Extensions = map[string]bool{".txt": true, ".md": true, ".markdown": true, ".html": true, ".htm": true}
+
+const (
+	maxFileSize    = 4 << 20 //超过的文件不索引
+	maxPassageSize = 300     //段落的最多字数(超过时按句子切分)
+)
+
+// Passage 资料中的一段
+type Passage struct {
+	File  string `json:"file"`  //资料目录中的相对路径
+	Title string `json:"title"` //所在的标题(没有时为空)
+	Line  int    `json:"line"`  //开始的行号(HTML为0)
+	Text  string `json:"text"`
+}
+
+// Source 出处：文件名(不含扩展名)和标题
+func (p *Passage) Source() string {
+	name := strings.TrimSuffix(filepath.Base(p.File), filepath.Ext(p.File))
+	if p.Title != "" && p.Title != name {
+		return name + "「" + p.Title + "」"
+	}
+	return name
+}
+
+// 读取文件并切分成段落
+func loadPassages(path, rel string) ([]Passage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	text := string(data)
+	isHTML := false
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".html", ".htm":
+		text, isHTML = htmlText(text), true
+	}
+	passages := splitPassages(text, rel)
+	if isHTML {
+		for i := range passages {
+			passages[i].Line = 0
+		}
+	}
+	return passages, nil
+}
+
+// 切分段落：空行分隔段落，# 开头的行为标题，相邻的短段落合并
+func splitPassages(text, file string) []Passage {
+	passages := []Passage{}
+	title := ""
+	var buf []string
+	start := 0
+	size := 0
+	flush := func() {
+		if len(buf) == 0 {
+			return
+		}
+		passages = append(passages, Passage{File: file, Title: title, Line: start, Text: strings.Join(buf, "\n")})
+		buf, size = nil, 0
+	}
+	add := func(line string, lineNo int) {
+		n := runeCount(line)
+		if size > 0 && size+n > maxPassageSize {
+			flush()
+		}
+		if len(buf) == 0 {
+			start = lineNo
+		}
+		if n <= maxPassageSize {
+			buf = append(buf, line)
+			size += n
+			return
+		}
+		// 很长的一行按句子切分
+		for _, s := range splitSentences(line) {
+			if m := runeCount(s); size > 0 && size+m > maxPassageSize {
+				flush()
+				start = lineNo
+			}
+			buf = append(buf, s)
+			size += runeCount(s)
+		}
+	}
+
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			if size >= maxPassageSize/2 {
+				flush()
+			}
+		case strings.HasPrefix(line, "#"):
+			flush()
+			title = strings.TrimSpace(strings.TrimLeft(line, "#"))
+		default:
+			add(line, i+1)
+		}
+	}
+	flush()
+	return passages
+}
+
+// HTML中的文字：标题转为 # 开头的行，块元素分行，忽略脚本和样式
+func htmlText(source string) string {
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(source))
+	skip := 0
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return b.String()
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+			switch tag {
+			case "script", "style", "noscript", "template":
+				if tt == html.StartTagToken {
+					skip++
+				} else if tt == html.EndTagToken && skip > 0 {
+					skip--
+				}
+			case "h1", "h2", "h3", "h4", "h5", "h6", "title":
+				b.WriteString("\n")
+				if tt == html.StartTagToken {
+					b.WriteString("# ")
+				}
+			case "p", "div", "li", "tr", "br", "section", "article", "table", "ul", "ol", "dt", "dd", "blockquote", "pre":
+				b.WriteString("\n")
+			case "td", "th":
+				b.WriteString(" ")
+			}
+		case html.TextToken:
+			if skip == 0 {
+				b.WriteString(strings.Join(strings.Fields(string(z.Text())), " "))
+			}
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package knowledge
+
+import (
+	"io/fs"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"ninego/log"
+)
+
+/*
+本地知识库
+	把资料目录中的 .txt、.md、.html 文件切分成段落，建立内存中的全文索引，用BM25检索和提问相关的段落。
+	汉字不用词典分词：取每个字和相邻的两字作为词，完全离线。
+	目录中的文件增删改后(每 WatchInterval 检查一次)自动重新索引。
+*/
+
+// BM25 参数
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// WatchInterval 检查资料目录变化的间隔
+var WatchInterval = 30 * time.Second
+
+type posting struct {
+	doc int
+	tf  int
+}
+
+type fileState struct {
+	modTime  time.Time
+	size     int64
+	passages []Passage
+}
+
+// Index 资料目录的全文索引
+type Index struct {
+	mu       sync.RWMutex
+	dir      string
+	files    map[string]*fileState //相对路径 -> 文件
+	docs     []Passage
+	lengths  []int
+	avgLen   float64
+	postings map[string][]posting
+	updated  time.Time
+	lastErr  error
+
+	refreshMu sync.Mutex //同一时间只有一个重新索引
+	watchOnce sync.Once
+}
+
+// Hit 检索到的段落
+type Hit struct {
+	Passage
+	Score float64 `json:"score"`
+	Match float64 `json:"match"` //提问中的词出现在段落中的比例(按IDF加权，0~1)
+}
+
+// Stats 索引的状态
+type Stats struct {
+	Dir      string    `json:"dir"`
+	Files    int       `json:"files"`
+	Passages int       `json:"passages"`
+	Terms    int       `json:"terms"`
+	Updated  time.Time `json:"updated"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Default 配置 knowledge_path 的资料目录的索引
+var Default = &Index{}
+
+// SetDir 设置资料目录(为空时清空索引)，目录变化时在后台重新索引并开始检查文件变化
+func (ix *Index) SetDir(dir string) {
+	ix.mu.Lock()
+	changed := ix.dir != dir
+	if changed {
+		ix.dir = dir
+		ix.files = nil
+		ix.rebuild()
+	}
+	ix.mu.Unlock()
+	if dir == "" {
+		return
+	}
+	if changed {
+		go ix.Refresh()
+	}
+	ix.watchOnce.Do(func() {
+		go func() {
+			for {
+				time.Sleep(WatchInterval)
+				ix.Refresh()
+			}
+		}()
+	})
+}
+
+// Refresh 检查资料目录，有文件变化时重新索引，返回是否有变化
+func (ix *Index) Refresh() (bool, error) {
+	ix.refreshMu.Lock()
+	defer ix.refreshMu.Unlock()
+	ix.mu.RLock()
+	dir, old := ix.dir, ix.files
+	ix.mu.RUnlock()
+	if dir == "" {
+		return false, nil
+	}
+
+	files := map[string]*fileState{}
+	changed := false
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && path != dir {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !Extensions[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.Size() > maxFileSize {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, path)
+		rel = filepath.ToSlash(rel)
+		if f := old[rel]; f != nil && f.modTime.Equal(info.ModTime()) && f.size == info.Size() {
+			files[rel] = f
+			return nil
+		}
+		passages, err := loadPassages(path, rel)
+		if err != nil {
+			log.Error("知识库读取文件出错:", rel, err)
+			return nil
+		}
+		files[rel] = &fileState{modTime: info.ModTime(), size: info.Size(), passages: passages}
+		changed = true
+		return nil
+	})
+	if len(files) != len(old) {
+		changed = true
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.lastErr = err
+	if err != nil {
+		log.Error("知识库索引出错:", err)
+		return false, err
+	}
+	if ix.dir != dir || !changed {
+		return false, nil
+	}
+	ix.files = files
+	ix.rebuild()
+	log.Printf("知识库已索引 %s: %d个文件，%d段\n", dir, len(files), len(ix.docs))
+	return true, nil
+}
+
+// 按文件重新建立倒排索引(已持有锁)
+func (ix *Index) rebuild() {
+	names := make([]string, 0, len(ix.files))
+	for name := range ix.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ix.docs = nil
+	ix.lengths = nil
+	ix.postings = map[string][]posting{}
+	total := 0
+	for _, name := range names {
+		for _, p := range ix.files[name].passages {
+			doc := len(ix.docs)
+			tokens := Tokenize(p.Source() + "\n" + p.Text) //文件名和标题也参与检索
+			tf := map[string]int{}
+			for _, t := range tokens {
+				tf[t]++
+			}
+			for t, n := range tf {
+				ix.postings[t] = append(ix.postings[t], posting{doc: doc, tf: n})
+			}
+			ix.docs = append(ix.docs, p)
+			ix.lengths = append(ix.lengths, len(tokens))
+			total += len(tokens)
+		}
+	}
+	ix.avgLen = 0
+	if len(ix.docs) > 0 {
+		ix.avgLen = float64(total) / float64(len(ix.docs))
+	}
+	ix.updated = time.Now()
+}
+
+func (ix *Index) idf(df int) float64 {
+	n := float64(len(ix.docs))
+	return math.Log(1 + (n-float64(df)+0.5)/(float64(df)+0.5))
+}
+
+// 单个汉字的词权重较低(主要靠相邻两字的词)
+func termWeight(t string) float64 {
+	if utf8.RuneCountInString(t) == 1 && t[0] >= utf8.RuneSelf {
+		return 0.3
+	}
+	return 1
+}
+
+// Search 和提问最相关的n个段落(得分从高到低)
+func (ix *Index) Search(query string, n int) []Hit {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	if len(ix.docs) == 0 || n <= 0 {
+		return nil
+	}
+	terms := map[string]bool{}
+	for _, t := range Tokenize(query) {
+		terms[t] = true
+	}
+	scores := map[int]float64{}
+	matched := map[int]float64{}
+	specific := map[int]bool{} //有两字以上的词相同(只有单字相同的不算匹配，如“现在几点了”和“下午三点”)
+	totalWeight := 0.0
+	for t := range terms {
+		list := ix.postings[t]
+		idf := ix.idf(len(list)) * termWeight(t)
+		totalWeight += idf //资料中没有的词也计入(权重最高)，无关的提问匹配比例低
+		for _, p := range list {
+			tf := float64(p.tf)
+			norm := 1 - bm25B + bm25B*float64(ix.lengths[p.doc])/ix.avgLen
+			scores[p.doc] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+			matched[p.doc] += idf
+			if termWeight(t) == 1 {
+				specific[p.doc] = true
+			}
+		}
+	}
+	hits := make([]Hit, 0, len(scores))
+	for doc, score := range scores {
+		hit := Hit{Passage: ix.docs[doc], Score: score}
+		if specific[doc] {
+			hit.Match = matched[doc] / totalWeight
+		}
+		hits = append(hits, hit)
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].File+hits[i].Text < hits[j].File+hits[j].Text
+	})
+	if len(hits) > n {
+		hits = hits[:n]
+	}
+	return hits
+}
+
+// Stats 索引的状态
+func (ix *Index) Stats() Stats {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	st := Stats{Dir: ix.dir, Files: len(ix.files), Passages: len(ix.docs), Terms: len(ix.postings), Updated: ix.updated}
+	if ix.lastErr != nil {
+		st.Error = ix.lastErr.Error()
+	}
+	return st
+}
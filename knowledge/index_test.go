@@ -0,0 +1,59 @@
+package knowledge
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 和 SearchKnowledge 的 knowledgeMinMatch 相同：提问和资料无关时匹配比例低于此值，不附加到提示词
+const testMinMatch = 0.25
+
+func testIndex(t *testing.T) *Index {
+	dir := t.TempDir()
+	files := map[string]string{
+		"洗衣机说明书.md": "# 洗衣机说明书\n\n" +
+			"## 清洗滚筒\n\n每月清洗一次滚筒，选择“筒自洁”程序，加入专用清洁剂，运行约一小时。\n\n" +
+			"## 故障代码\n\nE1 表示进水超时，请检查水龙头是否打开、进水管是否弯折。\n\nE2 表示排水超时，请清理排水过滤器。\n",
+		"学校通知.txt": "各位家长：本周星期五下午三点召开家长会，地点在三年级二班教室。\n\n" +
+			"下周一起冬季作息时间调整为早上八点到校，下午四点放学，请按时接送孩子。\n",
+	}
+	for name, text := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ix := &Index{dir: dir}
+	if _, err := ix.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	return ix
+}
+
+func TestSearchUnrelated(t *testing.T) {
+	ix := testIndex(t)
+	for _, query := range []string{"现在几点了", "今天下雨吗", "明天星期几", "讲个笑话"} {
+		for _, hit := range ix.Search(query, 3) {
+			if hit.Match >= testMinMatch {
+				t.Errorf("%s: 不相关的段落 %s 匹配比例 %.2f", query, hit.Source(), hit.Match)
+			}
+		}
+	}
+}
+
+func TestSearchRelated(t *testing.T) {
+	ix := testIndex(t)
+	cases := map[string]string{
+		"洗衣机显示E1怎么办": "洗衣机说明书.md",
+		"怎么清洗滚筒":     "洗衣机说明书.md",
+		"家长会在哪里开":    "学校通知.txt",
+		"冬季几点放学":     "学校通知.txt",
+		"E2表示什么":     "洗衣机说明书.md",
+	}
+	for query, file := range cases {
+		hits := ix.Search(query, 3)
+		if len(hits) == 0 || hits[0].File != file || hits[0].Match < testMinMatch {
+			t.Errorf("%s: 应检索到 %s，结果 %+v", query, file, hits)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package knowledge
+
+import (
+	"strings"
+	"unicode"
+)
+
+// 不作为词的常用字(含这些字的两字也不作为词，如“怎么”“在哪”)
+var stopChars = map[rune]bool{
+	'的': true, '了': true, '是': true, '在': true, '吗': true, '呢': true, '啊': true, '吧': true,
+	'呀': true, '么': true, '和': true, '与': true, '及': true, '或': true, '就': true, '也': true,
+	'都': true, '而': true, '被': true, '把': true, '这': true, '那': true, '个': true, '请': true,
+}
+
+// Tokenize 分词(不需要词典)：连续的汉字取每个字和相邻的两字(不含常用字)，字母和数字按词(小写)
+func Tokenize(text string) []string {
+	tokens := []string{}
+	han := []rune{}
+	word := []rune{}
+	flushHan := func() {
+		for i, r := range han {
+			if !stopChars[r] {
+				tokens = append(tokens, string(r))
+			}
+			if i+1 < len(han) && !stopChars[r] && !stopChars[han[i+1]] {
+				tokens = append(tokens, string(han[i:i+2]))
+			}
+		}
+		han = han[:0]
+	}
+	flushWord := func() {
+		if len(word) > 0 {
+			tokens = append(tokens, string(word))
+			word = word[:0]
+		}
+	}
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			flushWord()
+			han = append(han, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushHan()
+			word = append(word, unicode.ToLower(r))
+		default:
+			flushHan()
+			flushWord()
+		}
+	}
+	flushHan()
+	flushWord()
+	return tokens
+}
+
+// 文字的字数(汉字、字母、数字)
+func runeCount(text string) int {
+	n := 0
+	for _, r := range text {
+		if !unicode.IsSpace(r) {
+			n++
+		}
+	}
+	return n
+}
+
+// 按句子切分(保留句末标点)
+func splitSentences(text string) []string {
+	sentences := []string{}
+	var b strings.Builder
+	for _, r := range text {
+		b.WriteRune(r)
+		switch r {
+		case '。', '！', '？', '；', '!', '?', ';', '\n':
+			if s := strings.TrimSpace(b.String()); s != "" {
+				sentences = append(sentences, s)
+			}
+			b.Reset()
+		}
+	}
+	if s := strings.TrimSpace(b.String()); s != "" {
+		sentences = append(sentences, s)
+	}
+	return sentences
+}
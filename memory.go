@@ -0,0 +1,536 @@
+package xiaobot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"ninego/log"
+	"xiaobot/jarvis"
+	"xiaobot/jsengine"
+)
+
+/*
+长期记忆
+	记住家里长期不变的事实(如 奶奶有糖尿病、孩子上三年级)，问AI时把和提问最相关的记忆附加到系统提示词。
+	记忆的来源：
+		语音说“记住…”(需在 skills 中启用 "memory" 技能)
+		配置 memory_auto 为 true 时，连续对话结束后或累计几次AI问答后，用AI从对话中总结需要长期记住的事实
+		网页 /memory 添加
+	语音(memory 技能)：记住奶奶有糖尿病、你记得什么、忘记奶奶的病、把孩子上学的记忆改成孩子上四年级、忘记所有记忆(需确认)
+	网页 /memory 查看、修改、删除记忆。
+	记忆保存在 bot.storage 的 memory 命名空间(键为记忆ID)。
+*/
+
+// MemoryCategories 记忆的类别
+var MemoryCategories = []string{"健康", "家人", "喜好", "日程", "其他"}
+
+// Memory 一条记忆
+type Memory struct {
+	ID       string `json:"id"`
+	Text     string `json:"text"`
+	Category string `json:"category"`
+	Source   string `json:"source"`  //voice=语音，auto=AI总结，web=网页
+	Created  int64  `json:"created"` //Unix毫秒
+	Updated  int64  `json:"updated"`
+}
+
+const (
+	defaultMemoryInject = 10
+	memoryBatch         = 5   //非连续对话累计几次AI问答后总结记忆
+	memorySameScore     = 0.8 //语音记住的内容和已有记忆相似度达到时视为同一条(更新)
+	memoryFindScore     = 0.3 //按描述查找记忆时，描述中至少这个比例的二字组出现在记忆里
+)
+
+var (
+	memoryInject int        //配置 memory_inject
+	memoryMu     sync.Mutex //新增、修改记忆
+)
+
+func init() {
+	jarvis.AddPromptContext(memoryPrompt)
+	RegisterSkill(&memorySkill{}, false)
+}
+
+func memoryStore() *jsengine.SharedData {
+	return jsengine.ScriptStorage().Namespace("memory")
+}
+
+func memoryFrom(v interface{}) *Memory {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	m := &Memory{}
+	if json.Unmarshal(data, m) != nil || m.Text == "" {
+		return nil
+	}
+	return m
+}
+
+// 保存到存储中的值：用map保存(和从快照读取的相同)，不保存*Memory，脚本读取到的是副本
+func (m *Memory) value() map[string]interface{} {
+	return map[string]interface{}{
+		"id":       m.ID,
+		"text":     m.Text,
+		"category": m.Category,
+		"source":   m.Source,
+		"created":  m.Created,
+		"updated":  m.Updated,
+	}
+}
+
+// Memories 全部记忆(最近修改的在前)
+func Memories() []*Memory {
+	store := memoryStore()
+	list := []*Memory{}
+	for _, key := range store.Keys() {
+		if m := memoryFrom(store.Get(key)); m != nil {
+			m.ID = key
+			list = append(list, m)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Updated > list[j].Updated })
+	return list
+}
+
+// GetMemory 按ID读取记忆
+func GetMemory(id string) *Memory {
+	m := memoryFrom(memoryStore().Get(id))
+	if m != nil {
+		m.ID = id
+	}
+	return m
+}
+
+func normalizeMemory(text string) string {
+	return strings.Trim(strings.TrimSpace(text), " ：:，,。.！!")
+}
+
+func validCategory(category, text string) string {
+	for _, c := range MemoryCategories {
+		if c == category {
+			return c
+		}
+	}
+	return memoryCategory(text)
+}
+
+// 按内容猜测记忆的类别
+func memoryCategory(text string) string {
+	switch {
+	case containsAny(text, "病", "过敏", "血压", "血糖", "药", "医院", "手术", "身体", "怀孕", "视力"):
+		return "健康"
+	case containsAny(text, "生日", "纪念日", "每天", "每周", "每月", "周末", "上班", "上学", "放学", "考试"):
+		return "日程"
+	case containsAny(text, "喜欢", "爱吃", "爱喝", "爱听", "不吃", "不喝", "讨厌", "最爱", "口味"):
+		return "喜好"
+	case containsAny(text, "奶奶", "爷爷", "外婆", "外公", "姥姥", "姥爷", "爸", "妈", "孩子", "儿子", "女儿", "老婆", "老公", "哥", "姐", "弟", "妹", "宝宝", "年级", "家"):
+		return "家人"
+	}
+	return "其他"
+}
+
+// Remember 保存记忆，和已有记忆几乎相同时更新已有的记忆；返回保存的记忆和是否为更新
+func Remember(text, category, source string) (*Memory, bool) {
+	text = normalizeMemory(text)
+	if text == "" {
+		return nil, false
+	}
+	memoryMu.Lock()
+	defer memoryMu.Unlock()
+	now := time.Now().UnixMilli()
+	for _, m := range Memories() {
+		if memorySimilarity(m.Text, text) >= memorySameScore {
+			m.Text, m.Category, m.Updated = text, validCategory(category, text), now
+			memoryStore().Set(m.ID, m.value())
+			log.Printf("更新记忆[%s]: %s\n", m.Category, m.Text)
+			return m, true
+		}
+	}
+	m := &Memory{
+		ID:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		Text:     text,
+		Category: validCategory(category, text),
+		Source:   source,
+		Created:  now,
+		Updated:  now,
+	}
+	memoryStore().Set(m.ID, m.value())
+	log.Printf("记住[%s]: %s\n", m.Category, m.Text)
+	return m, false
+}
+
+// UpdateMemory 修改记忆的内容和类别(类别为空时按内容猜测)
+func UpdateMemory(id, text, category string) (*Memory, error) {
+	text = normalizeMemory(text)
+	if text == "" {
+		return nil, errors.New("记忆的内容为空")
+	}
+	memoryMu.Lock()
+	defer memoryMu.Unlock()
+	m := GetMemory(id)
+	if m == nil {
+		return nil, fmt.Errorf("没有记忆: %s", id)
+	}
+	m.Text, m.Category, m.Updated = text, validCategory(category, text), time.Now().UnixMilli()
+	memoryStore().Set(id, m.value())
+	log.Printf("修改记忆[%s]: %s\n", m.Category, m.Text)
+	return m, nil
+}
+
+// ForgetMemory 删除记忆
+func ForgetMemory(id string) bool {
+	store := memoryStore()
+	if !store.Has(id) {
+		return false
+	}
+	store.Delete(id)
+	log.Println("删除记忆:", id)
+	return true
+}
+
+// ForgetAllMemories 删除全部记忆，返回删除的条数
+func ForgetAllMemories() int {
+	store := memoryStore()
+	n := len(store.Keys())
+	store.Clear()
+	log.Println("删除全部记忆:", n)
+	return n
+}
+
+// 去掉标点和空白后的二字组
+func memoryBigrams(text string) []string {
+	runes := []rune{}
+	for _, r := range text {
+		if !unicode.IsPunct(r) && !unicode.IsSpace(r) && !unicode.IsSymbol(r) {
+			runes = append(runes, r)
+		}
+	}
+	if len(runes) == 1 {
+		return []string{string(runes)}
+	}
+	grams := make([]string, 0, len(runes))
+	for i := 0; i+1 < len(runes); i++ {
+		grams = append(grams, string(runes[i:i+2]))
+	}
+	return grams
+}
+
+// 两段文字的相似度(0~1)
+func memorySimilarity(a, b string) float64 {
+	ga, gb := memoryBigrams(a), memoryBigrams(b)
+	if len(ga) == 0 || len(gb) == 0 {
+		return 0
+	}
+	count := map[string]int{}
+	for _, g := range ga {
+		count[g]++
+	}
+	common := 0
+	for _, g := range gb {
+		if count[g] > 0 {
+			count[g]--
+			common++
+		}
+	}
+	return 2 * float64(common) / float64(len(ga)+len(gb))
+}
+
+// 记忆和提问相关的程度：提问中出现在记忆(含类别)里的二字组个数
+func memoryRelevance(m *Memory, query string) int {
+	text := m.Category + m.Text
+	score := 0
+	for _, g := range memoryBigrams(query) {
+		if strings.Contains(text, g) {
+			score++
+		}
+	}
+	return score
+}
+
+// FindMemory 和描述最相关的记忆，没有足够相关的记忆时返回nil(如“忘记密码怎么办”不对应“WiFi密码是…”)
+func FindMemory(about string) *Memory {
+	grams := len(memoryBigrams(about))
+	if grams == 0 {
+		return nil
+	}
+	var best *Memory
+	bestScore := 0
+	for _, m := range Memories() {
+		if score := memoryRelevance(m, about); score > bestScore {
+			best, bestScore = m, score
+		}
+	}
+	if float64(bestScore)/float64(grams) < memoryFindScore {
+		return nil
+	}
+	return best
+}
+
+// RelevantMemories 和提问最相关的n条记忆(记忆不超过n条时为全部)
+func RelevantMemories(query string, n int) []*Memory {
+	list := Memories()
+	if len(list) <= n {
+		return list
+	}
+	scores := make(map[string]int, len(list))
+	for _, m := range list {
+		scores[m.ID] = memoryRelevance(m, query)
+	}
+	sort.SliceStable(list, func(i, j int) bool { return scores[list[i].ID] > scores[list[j].ID] })
+	return list[:n]
+}
+
+// 附加到系统提示词的记忆
+func memoryPrompt(query string) string {
+	n := memoryInject
+	if n == 0 {
+		n = defaultMemoryInject
+	}
+	if n < 0 {
+		return ""
+	}
+	list := RelevantMemories(query, n)
+	if len(list) == 0 {
+		return ""
+	}
+	lines := make([]string, 0, len(list)+1)
+	lines = append(lines, "以下是关于用户家里的长期记忆(“我”指用户)，需要时参考，不必复述：")
+	for _, m := range list {
+		lines = append(lines, fmt.Sprintf("- [%s] %s（%s）", m.Category, m.Text, time.UnixMilli(m.Updated).Format(time.DateOnly)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// 总结记忆的提示词
+const memoryExtractPrompt = `你负责从家庭智能音箱的对话中找出需要长期记住的事实，如家人的健康状况、年龄年级、喜好、生日和固定的日程。
+不要记录一次性的问题、闲聊、天气、新闻和AI自己的回答内容。
+已有的记忆(ID|类别|内容)：
+%s
+每行输出一条，格式为：
+新增|类别|事实
+更新|ID|新的事实
+类别是：%s。事实用简短的陈述句，“我”指用户。没有需要记住的内容时只输出：无`
+
+var memoryPending struct {
+	sync.Mutex
+	dialogue []jarvis.RoleContent
+}
+
+// collectMemory 记录一次AI问答(memory_auto)，累计 memoryBatch 次后总结记忆
+func (mt *MiBot) collectMemory(query, answer string) {
+	if !mt.config.MemoryAuto || !mt.HasGPT || answer == "" {
+		return
+	}
+	memoryPending.Lock()
+	memoryPending.dialogue = append(memoryPending.dialogue,
+		jarvis.RoleContent{Role: "user", Content: query},
+		jarvis.RoleContent{Role: "assistant", Content: answer},
+	)
+	if len(memoryPending.dialogue) < memoryBatch*2 {
+		memoryPending.Unlock()
+		return
+	}
+	dialogue := memoryPending.dialogue
+	memoryPending.dialogue = nil
+	memoryPending.Unlock()
+	go mt.extractMemories(dialogue)
+}
+
+// extractMemories 用AI从对话中总结需要长期记住的事实(memory_auto)
+func (mt *MiBot) extractMemories(dialogue []jarvis.RoleContent) {
+	if !mt.config.MemoryAuto || !mt.HasGPT || len(dialogue) == 0 {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("总结记忆出错:", r)
+		}
+	}()
+	existing := []string{}
+	for _, m := range Memories() {
+		existing = append(existing, m.ID+"|"+m.Category+"|"+m.Text)
+	}
+	if len(existing) == 0 {
+		existing = append(existing, "无")
+	}
+	lines := make([]string, 0, len(dialogue))
+	for _, rc := range dialogue {
+		role := "用户"
+		if rc.Role == "assistant" {
+			role = "AI"
+		}
+		lines = append(lines, role+"："+rc.Content)
+	}
+
+	ai := newAssistant(mt.config.Bot, mt.config.OpenAIKey, mt.config.OpenAIBackend, mt.config.Proxy, "", mt.config.GPTOptions)
+	ai.Prompt = fmt.Sprintf(memoryExtractPrompt, strings.Join(existing, "\n"), strings.Join(MemoryCategories, "、"))
+	ai.NoContext = true
+	result, err := ai.Ask("对话：\n"+strings.Join(lines, "\n"), "")
+	if err != nil {
+		log.Error("总结记忆出错:", err)
+		return
+	}
+	applyMemoryChanges(result, "auto")
+}
+
+// 保存AI总结的记忆：新增|类别|事实、更新|ID|事实
+func applyMemoryChanges(result, source string) int {
+	n := 0
+	for _, line := range strings.Split(result, "\n") {
+		parts := strings.SplitN(strings.TrimLeft(strings.TrimSpace(line), "-* "), "|", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		switch strings.TrimSpace(parts[0]) {
+		case "新增":
+			if m, _ := Remember(parts[2], strings.TrimSpace(parts[1]), source); m != nil {
+				n++
+			}
+		case "更新":
+			if m := GetMemory(strings.TrimSpace(parts[1])); m != nil {
+				if _, err := UpdateMemory(m.ID, parts[2], m.Category); err == nil {
+					n++
+				}
+			}
+		}
+	}
+	return n
+}
+
+// memorySkill 语音管理记忆：记住…、你记得什么、忘记…、把…的记忆改成…、忘记所有记忆
+type memorySkill struct{}
+
+var (
+	rememberWords   = []string{"请记住", "帮我记住", "你要记住", "你记住", "记住", "记一下"}
+	forgetWords     = []string{"请忘记", "忘记", "忘掉", "不要记住", "别记住", "删除记忆", "删掉记忆"}
+	listMemoryWords = []string{"你记得什么", "你都记得什么", "你记住了什么", "你都记住了什么", "你记得哪些", "我的记忆", "有哪些记忆", "有什么记忆"}
+	editMemoryRegex = regexp.MustCompile(`^(?:把|将)?(?:关于)?(.+?)的?记忆(?:改成|改为|修改为|更正为)(.+)$`)
+)
+
+func (*memorySkill) Name() string { return "memory" }
+
+func (*memorySkill) Match(query string) float64 {
+	query = strings.TrimRight(query, "。.!！?？")
+	switch {
+	case editMemoryRegex.MatchString(query):
+		return 0.95
+	case containsAny(query, listMemoryWords...):
+		return 0.9
+	}
+	for _, w := range rememberWords {
+		if strings.HasPrefix(query, w) && len(query) > len(w) {
+			return 0.95
+		}
+	}
+	// 只处理有相关记忆的忘记(如“忘记密码怎么办”交给AI)
+	if text, ok := trimPrefixAny(query, forgetWords); ok && text != "" {
+		if topic := forgetTopic(text); isForgetAll(topic) || FindMemory(topic) != nil {
+			return 0.95
+		}
+	}
+	return 0
+}
+
+func isForgetAll(topic string) bool {
+	return containsAny(topic, "所有", "全部", "一切")
+}
+
+func trimPrefixAny(s string, prefixes []string) (string, bool) {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return s[len(p):], true
+		}
+	}
+	return s, false
+}
+
+// 忘记的内容：去掉“关于”“的记忆”等
+func forgetTopic(text string) string {
+	text = normalizeMemory(text)
+	text = strings.TrimPrefix(text, "关于")
+	for _, suffix := range []string{"的记忆", "这件事", "的事"} {
+		text = strings.TrimSuffix(text, suffix)
+	}
+	return text
+}
+
+func (*memorySkill) Handle(ctx *SkillContext) error {
+	query := strings.TrimRight(ctx.Query, "。.!！?？")
+	if m := editMemoryRegex.FindStringSubmatch(query); m != nil {
+		old := FindMemory(m[1])
+		if old == nil {
+			return ctx.Say("没有关于" + m[1] + "的记忆")
+		}
+		updated, err := UpdateMemory(old.ID, m[2], "")
+		if err != nil {
+			return err
+		}
+		return ctx.Say("好的，已改成：" + updated.Text)
+	}
+	if text, ok := trimPrefixAny(query, rememberWords); ok {
+		m, updated := Remember(text, "", "voice")
+		if m == nil {
+			return ctx.Say("要记住什么呢？可以说：记住奶奶有糖尿病")
+		}
+		if updated {
+			return ctx.Say("好的，已更新记忆：" + m.Text)
+		}
+		return ctx.Say("好的，我记住了：" + m.Text)
+	}
+	if text, ok := trimPrefixAny(query, forgetWords); ok {
+		topic := forgetTopic(text)
+		if isForgetAll(topic) {
+			n := len(Memories())
+			if n == 0 {
+				return ctx.Say("我还没有记住任何事")
+			}
+			return ctx.StartDialog(&DialogSpec{
+				Name:    "forget-memories",
+				Risky:   true,
+				Confirm: fmt.Sprintf("要忘记全部%d条记忆吗？", n),
+				Done: func(ctx *SkillContext, slots map[string]interface{}) error {
+					return ctx.Say(fmt.Sprintf("好的，已忘记%d条记忆", ForgetAllMemories()))
+				},
+			}, nil)
+		}
+		m := FindMemory(topic)
+		if m == nil {
+			return ctx.Say("没有关于" + topic + "的记忆")
+		}
+		return ctx.StartDialog(&DialogSpec{
+			Name:    "forget-memory",
+			Risky:   true,
+			Confirm: "要忘记“" + m.Text + "”吗？",
+			Done: func(ctx *SkillContext, slots map[string]interface{}) error {
+				ForgetMemory(m.ID)
+				return ctx.Say("好的，已忘记：" + m.Text)
+			},
+		}, nil)
+	}
+
+	list := Memories()
+	if len(list) == 0 {
+		return ctx.Say("我还没有记住任何事，可以说：记住奶奶有糖尿病")
+	}
+	items := []string{}
+	for i, m := range list {
+		if i == 10 {
+			break
+		}
+		items = append(items, m.Text)
+	}
+	text := fmt.Sprintf("我记得%d件事：%s", len(list), strings.Join(items, "；"))
+	if len(list) > len(items) {
+		text += "；等等"
+	}
+	return ctx.Say(text)
+}
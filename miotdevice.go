@@ -0,0 +1,228 @@
+package xiaobot
+
+import (
+	"fmt"
+	"ninego/log"
+	"strings"
+	"sync"
+	"time"
+
+	"xiaobot/miservice"
+)
+
+// 米家设备控制(按设备名称和规格名称，而不是siid/piid)
+// require('miot').get('台灯', 'light.on')、set('台灯', 'brightness', 50)、call('洗衣机', 'start-wash')
+
+var miotDevices = struct {
+	sync.Mutex
+	list    []miservice.DeviceInfo
+	fetched time.Time
+}{}
+
+// MiotDevices 账号下的设备列表(缓存10分钟)
+func (mt *XiaoMi) MiotDevices() ([]miservice.DeviceInfo, error) {
+	miotDevices.Lock()
+	defer miotDevices.Unlock()
+	if miotDevices.list != nil && time.Since(miotDevices.fetched) < 10*time.Minute {
+		return miotDevices.list, nil
+	}
+	devices, err := mt.miioService.DeviceList(false, 0)
+	if err != nil {
+		return nil, err
+	}
+	miotDevices.list, miotDevices.fetched = devices, time.Now()
+	return devices, nil
+}
+
+// FindDevice 按did、名称(完全相同优先，其次包含)或型号查找设备
+func (mt *XiaoMi) FindDevice(name string) (*miservice.DeviceInfo, error) {
+	devices, err := mt.MiotDevices()
+	if err != nil {
+		return nil, err
+	}
+	for i := range devices {
+		if devices[i].Did == name || devices[i].Name == name {
+			return &devices[i], nil
+		}
+	}
+	for i := range devices {
+		if strings.Contains(devices[i].Name, name) || devices[i].Model == name {
+			return &devices[i], nil
+		}
+	}
+	return nil, fmt.Errorf("未找到设备: %s", name)
+}
+
+// MiotSpec 设备的MIoT规格
+func (mt *XiaoMi) MiotSpec(device string) (*miservice.DeviceInfo, *miservice.MiotSpec, error) {
+	dev, err := mt.FindDevice(device)
+	if err != nil {
+		return nil, nil, err
+	}
+	spec, err := mt.miioService.MiotSpecOf(dev.Model)
+	if err != nil {
+		return dev, nil, err
+	}
+	return dev, spec, nil
+}
+
+// MiotGet 读取设备属性，如 MiotGet("台灯", "light.on")
+func (mt *XiaoMi) MiotGet(device, prop string) (interface{}, error) {
+	dev, spec, err := mt.MiotSpec(device)
+	if err != nil {
+		return nil, err
+	}
+	siid, p, err := spec.FindProperty(prop)
+	if err != nil {
+		return nil, err
+	}
+	return mt.miioService.MiotGetProp(dev.Did, miservice.Iid{Siid: siid, Piid: p.Iid})
+}
+
+// MiotSet 设置设备属性，值按属性格式转换(如"on"->true，枚举值可用描述)
+func (mt *XiaoMi) MiotSet(device, prop string, value interface{}) error {
+	dev, spec, err := mt.MiotSpec(device)
+	if err != nil {
+		return err
+	}
+	siid, p, err := spec.FindProperty(prop)
+	if err != nil {
+		return err
+	}
+	code, err := mt.miioService.MiotSetProp(dev.Did, miservice.Iid{Siid: siid, Piid: p.Iid}, p.ConvertValue(value))
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("设置%s的%s失败: code=%d", dev.Name, prop, code)
+	}
+	return nil
+}
+
+// MiotCall 执行设备方法，如 MiotCall("洗衣机", "washer.start-wash", nil)
+func (mt *XiaoMi) MiotCall(device, action string, args []interface{}) error {
+	dev, spec, err := mt.MiotSpec(device)
+	if err != nil {
+		return err
+	}
+	siid, a, err := spec.FindAction(action)
+	if err != nil {
+		return err
+	}
+	if args == nil {
+		args = []interface{}{}
+	}
+	code, err := mt.miioService.MiotAction(dev.Did, []int{siid, a.Iid}, args)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("执行%s的%s失败: code=%v", dev.Name, action, code)
+	}
+	return nil
+}
+
+// 设备列表和规格转为JS可用的形式
+func miotDeviceMap(dev miservice.DeviceInfo) map[string]interface{} {
+	return map[string]interface{}{"name": dev.Name, "model": dev.Model, "did": dev.Did, "ip": dev.LocalIP}
+}
+
+func miotSpecMap(spec *miservice.MiotSpec) []map[string]interface{} {
+	services := make([]map[string]interface{}, 0, len(spec.Services))
+	for _, svc := range spec.Services {
+		props := make([]map[string]interface{}, 0, len(svc.Properties))
+		for _, p := range svc.Properties {
+			prop := map[string]interface{}{
+				"name":        miservice.SpecName(p.Type),
+				"iid":         p.Iid,
+				"description": p.Description,
+				"format":      p.Format,
+				"access":      strings.Join(p.Access, ","),
+			}
+			if p.Unit != "" && p.Unit != "none" {
+				prop["unit"] = p.Unit
+			}
+			if len(p.ValueRange) > 0 {
+				prop["range"] = p.ValueRange
+			}
+			if len(p.ValueList) > 0 {
+				values := make(map[string]interface{}, len(p.ValueList))
+				for _, v := range p.ValueList {
+					values[v.Description] = v.Value
+				}
+				prop["values"] = values
+			}
+			props = append(props, prop)
+		}
+		actions := make([]map[string]interface{}, 0, len(svc.Actions))
+		for _, a := range svc.Actions {
+			actions = append(actions, map[string]interface{}{
+				"name":        miservice.SpecName(a.Type),
+				"iid":         a.Iid,
+				"description": a.Description,
+			})
+		}
+		services = append(services, map[string]interface{}{
+			"name":        miservice.SpecName(svc.Type),
+			"iid":         svc.Iid,
+			"description": svc.Description,
+			"properties":  props,
+			"actions":     actions,
+		})
+	}
+	return services
+}
+
+// 注册到 require('miot')
+func (mt *XiaoMi) setMiotModule(miot map[string]interface{}) {
+	miot["devices"] = func() []map[string]interface{} {
+		devices, err := mt.MiotDevices()
+		if err != nil {
+			log.Error("miot.devices:", err)
+			return nil
+		}
+		list := make([]map[string]interface{}, 0, len(devices))
+		for _, dev := range devices {
+			list = append(list, miotDeviceMap(dev))
+		}
+		return list
+	}
+	miot["spec"] = func(device string) []map[string]interface{} {
+		_, spec, err := mt.MiotSpec(device)
+		if err != nil {
+			log.Error("miot.spec:", err)
+			return nil
+		}
+		return miotSpecMap(spec)
+	}
+	miot["get"] = func(device, prop string) interface{} {
+		value, err := mt.MiotGet(device, prop)
+		if err != nil {
+			log.Error("miot.get:", err)
+			return nil
+		}
+		return value
+	}
+	miot["set"] = func(device, prop string, value interface{}) bool {
+		if err := mt.MiotSet(device, prop, value); err != nil {
+			log.Error("miot.set:", err)
+			return false
+		}
+		return true
+	}
+	miot["call"] = func(device, action string, args interface{}) bool {
+		var in []interface{}
+		switch a := args.(type) {
+		case []interface{}:
+			in = a
+		case nil:
+		default:
+			in = []interface{}{a}
+		}
+		if err := mt.MiotCall(device, action, in); err != nil {
+			log.Error("miot.call:", err)
+			return false
+		}
+		return true
+	}
+}
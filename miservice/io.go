@@ -53,7 +53,15 @@ func (s *IOService) Request(uri string, data map[string]interface{}) (map[string
 
 	result, ok := resp["result"].(map[string]interface{})
 	if !ok {
-		return nil, fmt.Errorf("error %s: %v", uri, resp)
+		// prop/get、prop/set 返回数组，按序号转为map
+		list, isList := resp["result"].([]interface{})
+		if !isList {
+			return nil, fmt.Errorf("error %s: %v", uri, resp)
+		}
+		result = make(map[string]interface{}, len(list))
+		for i, v := range list {
+			result[strconv.Itoa(i)] = v
+		}
 	}
 
 	return result, nil
@@ -204,7 +212,7 @@ func (s *IOService) MiotGetProps(did string, iids []Iid) ([]interface{}, error)
 	for i, it := range result {
 		index, _ := strconv.Atoi(i)
 		itm := it.(map[string]interface{})
-		if code, ok := itm["code"].(int); ok && code == 0 {
+		if code, ok := itm["code"]; ok && toInt(code) == 0 {
 			values[index] = itm["value"]
 		} else {
 			values[index] = nil
@@ -234,7 +242,7 @@ func (s *IOService) MiotSetProps(did string, props map[Iid]interface{}) ([]int,
 	for i, it := range result {
 		index, _ := strconv.Atoi(i)
 		itm := it.(map[string]interface{})
-		codes[index] = itm["code"].(int)
+		codes[index] = toInt(itm["code"])
 	}
 	return codes, nil
 }
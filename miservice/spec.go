@@ -0,0 +1,270 @@
+package miservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MIoT规格的本地缓存目录(每个型号一个json文件)
+var SpecCacheDir = filepath.Join(os.TempDir(), "miot-spec")
+
+// MiotSpec 设备型号的MIoT规格 http://miot-spec.org/miot-spec-v2/instance?type=urn...
+type MiotSpec struct {
+	Type        string        `json:"type"`
+	Description string        `json:"description"`
+	Services    []SpecService `json:"services"`
+}
+
+type SpecService struct {
+	Iid         int            `json:"iid"`
+	Type        string         `json:"type"`
+	Description string         `json:"description"`
+	Properties  []SpecProperty `json:"properties,omitempty"`
+	Actions     []SpecAction   `json:"actions,omitempty"`
+}
+
+type SpecProperty struct {
+	Iid         int         `json:"iid"`
+	Type        string      `json:"type"`
+	Description string      `json:"description"`
+	Format      string      `json:"format"`
+	Access      []string    `json:"access"`
+	Unit        string      `json:"unit,omitempty"`
+	ValueRange  []float64   `json:"value-range,omitempty"`
+	ValueList   []SpecValue `json:"value-list,omitempty"`
+}
+
+type SpecValue struct {
+	Value       int    `json:"value"`
+	Description string `json:"description"`
+}
+
+type SpecAction struct {
+	Iid         int    `json:"iid"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	In          []int  `json:"in"`
+	Out         []int  `json:"out"`
+}
+
+var specCache = struct {
+	sync.Mutex
+	specs map[string]*MiotSpec
+}{specs: make(map[string]*MiotSpec)}
+
+// SpecName 取类型URN中的名称，如 urn:miot-spec-v2:property:brightness:0000000D:... -> brightness
+func SpecName(urn string) string {
+	parts := strings.Split(urn, ":")
+	if len(parts) > 3 {
+		return parts[3]
+	}
+	return urn
+}
+
+// MiotSpecOf 取设备型号的MIoT规格，依次从内存、本地缓存、miot-spec.org获取
+func (s *IOService) MiotSpecOf(model string) (*MiotSpec, error) {
+	specCache.Lock()
+	defer specCache.Unlock()
+	if spec, ok := specCache.specs[model]; ok {
+		return spec, nil
+	}
+
+	file := filepath.Join(SpecCacheDir, model+".json")
+	if spec, err := loadMiotSpec(file); err == nil {
+		specCache.specs[model] = spec
+		return spec, nil
+	}
+
+	urn, err := s.specType(model)
+	if err != nil {
+		return nil, err
+	}
+	r, err := s.account.client.Get("http://miot-spec.org/miot-spec-v2/instance?type=" + urn)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	var spec MiotSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		return nil, err
+	}
+	if len(spec.Services) == 0 {
+		return nil, fmt.Errorf("型号%s没有MIoT规格", model)
+	}
+	if err := os.MkdirAll(SpecCacheDir, 0755); err == nil {
+		if f, err := os.Create(file); err == nil {
+			json.NewEncoder(f).Encode(&spec)
+			f.Close()
+		}
+	}
+	specCache.specs[model] = &spec
+	return &spec, nil
+}
+
+func loadMiotSpec(file string) (*MiotSpec, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var spec MiotSpec
+	if err := json.Unmarshal(content, &spec); err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+// 型号对应的规格类型URN(型号列表缓存在 miot-spec.json 中，见IotSpec)
+func (s *IOService) specType(model string) (string, error) {
+	p := path.Join(os.TempDir(), "miot-spec.json")
+	specs, err := loadSpec(p)
+	if err != nil || specs[model] == "" {
+		os.Remove(p)
+		if _, err := s.IotSpec(""); err != nil {
+			return "", err
+		}
+		if specs, err = loadSpec(p); err != nil {
+			return "", err
+		}
+	}
+	urn, ok := specs[model]
+	if !ok {
+		return "", fmt.Errorf("未找到型号%s的MIoT规格", model)
+	}
+	return urn, nil
+}
+
+// 名称匹配：类型名(如on/brightness)或描述(如Switch Status)，不区分大小写
+func specMatch(urn, description, name string) bool {
+	return strings.EqualFold(SpecName(urn), name) || strings.EqualFold(description, name)
+}
+
+// 拆分 "服务.属性"，返回服务名(可为空)和属性名
+func splitSpecName(name string) (string, string) {
+	if i := strings.LastIndex(name, "."); i > 0 {
+		return name[:i], name[i+1:]
+	}
+	return "", name
+}
+
+// 按 siid.iid 数字形式解析
+func parseIids(name string) (int, int, bool) {
+	service, item := splitSpecName(name)
+	siid, err1 := strconv.Atoi(service)
+	iid, err2 := strconv.Atoi(item)
+	return siid, iid, err1 == nil && err2 == nil
+}
+
+// FindProperty 按 "服务.属性"(如light.on)、"属性"(如brightness，取第一个匹配的服务) 或 "siid.piid"(如2.1) 查找属性
+func (spec *MiotSpec) FindProperty(name string) (int, *SpecProperty, error) {
+	if siid, piid, ok := parseIids(name); ok {
+		for i := range spec.Services {
+			if spec.Services[i].Iid != siid {
+				continue
+			}
+			for j := range spec.Services[i].Properties {
+				if spec.Services[i].Properties[j].Iid == piid {
+					return siid, &spec.Services[i].Properties[j], nil
+				}
+			}
+		}
+		return siid, &SpecProperty{Iid: piid}, nil
+	}
+	service, prop := splitSpecName(name)
+	for i := range spec.Services {
+		svc := &spec.Services[i]
+		if service != "" && !specMatch(svc.Type, svc.Description, service) {
+			continue
+		}
+		for j := range svc.Properties {
+			if specMatch(svc.Properties[j].Type, svc.Properties[j].Description, prop) {
+				return svc.Iid, &svc.Properties[j], nil
+			}
+		}
+	}
+	return 0, nil, fmt.Errorf("未找到属性: %s", name)
+}
+
+// FindAction 按 "服务.方法"(如washer.start-wash)、"方法" 或 "siid.aiid" 查找方法
+func (spec *MiotSpec) FindAction(name string) (int, *SpecAction, error) {
+	if siid, aiid, ok := parseIids(name); ok {
+		return siid, &SpecAction{Iid: aiid}, nil
+	}
+	service, action := splitSpecName(name)
+	for i := range spec.Services {
+		svc := &spec.Services[i]
+		if service != "" && !specMatch(svc.Type, svc.Description, service) {
+			continue
+		}
+		for j := range svc.Actions {
+			if specMatch(svc.Actions[j].Type, svc.Actions[j].Description, action) {
+				return svc.Iid, &svc.Actions[j], nil
+			}
+		}
+	}
+	return 0, nil, fmt.Errorf("未找到方法: %s", name)
+}
+
+// ConvertValue 按属性格式转换值，如 "on"/"true" -> true, "50" -> 50，枚举值可用描述
+func (prop *SpecProperty) ConvertValue(value interface{}) interface{} {
+	var f float64
+	switch v := value.(type) {
+	case string:
+		return prop.convertString(v)
+	case bool:
+		if prop.Format == "bool" {
+			return v
+		}
+		if v { //数值属性用 true/false 表示 1/0
+			f = 1
+		}
+	case float64:
+		f = v
+	case int: //脚本中的整数(goja导出为int64)
+		f = float64(v)
+	case int64:
+		f = float64(v)
+	default:
+		return value
+	}
+	switch {
+	case prop.Format == "bool":
+		return f != 0
+	case prop.Format == "float":
+		return f
+	case strings.HasPrefix(prop.Format, "int") || strings.HasPrefix(prop.Format, "uint"):
+		return int(f)
+	}
+	return value
+}
+
+// convertString 按属性格式转换字符串的值
+func (prop *SpecProperty) convertString(str string) interface{} {
+	switch {
+	case prop.Format == "bool":
+		switch strings.ToLower(str) {
+		case "true", "on", "1", "开":
+			return true
+		}
+		return false
+	case prop.Format == "float":
+		if f, err := strconv.ParseFloat(str, 64); err == nil {
+			return f
+		}
+	case strings.HasPrefix(prop.Format, "int") || strings.HasPrefix(prop.Format, "uint"):
+		if i, err := strconv.Atoi(str); err == nil {
+			return i
+		}
+		for _, v := range prop.ValueList {
+			if strings.EqualFold(v.Description, str) {
+				return v.Value
+			}
+		}
+	}
+	return str
+}
@@ -134,3 +134,17 @@ func isDigit(s string) bool {
 	}
 	return true
 }
+
+// JSON中的数字(float64/json.Number)转为int
+func toInt(v interface{}) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case float64:
+		return int(n)
+	case json.Number:
+		i, _ := n.Int64()
+		return int(i)
+	}
+	return -1
+}
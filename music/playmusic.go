@@ -9,6 +9,7 @@ import (
 	"sync"
 	"time"
 	. "xiaobot"
+	"xiaobot/jsengine"
 )
 
 type playState struct {
@@ -146,6 +147,12 @@ func Play(bot *MiBot, cfg *Config) error {
 
 					// 通知前端当前播放的音乐
 					PushToAll(currentMusic.Name)
+					go jsengine.Hooks.Emit(jsengine.EventMusicTrack, map[string]interface{}{
+						"name":     currentMusic.Name,
+						"path":     filePath,
+						"url":      fullUrl,
+						"duration": duration.Seconds(),
+					})
 
 					// 执行播放操作
 					if err := bot.Box.MiPlay(fullUrl); err != nil {
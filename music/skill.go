@@ -0,0 +1,121 @@
+package music
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+
+	"ninego/log"
+	. "xiaobot"
+)
+
+// 本地音乐技能(配置 skills 中 "music": true 启用)
+func init() {
+	RegisterSkill(musicSkill{}, false)
+}
+
+// musicSkill 播放本地音乐：播放本地音乐晴天、本地播放周杰伦、播放收藏的歌、停止播放本地音乐；
+// 本地音乐播放时还处理 下一首、上一首
+type musicSkill struct{}
+
+var (
+	localPlayWords = []string{"播放本地音乐", "播放本地的", "本地播放", "放本地的", "放一首本地的"}
+	favoriteWords  = []string{"播放收藏的", "放收藏的", "播放我收藏的", "放我收藏的"}
+)
+
+func (musicSkill) Name() string { return "music" }
+
+// 本地音乐是否正在播放
+func localPlaying() bool {
+	PlayerMutex.Lock()
+	defer PlayerMutex.Unlock()
+	return PlayChannel != nil && !PlayChannel.IsClosed()
+}
+
+func (musicSkill) Match(query string) float64 {
+	for _, w := range append(localPlayWords, favoriteWords...) {
+		if strings.HasPrefix(query, w) {
+			return 0.9
+		}
+	}
+	if strings.Contains(query, "本地音乐") && strings.Contains(query, "停") {
+		return 0.9
+	}
+	if localPlaying() && (strings.Contains(query, "下一首") || strings.Contains(query, "上一首") || query == "停止播放" || query == "暂停") {
+		return 0.8
+	}
+	return 0
+}
+
+func (musicSkill) Handle(ctx *SkillContext) error {
+	query := strings.TrimRight(ctx.Query, "。.!！")
+	switch {
+	case strings.Contains(query, "下一首") || strings.Contains(query, "上一首"):
+		step := 1
+		if strings.Contains(query, "上一首") {
+			step = -1
+		}
+		if SkipChannel != nil && !SkipChannel.IsClosed() {
+			SkipChannel.C <- step
+		}
+		return nil
+	case strings.Contains(query, "停") || query == "暂停":
+		return Stop(ctx.Bot)
+	}
+	if ctx.Config.MusicPath == "" {
+		return ctx.Say("没有设置本地音乐的目录")
+	}
+
+	var files []FileItem
+	keyword := ""
+	random := false
+	for _, w := range favoriteWords {
+		if strings.HasPrefix(query, w) {
+			favoriteMu.RLock()
+			files = append(files, FavoritedMusicFiles...)
+			favoriteMu.RUnlock()
+			if len(files) == 0 {
+				return ctx.Say("还没有收藏的音乐")
+			}
+			random = true
+		}
+	}
+	if files == nil {
+		for _, w := range localPlayWords {
+			if strings.HasPrefix(query, w) {
+				keyword = strings.TrimSpace(strings.TrimPrefix(query, w))
+				break
+			}
+		}
+		keyword = strings.TrimPrefix(keyword, "歌曲")
+		keyword = strings.TrimPrefix(keyword, "音乐")
+		keyword = strings.TrimSuffix(keyword, "的歌")
+		filter := keyword
+		if filter == "" {
+			filter, random = ".", true //全部音乐随机播放
+		}
+		found, err := FindAudioFiles(ctx.Config.MusicPath, "", filter)
+		if err != nil {
+			return err
+		}
+		if len(found) == 0 {
+			return ctx.Say("本地没有找到" + keyword)
+		}
+		files = found
+	}
+
+	log.Printf("播放本地音乐%s: %d首\n", keyword, len(files))
+	PlayerMutex.Lock()
+	PlayerState.FilteredMusicFiles = files
+	PlayerState.CurrentPlayingIndex = 0
+	if random {
+		PlayerState.CurrentPlayingIndex = rand.Intn(len(files))
+	}
+	PlayerState.IsSequencePlaying = !random
+	PlayerState.IsRandomPlaying = random
+	PlayerMutex.Unlock()
+	if err := Play(ctx.Bot, ctx.Config); err != nil {
+		return errors.New("播放本地音乐出错: " + err.Error())
+	}
+	return nil
+}
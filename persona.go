@@ -0,0 +1,257 @@
+package xiaobot
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"ninego/log"
+	"xiaobot/jsengine"
+)
+
+/*
+人设库
+	配置 personas 中每个人设有名称、提示词、AI配置、思考说词、说话风格和可选的TTS声音，如：
+	"personas": [{"name": "英语老师", "aliases": ["老师"], "prompt": "你是英语老师，用中英文回答", "profile": "teacher",
+		"thinking": ["Let me think"], "style": "语速慢，多举例子", "voice": "http://192.168.1.2:5000/tts?voice=en&text={text}"}]
+	切换人设：
+		语音(persona 技能)：切换到英语老师、恢复默认人设、现在是什么人设、有哪些人设
+		网页 /persona
+		脚本 bot.setPersona('英语老师')，如定时任务在放学后切换到孩子的人设；bot.persona() 返回当前人设的名称
+	当前人设按音箱(mi_did)保存在 bot.storage 的 persona 命名空间，重启后恢复。
+	用“现在你是…”(change_prompt_keyword)更改的提示词只在本次运行有效，切换人设后不再恢复。
+*/
+
+// Persona 人设
+type Persona struct {
+	Name     string   `json:"name"`
+	Aliases  []string `json:"aliases,omitempty"`  //语音切换时的其他叫法
+	Prompt   string   `json:"prompt,omitempty"`   //提示词(可用提示词模板)，为空时用配置的 prompt
+	Profile  string   `json:"profile,omitempty"`  //llm_profiles 中的AI配置，为空时用默认的AI
+	Thinking []string `json:"thinking,omitempty"` //思考说词，为空时用配置的 thinking
+	Style    string   `json:"style,omitempty"`    //说话风格，附加到提示词
+	Voice    string   `json:"voice,omitempty"`    //TTS服务地址，{text} 替换为朗读的文字，音箱播放返回的音频；为空时用小爱的TTS
+}
+
+var personas []*Persona //配置 personas
+
+// Personas 配置的人设
+func Personas() []*Persona {
+	return personas
+}
+
+// FindPersona 按名称或别名查找人设(忽略“人设”“模式”等)，没有时返回nil
+func FindPersona(name string) *Persona {
+	name = personaName(name)
+	if name == "" {
+		return nil
+	}
+	for _, p := range personas {
+		if personaName(p.Name) == name {
+			return p
+		}
+		for _, alias := range p.Aliases {
+			if personaName(alias) == name {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+// 人设名称：去掉标点和“人设”“模式”等
+func personaName(name string) string {
+	name = strings.Trim(strings.TrimSpace(name), "。.!！?？，,吧")
+	for _, suffix := range []string{"的人设", "人设", "模式", "角色"} {
+		name = strings.TrimSuffix(name, suffix)
+	}
+	return strings.TrimSpace(name)
+}
+
+func isDefaultPersona(name string) bool {
+	switch personaName(name) {
+	case "", "默认", "原来", "原来的", "默认的":
+		return true
+	}
+	return false
+}
+
+// 人设的系统提示词：提示词(为空时用def)和说话风格
+func (p *Persona) systemPrompt(def string) string {
+	prompt := p.Prompt
+	if prompt == "" {
+		prompt = def
+	}
+	if p.Style != "" {
+		prompt += "\n说话风格：" + p.Style
+	}
+	return prompt
+}
+
+// 人设配置的问题
+func personaErrors(c *Config) []string {
+	errs := []string{}
+	names := map[string]bool{}
+	for i, p := range c.Personas {
+		if p == nil || personaName(p.Name) == "" {
+			errs = append(errs, fmt.Sprintf("第%d个人设没有名称", i+1))
+			continue
+		}
+		if names[personaName(p.Name)] {
+			errs = append(errs, "人设重名: "+p.Name)
+		}
+		names[personaName(p.Name)] = true
+		if p.Profile != "" && c.LLMProfiles[p.Profile] == nil {
+			errs = append(errs, fmt.Sprintf("人设 %s 的AI配置 %s 不在 llm_profiles 中", p.Name, p.Profile))
+		}
+	}
+	return errs
+}
+
+func personaStore() *jsengine.SharedData {
+	return jsengine.ScriptStorage().Namespace("persona")
+}
+
+// 保存当前人设的键(按音箱)
+func (mt *MiBot) personaKey() string {
+	if mt.config.MiDID != "" {
+		return mt.config.MiDID
+	}
+	return "default"
+}
+
+// Persona 当前的人设，默认时为nil
+func (mt *MiBot) Persona() *Persona {
+	mt.personaMu.RLock()
+	defer mt.personaMu.RUnlock()
+	return mt.persona
+}
+
+// SetPersona 切换人设并保存，name为空或“默认”时恢复默认，返回切换后的人设(默认时为nil)
+func (mt *MiBot) SetPersona(name string) (*Persona, error) {
+	var p *Persona
+	if !isDefaultPersona(name) {
+		if p = FindPersona(name); p == nil {
+			return nil, fmt.Errorf("没有人设: %s", name)
+		}
+	}
+	mt.applyPersona(p)
+	if p == nil {
+		personaStore().Delete(mt.personaKey())
+		log.Println("恢复默认人设")
+	} else {
+		personaStore().Set(mt.personaKey(), p.Name)
+		log.Println("切换人设:", p.Name)
+	}
+	return p, nil
+}
+
+func (mt *MiBot) applyPersona(p *Persona) {
+	mt.personaMu.Lock()
+	mt.persona = p
+	mt.personaMu.Unlock()
+	mt.Prompt = "" //不再恢复之前用语音更改的提示词
+	if mt.assistant == nil {
+		return
+	}
+	if p != nil {
+		mt.assistant.SetPrompt(p.systemPrompt(mt.configPrompt))
+	} else {
+		mt.assistant.SetPrompt(mt.configPrompt)
+	}
+}
+
+// 启动时恢复保存的人设
+func (mt *MiBot) restorePersona() {
+	for _, msg := range personaErrors(mt.config) {
+		log.Error(msg)
+	}
+	name, _ := personaStore().Get(mt.personaKey()).(string)
+	if name == "" {
+		return
+	}
+	p := FindPersona(name)
+	if p == nil {
+		log.Println("保存的人设已不在配置中:", name)
+		return
+	}
+	mt.applyPersona(p)
+	log.Println("当前人设:", p.Name)
+}
+
+// 思考说词：人设的或配置的
+func (mt *MiBot) thinkingWords() []string {
+	if p := mt.Persona(); p != nil && len(p.Thinking) > 0 {
+		return p.Thinking
+	}
+	return mt.config.Thinkingwords
+}
+
+// speak 朗读文字：人设设置了声音时播放TTS服务的音频，否则用小爱的TTS
+func (mt *MiBot) speak(text string) error {
+	if p := mt.Persona(); p != nil && p.Voice != "" {
+		return mt.Box.MiPlay(strings.ReplaceAll(p.Voice, "{text}", url.QueryEscape(text)))
+	}
+	return mt.Box.MiTTS(text)
+}
+
+// ------------------------------
+// 语音切换人设的技能
+// ------------------------------
+
+var (
+	personaSwitchWords  = []string{"切换到", "切换成", "切换为", "换成", "变成", "恢复成", "恢复", "回到"}
+	personaListWords    = []string{"有哪些人设", "有什么人设", "人设列表", "都有哪些人设"}
+	personaCurrentWords = []string{"现在是什么人设", "现在是哪个人设", "当前人设", "当前是什么人设"}
+)
+
+type personaSkill struct{}
+
+func init() {
+	RegisterSkill(&personaSkill{}, true) //没有配置人设时不处理
+}
+
+func (*personaSkill) Name() string { return "persona" }
+
+func (*personaSkill) Match(query string) float64 {
+	if len(personas) == 0 {
+		return 0
+	}
+	query = strings.TrimRight(query, "。.!！?？")
+	if containsAny(query, personaListWords...) || containsAny(query, personaCurrentWords...) {
+		return 0.9
+	}
+	// 只处理配置中有的人设(如“换成周杰伦的歌”不处理)
+	if name, ok := trimPrefixAny(query, personaSwitchWords); ok && (FindPersona(name) != nil || (name != "" && isDefaultPersona(name))) {
+		return 0.95
+	}
+	return 0
+}
+
+func (*personaSkill) Handle(ctx *SkillContext) error {
+	query := strings.TrimRight(ctx.Query, "。.!！?？")
+	if name, ok := trimPrefixAny(query, personaSwitchWords); ok {
+		p, err := ctx.Bot.SetPersona(name)
+		if err != nil {
+			return ctx.Say("没有" + personaName(name) + "这个人设")
+		}
+		if p == nil {
+			return ctx.Say("好的，已恢复默认人设")
+		}
+		return ctx.Say("好的，已切换到" + p.Name)
+	}
+	if containsAny(query, personaCurrentWords...) {
+		if p := ctx.Bot.Persona(); p != nil {
+			return ctx.Say("现在是" + p.Name)
+		}
+		return ctx.Say("现在是默认人设")
+	}
+	names := []string{}
+	for _, p := range personas {
+		names = append(names, p.Name)
+	}
+	if len(names) == 0 {
+		return ctx.Say("还没有配置人设")
+	}
+	return ctx.Say(fmt.Sprintf("有%d个人设：%s，可以说：切换到%s", len(names), strings.Join(names, "、"), names[0]))
+}
@@ -0,0 +1,304 @@
+package xiaobot
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"ninego/log"
+	"xiaobot/jsengine"
+)
+
+/*
+提问路由
+	配置 routes 为按顺序的规则表，在 query.bot 之后、Go技能之前用第一个匹配的规则决定如何回答：
+		xiaoai  由小爱回答(不静音、不问AI)
+		llm     问AI，profile 为 llm_profiles 中的AI配置(如换模型、换提示词)
+		script  执行任务脚本 script(请求参数 query、answer，脚本 res.send() 的文字被播放)，有风险的脚本先口头确认
+		skill   用Go技能 skill 处理(不管技能开关是否启用)
+	规则的条件都满足时匹配，没有条件的规则匹配所有提问：
+		regex     提问匹配的正则表达式
+		prefix    提问以其中之一开头
+		keywords  提问包含全部关键词
+		answer    小爱的回答匹配的正则表达式
+		unknown   小爱回答不知道(见 unknown_answers)
+	用到小爱回答的规则会等待小爱的完整回答。没有规则匹配时按原来的流程：Go技能、关键词开头问AI、小爱不知道时问AI。
+	网页 /api/route/test?query=xx&answer=xx 测试哪个规则匹配(POST 可带未保存的 routes)。
+	如：
+		"routes": [
+			{"name": "天气", "keywords": ["天气"], "action": "xiaoai"},
+			{"name": "编程", "prefix": ["写代码", "编程"], "action": "llm", "profile": "coder"},
+			{"name": "开灯", "regex": "^(打开|关闭).*灯$", "action": "script", "script": "light"},
+			{"name": "小爱不会", "unknown": true, "action": "llm"}
+		]
+*/
+
+// 路由规则的动作
+const (
+	RouteXiaoai = "xiaoai"
+	RouteLLM    = "llm"
+	RouteScript = "script"
+	RouteSkill  = "skill"
+)
+
+// RouteRule 路由规则
+type RouteRule struct {
+	Name     string   `json:"name,omitempty" toml:"name,omitempty"`
+	Regex    string   `json:"regex,omitempty" toml:"regex,omitempty"`       //提问匹配的正则表达式
+	Prefix   []string `json:"prefix,omitempty" toml:"prefix,omitempty"`     //提问以其中之一开头
+	Keywords []string `json:"keywords,omitempty" toml:"keywords,omitempty"` //提问包含全部关键词
+	Answer   string   `json:"answer,omitempty" toml:"answer,omitempty"`     //小爱的回答匹配的正则表达式
+	Unknown  bool     `json:"unknown,omitempty" toml:"unknown,omitempty"`   //小爱回答不知道
+	Action   string   `json:"action" toml:"action"`                         //xiaoai、llm、script、skill
+	Profile  string   `json:"profile,omitempty" toml:"profile,omitempty"`   //llm 的AI配置(llm_profiles)
+	Script   string   `json:"script,omitempty" toml:"script,omitempty"`     //script 的任务脚本名
+	Skill    string   `json:"skill,omitempty" toml:"skill,omitempty"`       //skill 的技能名
+	Disabled bool     `json:"disabled,omitempty" toml:"disabled,omitempty"`
+}
+
+// LLMProfile 路由规则 llm 动作的AI配置，为空的项同默认配置
+type LLMProfile struct {
+	Bot        string                 `json:"bot,omitempty" toml:"bot,omitempty"` //模型，可为“适配器=模型”
+	Key        string                 `json:"key,omitempty" toml:"key,omitempty"`
+	Backend    string                 `json:"backend,omitempty" toml:"backend,omitempty"`
+	Prompt     string                 `json:"prompt,omitempty" toml:"prompt,omitempty"`
+	GPTOptions map[string]interface{} `json:"gpt_options,omitempty" toml:"gpt_options,omitempty"`
+}
+
+// RouteResult 路由的结果，没有规则匹配时Index为-1
+type RouteResult struct {
+	Index  int        `json:"index"`
+	Rule   *RouteRule `json:"rule,omitempty"`
+	Action string     `json:"action"`
+	Reason string     `json:"reason"`
+}
+
+// 编译过的正则表达式最多缓存的个数，超过时清空(网页测试提交的规则也会编译)
+const maxRouteRegexps = 256
+
+var (
+	routeRegexpsMu sync.Mutex
+	routeRegexps   = map[string]interface{}{} //正则表达式 -> *regexp.Regexp 或 error
+)
+
+func routeRegexp(expr string) (*regexp.Regexp, error) {
+	routeRegexpsMu.Lock()
+	defer routeRegexpsMu.Unlock()
+	if v, ok := routeRegexps[expr]; ok {
+		if err, ok := v.(error); ok {
+			return nil, err
+		}
+		return v.(*regexp.Regexp), nil
+	}
+	if len(routeRegexps) >= maxRouteRegexps {
+		routeRegexps = map[string]interface{}{}
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		routeRegexps[expr] = err
+		return nil, err
+	}
+	routeRegexps[expr] = re
+	return re, nil
+}
+
+func (r *RouteRule) title(index int) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return fmt.Sprintf("#%d", index+1)
+}
+
+// 规则是否匹配，返回满足的条件；answer在用到时才调用(可能要等待小爱的完整回答)
+func (r *RouteRule) match(query string, answer func() string) (bool, []string) {
+	var reasons []string
+	if r.Regex != "" {
+		re, err := routeRegexp(r.Regex)
+		if err != nil || !re.MatchString(query) {
+			return false, nil
+		}
+		reasons = append(reasons, "提问匹配 "+r.Regex)
+	}
+	if len(r.Prefix) > 0 {
+		prefix := ""
+		for _, p := range r.Prefix {
+			if p != "" && strings.HasPrefix(query, p) {
+				prefix = p
+				break
+			}
+		}
+		if prefix == "" {
+			return false, nil
+		}
+		reasons = append(reasons, "提问以 "+prefix+" 开头")
+	}
+	if len(r.Keywords) > 0 {
+		for _, k := range r.Keywords {
+			if !strings.Contains(query, k) {
+				return false, nil
+			}
+		}
+		reasons = append(reasons, "提问包含 "+strings.Join(r.Keywords, "、"))
+	}
+	if r.Answer != "" {
+		re, err := routeRegexp(r.Answer)
+		if err != nil || !re.MatchString(answer()) {
+			return false, nil
+		}
+		reasons = append(reasons, "小爱回答匹配 "+r.Answer)
+	}
+	if r.Unknown {
+		if !needStop(answer()) {
+			return false, nil
+		}
+		reasons = append(reasons, "小爱回答不知道")
+	}
+	if len(reasons) == 0 {
+		reasons = append(reasons, "没有条件(匹配所有提问)")
+	}
+	return true, reasons
+}
+
+func matchRoute(rules []RouteRule, query string, answer func() string) *RouteResult {
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Disabled || routeActionError(rule) != "" {
+			continue
+		}
+		if ok, reasons := rule.match(query, answer); ok {
+			return &RouteResult{Index: i, Rule: rule, Action: rule.Action, Reason: "规则 " + rule.title(i) + ": " + strings.Join(reasons, "，")}
+		}
+	}
+	return &RouteResult{Index: -1, Reason: "没有匹配的规则"}
+}
+
+// MatchRoute 第一个匹配提问和小爱回答的规则(Index为-1时没有匹配)
+func MatchRoute(rules []RouteRule, query, answer string) *RouteResult {
+	return matchRoute(rules, query, func() string { return answer })
+}
+
+// DefaultRoute 没有规则匹配时原来流程的处理(不含连续对话和监控模式)
+func DefaultRoute(c *Config, query, answer string) *RouteResult {
+	result := &RouteResult{Index: -1}
+	switch skill, score := Skills.Match(query, c.Skills); {
+	case skill != nil:
+		result.Action, result.Reason = RouteSkill, fmt.Sprintf("技能 %s 匹配(%.2f)", skill.Name(), score)
+	case queryIn(query, c.Keywords):
+		result.Action, result.Reason = RouteLLM, "提问以关键词 "+strings.Join(c.Keywords, "/")+" 开头"
+	case needStop(answer):
+		result.Action, result.Reason = RouteLLM, "小爱回答不知道"
+	default:
+		result.Action, result.Reason = RouteXiaoai, "小爱的回答有效"
+	}
+	return result
+}
+
+func routeActionError(r *RouteRule) string {
+	switch r.Action {
+	case RouteXiaoai, RouteLLM:
+	case RouteScript:
+		if r.Script == "" {
+			return "script 动作没有设置 script"
+		}
+	case RouteSkill:
+		if r.Skill == "" {
+			return "skill 动作没有设置 skill"
+		}
+	default:
+		return "未知的动作: " + r.Action
+	}
+	return ""
+}
+
+// RouteErrors 检查路由规则：正则表达式、动作和引用的AI配置、脚本、技能
+func RouteErrors(c *Config, rules []RouteRule) []string {
+	var errs []string
+	for i := range rules {
+		r := &rules[i]
+		add := func(msg string) { errs = append(errs, "规则 "+r.title(i)+": "+msg) }
+		for _, expr := range []string{r.Regex, r.Answer} {
+			if expr == "" {
+				continue
+			}
+			if _, err := routeRegexp(expr); err != nil {
+				add("正则表达式出错: " + err.Error())
+			}
+		}
+		if msg := routeActionError(r); msg != "" {
+			add(msg)
+			continue
+		}
+		switch r.Action {
+		case RouteLLM:
+			if r.Profile != "" && c.LLMProfiles[r.Profile] == nil {
+				add("没有AI配置: " + r.Profile)
+			}
+		case RouteScript:
+			if _, ok := c.TaskJS[r.Script]; !ok && c.TaskJS != nil {
+				add("没有任务脚本: " + r.Script)
+			}
+		case RouteSkill:
+			if Skills.Get(r.Skill) == nil {
+				add("没有技能: " + r.Skill)
+			}
+		}
+	}
+	return errs
+}
+
+// route 按路由规则决定如何回答，没有匹配时返回nil；规则用到小爱的回答时等待完整回答，
+// mute为true(静音小爱模式、连续对话中)时先静音再等待，等待期间不播放小爱的回答
+func (mt *MiTalk) route(query string, answer *string, mute bool) *RouteResult {
+	if len(mt.config.Routes) == 0 {
+		return nil
+	}
+	waited := false
+	result := matchRoute(mt.config.Routes, query, func() string {
+		if !waited {
+			waited = true
+			if mute && *answer == "" {
+				mt.Bot.startSpeakerMuteLoop()
+			}
+			if err := mt.waitForCompleteAnswer(query, answer); err != nil {
+				log.Error("等待完整回答失败:", err)
+			}
+		}
+		return *answer
+	})
+	if result.Rule == nil {
+		return nil
+	}
+	log.Printf("路由 %s -> %s\n", result.Reason, result.Action)
+	return result
+}
+
+// scriptSkill 路由规则的 script 动作：执行任务脚本，有风险的脚本先口头确认
+type scriptSkill struct {
+	script string
+	answer string //小爱的回答
+}
+
+func (s *scriptSkill) Name() string { return "script." + s.script }
+
+func (s *scriptSkill) Match(query string) float64 { return 1 }
+
+func (s *scriptSkill) Handle(ctx *SkillContext) error {
+	source, ok := ctx.Config.TaskJS[s.script]
+	if !ok {
+		return fmt.Errorf("没有任务脚本: %s", s.script)
+	}
+	params := map[string]interface{}{"query": ctx.Query, "answer": s.answer}
+	if risk := jsengine.ScriptRisk(source, filepath.Join(GetExecutableDir(), s.script+".bot")); risk != "" {
+		return ctx.StartDialog(&DialogSpec{
+			Name:    s.Name(),
+			Risky:   true,
+			Confirm: fmt.Sprintf("%s会%s，确定要执行吗？", s.script, risk),
+			Done: func(ctx *SkillContext, slots map[string]interface{}) error {
+				return runTaskScript(ctx, s.script, params)
+			},
+		}, nil)
+	}
+	return runTaskScript(ctx, s.script, params)
+}
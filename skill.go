@@ -0,0 +1,269 @@
+package xiaobot
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"ninego/log"
+	"xiaobot/jarvis"
+	"xiaobot/jsengine"
+)
+
+/*
+Go技能
+	在 query.bot 之后、问AI之前，用问题匹配已注册且启用的技能，得分最高的技能(不低于 SkillMinScore)处理问题，处理后不再问AI。
+	内置技能：time(时间日期农历)、volume(音量)、reminder(提醒)、memory(长期记忆，见memory.go)、persona(切换人设，见persona.go，默认启用)、music(本地音乐，在music包中注册)，
+	配置 "skills": {"time": true, "music": false} 开关，内置技能默认不启用。
+	自己的技能实现 Skill 接口，在 init() 中调用 xiaobot.RegisterSkill(mySkill{}, true) 并在 cmd 中 import 即可。
+*/
+
+// SkillMinScore 技能处理问题的最低得分
+const SkillMinScore = 0.5
+
+// ErrSkipSkill 技能的Handle返回此错误时放弃处理，问题按原来的流程(问AI)处理
+var ErrSkipSkill = errors.New("技能不处理")
+
+// Skill Go实现的技能
+type Skill interface {
+	Name() string                   //名称(配置 skills 中的开关名)
+	Match(query string) float64     //问题的得分：0=不处理，1=一定处理
+	Handle(ctx *SkillContext) error //处理问题，应尽快返回(耗时的操作放到goroutine中)
+}
+
+// SkillStarter 技能实现此接口时，机器人启动后调用Start(如恢复未到时间的提醒)
+type SkillStarter interface {
+	Start(bot *MiBot)
+}
+
+type skillEntry struct {
+	skill   Skill
+	enabled bool //配置中没有设置时是否启用
+}
+
+type skillRegistry struct {
+	mu   sync.RWMutex
+	list []*skillEntry
+}
+
+// Skills 已注册的技能
+var Skills = &skillRegistry{}
+
+// RegisterSkill 注册技能，同名的技能会被替换；enabled 为配置中没有设置开关时是否启用
+func RegisterSkill(s Skill, enabled bool) {
+	Skills.mu.Lock()
+	defer Skills.mu.Unlock()
+	for i, e := range Skills.list {
+		if e.skill.Name() == s.Name() {
+			Skills.list[i] = &skillEntry{skill: s, enabled: enabled}
+			return
+		}
+	}
+	Skills.list = append(Skills.list, &skillEntry{skill: s, enabled: enabled})
+}
+
+// SkillInfo 技能的名称和是否启用
+type SkillInfo struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+}
+
+func (e *skillEntry) isEnabled(toggles map[string]bool) bool {
+	if on, ok := toggles[e.skill.Name()]; ok {
+		return on
+	}
+	return e.enabled
+}
+
+// List 技能列表(按名称排序)，toggles 为配置中的开关
+func (r *skillRegistry) List(toggles map[string]bool) []SkillInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := make([]SkillInfo, 0, len(r.list))
+	for _, e := range r.list {
+		list = append(list, SkillInfo{Name: e.skill.Name(), Enabled: e.isEnabled(toggles)})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Get 按名称查找技能
+func (r *skillRegistry) Get(name string) Skill {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, e := range r.list {
+		if e.skill.Name() == name {
+			return e.skill
+		}
+	}
+	return nil
+}
+
+// Match 启用的技能中得分最高的(得分相同时先注册的优先)，没有时返回nil
+func (r *skillRegistry) Match(query string, toggles map[string]bool) (Skill, float64) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var best Skill
+	bestScore := 0.0
+	for _, e := range r.list {
+		if !e.isEnabled(toggles) {
+			continue
+		}
+		score := safeSkillMatch(e.skill, query)
+		if score >= SkillMinScore && score > bestScore {
+			best, bestScore = e.skill, score
+		}
+	}
+	return best, bestScore
+}
+
+// 启动实现了SkillStarter的启用的技能
+func (r *skillRegistry) start(bot *MiBot) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, e := range r.list {
+		if starter, ok := e.skill.(SkillStarter); ok && e.isEnabled(bot.config.Skills) {
+			starter.Start(bot)
+		}
+	}
+}
+
+func safeSkillMatch(s Skill, query string) (score float64) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("技能", s.Name(), "匹配出错:", r)
+			score = 0
+		}
+	}()
+	return s.Match(query)
+}
+
+// SkillContext 技能处理问题时可用的音箱、对话历史和存储
+type SkillContext struct {
+	Query  string
+	Score  float64
+	Config *Config
+	Bot    *MiBot
+
+	talk    *MiTalk
+	skill   string
+	replies []string
+	asked   bool //多轮对话已追问(已唤醒音箱)
+}
+
+// Say 播放回答(chat不发音时只记录回答)；连续对话时等待播放完
+func (c *SkillContext) Say(text string) error {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+	c.replies = append(c.replies, text)
+	log.Printf("-技能%s-的回答: %s\n", c.skill, text)
+	return c.talk.miTTS(text, c.talk.InConversation)
+}
+
+// Play 播放音频url
+func (c *SkillContext) Play(url string) error {
+	if c.talk.ChatDontTTS == 1 {
+		return nil
+	}
+	return c.talk.miPlay(url, false)
+}
+
+// Volume 音箱的音量
+func (c *SkillContext) Volume() int {
+	return c.Bot.Box.MiGetVolume()
+}
+
+// SetVolume 设置音箱的音量(0~100)
+func (c *SkillContext) SetVolume(value int) error {
+	return c.Bot.Box.MiSetVolume(value)
+}
+
+// InConversation 是否为连续对话
+func (c *SkillContext) InConversation() bool {
+	return c.talk.InConversation
+}
+
+// History AI对话历史的副本(没有配置AI时为空)
+func (c *SkillContext) History() []jarvis.RoleContent {
+	if c.Bot.assistant == nil {
+		return nil
+	}
+	history := c.Bot.assistant.GetHistory()
+	return append([]jarvis.RoleContent(nil), (*history)...)
+}
+
+// Storage 技能的数据存储(bot.storage 中的 skill.<技能名> 命名空间，脚本可用 bot.store('skill.xxx') 读写)
+func (c *SkillContext) Storage() *jsengine.SharedData {
+	return SkillStorage(c.skill)
+}
+
+// Answer 技能已播放的回答
+func (c *SkillContext) Answer() string {
+	return strings.Join(c.replies, "")
+}
+
+// SkillStorage 技能的数据存储
+func SkillStorage(name string) *jsengine.SharedData {
+	return jsengine.ScriptStorage().Namespace("skill." + name)
+}
+
+// handleSkill 有匹配的技能时由技能处理问题，返回是否有匹配的技能
+func (mt *MiTalk) handleSkill(query string, fallback func()) bool {
+	skill, score := Skills.Match(query, mt.config.Skills)
+	if skill == nil {
+		return false
+	}
+	mt.runSkill(skill, query, score, fallback)
+	return true
+}
+
+// runSkill 用指定的技能在后台处理问题，技能出错且没有回答时调用fallback(改由AI回答)
+func (mt *MiTalk) runSkill(skill Skill, query string, score float64, fallback func()) {
+	log.Printf("技能 %s 处理问题(%.2f): %s\n", skill.Name(), score, query)
+	ctx := &SkillContext{Query: query, Score: score, Config: mt.config, Bot: mt.Bot, talk: mt, skill: skill.Name()}
+	mt.Bot.startSpeakerMuteLoop()
+	// 同问AI一样在后台处理，新的提问结束本次对话(mt.Terminate)后不再播放
+	go func() {
+		err := func() (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Error("技能", skill.Name(), "处理出错:", r)
+					err = errors.New("技能处理出错")
+				}
+			}()
+			return skill.Handle(ctx)
+		}()
+		if err != nil {
+			if !errors.Is(err, ErrSkipSkill) {
+				log.Error("技能", skill.Name(), "处理出错:", err)
+			}
+			if len(ctx.replies) == 0 {
+				mt.Bot.activeSpeakerVoice() //交回原来的流程决定是否静音
+				if !mt.terminated() {
+					fallback()
+				}
+				return
+			}
+		}
+		mt.finishSkill(ctx)
+	}()
+}
+
+// finishSkill 技能或多轮对话处理完提问：记录回答(chat用)，连续对话时记录到对话历史并唤醒音箱
+func (mt *MiTalk) finishSkill(ctx *SkillContext) {
+	answer = ctx.Answer()
+	if mt.InConversation && mt.Bot.assistant != nil {
+		history := mt.Bot.assistant.GetHistory()
+		*history = append(*history,
+			jarvis.RoleContent{Role: "user", Content: ctx.Query},
+			jarvis.RoleContent{Role: "assistant", Content: answer},
+		)
+		if !ctx.asked && !mt.terminated() && mt.ChatDontTTS == 0 {
+			mt.Box.WakeUp()
+		}
+	}
+	mt.Terminate()
+}
@@ -0,0 +1,500 @@
+package xiaobot
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ninego/log"
+	"xiaobot/gcron"
+)
+
+// 内置技能(默认不启用，配置 skills 中打开)
+func init() {
+	RegisterSkill(timeSkill{}, false)
+	RegisterSkill(volumeSkill{}, false)
+	RegisterSkill(&reminderSkill{timers: make(map[string]*time.Timer)}, false)
+}
+
+var numberRegex = regexp.MustCompile(`[0-9]+|[零〇一二两三四五六七八九十百千]+`)
+
+// parseNumber 阿拉伯数字或中文数字(如 15、十五、两百)
+func parseNumber(s string) (int, bool) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n, true
+	}
+	digits := map[rune]int{'零': 0, '〇': 0, '一': 1, '二': 2, '两': 2, '三': 3, '四': 4, '五': 5, '六': 6, '七': 7, '八': 8, '九': 9}
+	units := map[rune]int{'十': 10, '百': 100, '千': 1000}
+	total, digit, found := 0, -1, false
+	for _, r := range s {
+		if d, ok := digits[r]; ok {
+			digit, found = d, true
+		} else if u, ok := units[r]; ok {
+			if digit < 0 {
+				digit = 1 //十五 = 一十五
+			}
+			total += digit * u
+			digit, found = -1, true
+		} else {
+			return 0, false
+		}
+	}
+	if digit > 0 {
+		total += digit
+	}
+	return total, found
+}
+
+// 问题中第一个数字
+func firstNumber(query string) (int, bool) {
+	if s := numberRegex.FindString(query); s != "" {
+		return parseNumber(s)
+	}
+	return 0, false
+}
+
+func containsAny(s string, words ...string) bool {
+	for _, w := range words {
+		if strings.Contains(s, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// 问题中的日期(今天/明天/后天/大后天/昨天/前天)
+func dayOffset(query string) (int, string) {
+	for _, d := range []struct {
+		word   string
+		offset int
+	}{{"大后天", 3}, {"后天", 2}, {"明天", 1}, {"前天", -2}, {"昨天", -1}, {"今天", 0}} {
+		if strings.Contains(query, d.word) {
+			return d.offset, d.word
+		}
+	}
+	return 0, "今天"
+}
+
+var weekdayNames = []string{"星期日", "星期一", "星期二", "星期三", "星期四", "星期五", "星期六"}
+
+// 时段的说法：凌晨、早上、上午、中午、下午、晚上
+func periodOfDay(hour int) string {
+	switch {
+	case hour < 5:
+		return "凌晨"
+	case hour < 8:
+		return "早上"
+	case hour < 11:
+		return "上午"
+	case hour < 13:
+		return "中午"
+	case hour < 18:
+		return "下午"
+	default:
+		return "晚上"
+	}
+}
+
+// 口语的时间，如 下午3点05分
+func spokenClock(t time.Time) string {
+	hour := t.Hour()
+	text := periodOfDay(hour)
+	if hour > 12 {
+		hour -= 12
+	}
+	if t.Minute() == 0 {
+		return fmt.Sprintf("%s%d点", text, hour)
+	}
+	return fmt.Sprintf("%s%d点%02d分", text, hour, t.Minute())
+}
+
+// 农历日期，如 农历九月廿九
+func lunarText(t time.Time) string {
+	lunar := gcron.SolarToLunar(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local))
+	month := gcron.LunarMonthStr(lunar.Month) + "月"
+	if lunar.IsLeap {
+		month = "闰" + month
+	}
+	return "农历" + month + gcron.LunarDayStr(lunar.Day)
+}
+
+// 节假日名称
+func holidayName(t time.Time) string {
+	date := t.Format("2006-01-02")
+	for _, h := range gcron.HolidayList(t.Year()) {
+		if h.Date == date {
+			return h.Name
+		}
+	}
+	return ""
+}
+
+// timeSkill 时间、日期、星期、农历、节气、是否上班
+type timeSkill struct{}
+
+func (timeSkill) Name() string { return "time" }
+
+func (timeSkill) Match(query string) float64 {
+	if containsAny(query, "提醒", "闹钟", "倒计时") {
+		return 0
+	}
+	switch {
+	case containsAny(query, "几点了", "现在几点", "几点钟", "现在时间", "什么时间了"):
+		return 0.9
+	case containsAny(query, "星期几", "周几", "礼拜几", "几号", "几月几", "什么日子", "日期"):
+		return 0.9
+	case containsAny(query, "农历", "阴历") && containsAny(query, "今天", "明天", "后天", "昨天", "前天", "几", "什么", "多少"):
+		return 0.9
+	case strings.Contains(query, "节气"):
+		return 0.8
+	case containsAny(query, "上班", "放假", "休息", "工作日") && containsAny(query, "今天", "明天", "后天", "大后天"):
+		return 0.8
+	}
+	return 0
+}
+
+func (timeSkill) Handle(ctx *SkillContext) error {
+	query := ctx.Query
+	now := time.Now()
+	offset, word := dayOffset(query)
+	day := now.AddDate(0, 0, offset)
+
+	switch {
+	case containsAny(query, "几点了", "现在几点", "几点钟", "现在时间", "什么时间了"):
+		return ctx.Say("现在是" + spokenClock(now))
+	case strings.Contains(query, "节气"):
+		if term := gcron.GetSolarTermByDate(day); term != "" {
+			return ctx.Say(word + "是" + term)
+		}
+		for i := 1; i <= 16; i++ {
+			next := day.AddDate(0, 0, i)
+			if term := gcron.GetSolarTermByDate(next); term != "" {
+				return ctx.Say(fmt.Sprintf("%s不是节气，下一个节气是%d月%d日%s，还有%d天", word, next.Month(), next.Day(), term, i))
+			}
+		}
+		return ctx.Say(word + "不是节气")
+	case containsAny(query, "上班", "放假", "休息", "工作日") && !containsAny(query, "几号", "星期", "周几", "农历", "阴历"):
+		text := word + "是"
+		if gcron.IsWorkday(day) {
+			text += "工作日，要上班"
+		} else {
+			text += "休息日，不用上班"
+		}
+		if name := holidayName(day); name != "" {
+			text += "，" + name
+		}
+		return ctx.Say(text)
+	case containsAny(query, "农历", "阴历") && !containsAny(query, "几号", "星期", "周几"):
+		return ctx.Say(word + "是" + lunarText(day))
+	}
+
+	text := fmt.Sprintf("%s是%d月%d日，%s，%s", word, day.Month(), day.Day(), weekdayNames[day.Weekday()], lunarText(day))
+	if name := holidayName(day); name != "" {
+		text += "，" + name
+	}
+	if term := gcron.GetSolarTermByDate(day); term != "" {
+		text += "，" + term
+	}
+	return ctx.Say(text)
+}
+
+// volumeSkill 查询和调节音量
+type volumeSkill struct{}
+
+func (volumeSkill) Name() string { return "volume" }
+
+func (volumeSkill) Match(query string) float64 {
+	switch {
+	case containsAny(query, "大声点", "大点声", "小声点", "小点声", "声音大一点", "声音小一点"):
+		return 0.9
+	case containsAny(query, "音量", "声音") && containsAny(query, "调", "设", "大", "小", "高", "低", "多少", "几"):
+		return 0.9
+	}
+	return 0
+}
+
+func (volumeSkill) Handle(ctx *SkillContext) error {
+	query := strings.ReplaceAll(ctx.Query, "百分之", "")
+	current := ctx.Volume()
+	volume := current
+	if n, ok := firstNumber(query); ok {
+		volume = n
+	} else {
+		switch {
+		case containsAny(query, "最大", "最高"):
+			volume = 100
+		case containsAny(query, "最小", "最低"):
+			volume = 5
+		case containsAny(query, "大", "高"):
+			volume = current + 10
+		case containsAny(query, "小", "低"):
+			volume = current - 10
+		default: //查询音量
+			return ctx.Say(fmt.Sprintf("当前音量是%d", current))
+		}
+	}
+	volume = max(0, min(100, volume))
+	if err := ctx.SetVolume(volume); err != nil {
+		return err
+	}
+	return ctx.Say(fmt.Sprintf("音量已调到%d", volume))
+}
+
+// reminderSkill 提醒：十分钟后提醒我关火、明天早上8点提醒我开会、我有什么提醒、取消提醒
+//
+//	提醒保存在 skill.reminder 存储中，重启后恢复
+type reminderSkill struct {
+	mu     sync.Mutex
+	bot    *MiBot
+	timers map[string]*time.Timer
+}
+
+type reminder struct {
+	Time int64  `json:"time"` //提醒时间(Unix毫秒)
+	Text string `json:"text"`
+}
+
+func (*reminderSkill) Name() string { return "reminder" }
+
+func (*reminderSkill) Match(query string) float64 {
+	switch {
+	case strings.Contains(query, "提醒我"):
+		return 0.95
+	case containsAny(query, "取消提醒", "取消所有提醒", "删除提醒", "什么提醒", "哪些提醒", "查看提醒", "几个提醒"):
+		return 0.9
+	}
+	return 0
+}
+
+var (
+	relativeTimeRegex = regexp.MustCompile(`([0-9]+|[零〇一二两三四五六七八九十百千]+|半)个?(半)?(秒钟|秒|分钟|分|小时|钟头)[以之]?后`)
+	clockTimeRegex    = regexp.MustCompile(`(凌晨|早上|早晨|上午|中午|下午|傍晚|晚上)?([0-9]+|[零〇一二两三四五六七八九十]+)[点:：]((?:[0-9]+|[零〇一二两三四五六七八九十]+)分?|半|一刻|三刻)?`)
+)
+
+// parseReminderTime 提醒的时间，不能识别时返回零值
+func parseReminderTime(query string, now time.Time) time.Time {
+	if m := relativeTimeRegex.FindStringSubmatch(query); m != nil {
+		var n float64
+		if m[1] == "半" {
+			n = 0.5
+		} else if v, ok := parseNumber(m[1]); ok {
+			n = float64(v)
+		}
+		if m[2] == "半" {
+			n += 0.5
+		}
+		unit := time.Minute
+		switch m[3] {
+		case "秒", "秒钟":
+			unit = time.Second
+		case "小时", "钟头":
+			unit = time.Hour
+		}
+		if n > 0 {
+			return now.Add(time.Duration(n * float64(unit)))
+		}
+	}
+	m := clockTimeRegex.FindStringSubmatch(query)
+	if m == nil {
+		return time.Time{}
+	}
+	hour, ok := parseNumber(m[2])
+	if !ok || hour > 24 {
+		return time.Time{}
+	}
+	minute := 0
+	switch m[3] {
+	case "":
+	case "半":
+		minute = 30
+	case "一刻":
+		minute = 15
+	case "三刻":
+		minute = 45
+	default:
+		minute, _ = parseNumber(strings.TrimSuffix(m[3], "分"))
+	}
+	switch m[1] {
+	case "下午", "傍晚", "晚上":
+		if hour < 12 {
+			hour += 12
+		}
+	case "中午":
+		if hour < 6 {
+			hour += 12
+		}
+	}
+	offset, _ := dayOffset(query)
+	t := time.Date(now.Year(), now.Month(), now.Day()+offset, hour, minute, 0, 0, now.Location())
+	if !t.After(now) {
+		if offset == 0 && m[1] == "" && hour < 12 && t.Add(12*time.Hour).After(now) {
+			t = t.Add(12 * time.Hour) //没说上午下午时取最近的时间
+		} else if offset == 0 {
+			t = t.AddDate(0, 0, 1)
+		}
+	}
+	return t
+}
+
+// 提醒的内容：“提醒我”之后的文字
+func reminderText(query string) string {
+	i := strings.Index(query, "提醒我")
+	if i < 0 {
+		return ""
+	}
+	text := query[i+len("提醒我"):]
+	// 去掉“提醒我”之后说的时间，如 提醒我明天8点开会
+	text = relativeTimeRegex.ReplaceAllString(text, "")
+	text = clockTimeRegex.ReplaceAllString(text, "")
+	for _, word := range []string{"大后天", "后天", "明天", "今天"} {
+		text = strings.Replace(text, word, "", 1)
+	}
+	text = strings.Trim(text, " ,，。.!！?？")
+	return strings.TrimPrefix(text, "要")
+}
+
+// 口语的提醒时间，如 明天早上8点
+func spokenReminderTime(t, now time.Time) string {
+	days := int(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()).Sub(
+		time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())).Hours() / 24)
+	day := fmt.Sprintf("%d月%d日", t.Month(), t.Day())
+	switch days {
+	case 0:
+		day = "今天"
+	case 1:
+		day = "明天"
+	case 2:
+		day = "后天"
+	}
+	text := day + spokenClock(t)
+	if t.Second() != 0 {
+		text += fmt.Sprintf("%d秒", t.Second())
+	}
+	return text
+}
+
+func (r *reminderSkill) Handle(ctx *SkillContext) error {
+	r.mu.Lock()
+	r.bot = ctx.Bot
+	r.mu.Unlock()
+	query := ctx.Query
+	store := ctx.Storage()
+	now := time.Now()
+
+	if !strings.Contains(query, "提醒我") {
+		if containsAny(query, "取消", "删除") {
+			keys := store.Keys()
+			for _, key := range keys {
+				r.cancel(key)
+				store.Delete(key)
+			}
+			if len(keys) == 0 {
+				return ctx.Say("你没有提醒")
+			}
+			return ctx.Say(fmt.Sprintf("已取消%d个提醒", len(keys)))
+		}
+		list := r.list()
+		if len(list) == 0 {
+			return ctx.Say("你没有提醒")
+		}
+		items := make([]string, 0, len(list))
+		for _, rem := range list {
+			items = append(items, spokenReminderTime(time.UnixMilli(rem.Time), now)+rem.Text)
+		}
+		return ctx.Say(fmt.Sprintf("你有%d个提醒：%s", len(list), strings.Join(items, "；")))
+	}
+
+	at := parseReminderTime(query, now)
+	if at.IsZero() {
+		return ctx.Say("没听清提醒的时间，可以说：十分钟后提醒我关火")
+	}
+	text := reminderText(query)
+	rem := &reminder{Time: at.UnixMilli(), Text: text}
+	key := strconv.FormatInt(now.UnixNano(), 36)
+	store.SetTTL(key, rem, at.Sub(now)+time.Hour) //过期前没有提醒(程序未运行)的丢弃
+	r.schedule(key, rem)
+	if text == "" {
+		return ctx.Say("好的，" + spokenReminderTime(at, now) + "提醒你")
+	}
+	return ctx.Say("好的，" + spokenReminderTime(at, now) + "提醒你" + text)
+}
+
+// Start 恢复未到时间的提醒
+func (r *reminderSkill) Start(bot *MiBot) {
+	r.mu.Lock()
+	r.bot = bot
+	r.mu.Unlock()
+	for _, rem := range r.list() {
+		r.schedule(rem.key, &rem.reminder)
+	}
+}
+
+type storedReminder struct {
+	reminder
+	key string
+}
+
+// 保存的提醒(按时间排序)
+func (r *reminderSkill) list() []storedReminder {
+	store := SkillStorage(r.Name())
+	list := []storedReminder{}
+	for _, key := range store.Keys() {
+		if rem := reminderFrom(store.Get(key)); rem != nil {
+			list = append(list, storedReminder{reminder: *rem, key: key})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Time < list[j].Time })
+	return list
+}
+
+// 存储中的提醒：本次运行保存的为*reminder，从文件读取的为map
+func reminderFrom(v interface{}) *reminder {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	rem := &reminder{}
+	if json.Unmarshal(data, rem) != nil || rem.Time == 0 {
+		return nil
+	}
+	return rem
+}
+
+func (r *reminderSkill) schedule(key string, rem *reminder) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if t := r.timers[key]; t != nil {
+		t.Stop()
+	}
+	r.timers[key] = time.AfterFunc(time.Until(time.UnixMilli(rem.Time)), func() {
+		r.mu.Lock()
+		delete(r.timers, key)
+		bot := r.bot
+		r.mu.Unlock()
+		SkillStorage(r.Name()).Delete(key)
+		text := "提醒你：" + rem.Text
+		if rem.Text == "" {
+			text = "提醒时间到了"
+		}
+		log.Println("提醒:", text)
+		if bot != nil {
+			if err := bot.Box.MiTTS(text); err != nil {
+				log.Error("播放提醒出错:", err)
+			}
+		}
+	})
+}
+
+func (r *reminderSkill) cancel(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if t := r.timers[key]; t != nil {
+		t.Stop()
+		delete(r.timers, key)
+	}
+}
@@ -9,6 +9,8 @@ import (
 
 	"ninego/log"
 	"xiaobot"
+	"xiaobot/gcron"
+	"xiaobot/jsengine"
 	"xiaobot/miservice"
 )
 
@@ -49,6 +51,14 @@ func do_setConfig(writer http.ResponseWriter, request *http.Request) {
 	// 动态更新Music目录
 	MusicFS.UpdateHandler(config.MusicPath)
 	log.Debug("Music folder:", config.MusicPath)
+	// 更新日出日落计算的位置
+	gcron.SetHomeLocation(config.Latitude, config.Longitude)
+	gcron.NetworkHolidays.Online = config.HolidayOnline
+	if config.City != "" {
+		jsengine.SetPromptVar("city", config.City)
+	}
+	jsengine.SetTimeouts(config.ScriptTimeout)
+	jsengine.SetVersionRetention(config.ScriptHistory)
 
 	writer.Header().Set("Content-Type", "application/json")
 	writer.WriteHeader(http.StatusOK)
@@ -0,0 +1,82 @@
+package webui
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ninego/log"
+	"xiaobot/gcron"
+	"xiaobot/jsengine"
+)
+
+// 节假日列表：指定年份合并后的节假日/调休日期，以及数据来源和自定义日期
+func do_holidayList(writer http.ResponseWriter, request *http.Request) {
+	year, err := strconv.Atoi(request.URL.Query().Get("year"))
+	if err != nil {
+		year = time.Now().Year()
+	}
+	var sources []string
+	for _, p := range gcron.HolidayProviders() {
+		sources = append(sources, p.Name())
+	}
+	rest := struct {
+		Year      int                     `json:"year"`
+		Days      []gcron.Holiday         `json:"days"`
+		Sources   []string                `json:"sources"`
+		Online    bool                    `json:"online"`
+		Overrides []gcron.HolidayOverride `json:"overrides"`
+	}{
+		Year:      year,
+		Days:      gcron.HolidayList(year),
+		Sources:   sources,
+		Online:    gcron.NetworkHolidays.Online,
+		Overrides: gcron.OverrideHolidays.List(),
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	json.NewEncoder(writer).Encode(rest)
+}
+
+// 保存自定义日期(额外假日/调休上班/个人请假)
+func do_holidayOverrides(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost || request.Body == nil {
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusOK)
+		json.NewEncoder(writer).Encode(gcron.OverrideHolidays.List())
+		return
+	}
+	var list []gcron.HolidayOverride
+	if err := json.NewDecoder(request.Body).Decode(&list); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := gcron.OverrideHolidays.Save(list); err != nil {
+		log.Error("保存自定义节假日失败:", err)
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	jsengine.Schedules.Refresh()
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write([]byte(`{"msg":"","success":1}`))
+}
+
+// 从网络刷新指定年份的节假日数据
+func do_holidayRefresh(writer http.ResponseWriter, request *http.Request) {
+	year, err := strconv.Atoi(request.URL.Query().Get("year"))
+	if err != nil {
+		year = time.Now().Year()
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	if err := gcron.RefreshHolidays(year); err != nil {
+		log.Error("刷新节假日数据失败:", err)
+		writer.Write([]byte(fmt.Sprintf(`{"msg":%q,"success":0}`, err.Error())))
+		return
+	}
+	jsengine.Schedules.Refresh()
+	writer.Write([]byte(`{"msg":"","success":1}`))
+}
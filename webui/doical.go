@@ -0,0 +1,136 @@
+package webui
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"ninego/log"
+	"xiaobot/gcron"
+	"xiaobot/jsengine"
+)
+
+// 定时任务日历(可在手机/电脑日历中订阅)
+// GET /gcron/calendar.ics?all=1  all=1时包括未启用的任务
+func do_gcronCalendar(writer http.ResponseWriter, request *http.Request) {
+	all := request.URL.Query().Get("all") == "1"
+	var jobs []*gcron.CronJob
+	for _, job := range jsengine.Schedules.List() {
+		if all || job.IsActive {
+			jobs = append(jobs, job)
+		}
+	}
+	writer.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	writer.Header().Set("Content-Disposition", `inline; filename="xiaobot.ics"`)
+	writer.WriteHeader(http.StatusOK)
+	if err := gcron.ExportICS(writer, jobs); err != nil {
+		log.Error("导出日历失败:", err)
+	}
+}
+
+// 导入iCalendar日历为定时任务
+// POST /gcron/import?action=tts|script&script=任务名&time=08:00
+// 日历内容为上传的文件(表单字段file)或请求体；GET/POST ?path=xxx.ics 导入程序目录下的.ics文件(只能是文件名)
+// action=tts: 到时朗读事件名称；action=script: 到时执行指定的.bot脚本(引用脚本，修改脚本后生效)
+// 已导入过的事件(UID相同)更新原来的任务
+func do_gcronImport(writer http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	var reader io.Reader
+	if path := query.Get("path"); path != "" {
+		if path != filepath.Base(path) || !strings.HasSuffix(strings.ToLower(path), ".ics") {
+			http.Error(writer, "只能导入程序目录下的.ics文件", http.StatusBadRequest)
+			return
+		}
+		file, err := os.Open(filepath.Join(jsengine.GetExecutableDir(), path))
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		reader = file
+	} else if request.Method != http.MethodPost || request.Body == nil {
+		http.Error(writer, "请上传日历文件", http.StatusBadRequest)
+		return
+	} else if strings.HasPrefix(request.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := request.FormFile("file")
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		reader = file
+	} else {
+		reader = request.Body
+	}
+
+	// 执行内容
+	action := query.Get("action")
+	botScript := ""
+	if action == "script" {
+		botScript = query.Get("script")
+		if _, ok := config.TaskJS[botScript]; !ok {
+			http.Error(writer, fmt.Sprintf("脚本'%s'不存在", botScript), http.StatusBadRequest)
+			return
+		}
+	}
+	// 全天事件的提醒时间
+	allDayAt := 8 * time.Hour
+	if s := query.Get("time"); s != "" {
+		t, err := time.Parse("15:04", s)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		allDayAt = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+
+	jobs, skipped, err := gcron.ImportICS(reader, allDayAt)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// 之前导入的任务(按事件UID)
+	existing := map[string]*gcron.CronJob{}
+	for _, job := range jsengine.Schedules.List() {
+		if job.UID != "" {
+			existing[job.UID] = job
+		}
+	}
+	imported, updated := 0, 0
+	for _, job := range jobs {
+		script := ""
+		job.BotScript = botScript
+		if botScript == "" {
+			script = fmt.Sprintf("bot.tts(%s, true);", strconv.Quote("提醒："+job.Name))
+		}
+		if old := existing[job.UID]; old != nil && job.UID != "" {
+			job.Filename, job.IsActive = old.Filename, old.IsActive //保留启用状态
+		}
+		isNew := job.Filename == ""
+		if err := jsengine.Schedules.Save(job, script); err != nil {
+			skipped = append(skipped, fmt.Sprintf("%s: %v", job.Name, err))
+			continue
+		}
+		if isNew {
+			imported++
+		} else {
+			updated++
+		}
+	}
+	log.Printf("导入日历: 新建%d个任务, 更新%d个, 跳过%d个\n", imported, updated, len(skipped))
+
+	rest := struct {
+		Imported int      `json:"imported"`
+		Updated  int      `json:"updated"`
+		Skipped  []string `json:"skipped"`
+	}{imported, updated, skipped}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	json.NewEncoder(writer).Encode(rest)
+}
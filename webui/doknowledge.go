@@ -0,0 +1,48 @@
+package webui
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"xiaobot"
+	"xiaobot/knowledge"
+)
+
+// 知识库检索：?query=洗衣机怎么快洗&n=5，返回索引状态、检索到的段落和问AI时附加的段落
+func do_knowledgeSearch(writer http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query().Get("query")
+	n, _ := strconv.Atoi(request.URL.Query().Get("n"))
+	if n <= 0 {
+		n = 5
+	}
+	rest := struct {
+		Stats knowledge.Stats `json:"stats"`
+		Hits  []knowledge.Hit `json:"hits"`
+		Used  []knowledge.Hit `json:"used"` //问AI时附加到提示词的段落
+	}{
+		Stats: knowledge.Default.Stats(),
+	}
+	if query != "" {
+		rest.Hits = knowledge.Default.Search(query, n)
+		rest.Used = xiaobot.SearchKnowledge(query)
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	json.NewEncoder(writer).Encode(rest)
+}
+
+// 立即检查资料目录，重新索引有变化的文件
+func do_knowledgeReindex(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if _, err := knowledge.Default.Refresh(); err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	json.NewEncoder(writer).Encode(knowledge.Default.Stats())
+}
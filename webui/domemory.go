@@ -0,0 +1,81 @@
+package webui
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"ninego/log"
+	"xiaobot"
+)
+
+// 长期记忆：全部记忆和类别
+func do_memoryList(writer http.ResponseWriter, request *http.Request) {
+	rest := struct {
+		Categories []string          `json:"categories"`
+		Items      []*xiaobot.Memory `json:"items"`
+	}{
+		Categories: xiaobot.MemoryCategories,
+		Items:      xiaobot.Memories(),
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	json.NewEncoder(writer).Encode(rest)
+}
+
+// 新增或修改记忆：{"id":"","text":"奶奶有糖尿病","category":"健康"}，id为空时新增，category为空时按内容猜测
+func do_memorySave(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost || request.Body == nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	req := struct {
+		ID       string `json:"id"`
+		Text     string `json:"text"`
+		Category string `json:"category"`
+	}{}
+	if err := json.NewDecoder(request.Body).Decode(&req); err != nil || req.Text == "" {
+		http.Error(writer, "参数有误", http.StatusBadRequest)
+		return
+	}
+	var m *xiaobot.Memory
+	if req.ID == "" {
+		if m, _ = xiaobot.Remember(req.Text, req.Category, "web"); m == nil {
+			http.Error(writer, "记忆内容为空", http.StatusBadRequest)
+			return
+		}
+	} else {
+		var err error
+		if m, err = xiaobot.UpdateMemory(req.ID, req.Text, req.Category); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	log.Println("网页保存记忆:", m.Text)
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	json.NewEncoder(writer).Encode(m)
+}
+
+// 删除记忆：{"id":"xx"}，id为空时删除全部
+func do_memoryDelete(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost || request.Body == nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	req := struct {
+		ID string `json:"id"`
+	}{}
+	if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+		http.Error(writer, "参数有误", http.StatusBadRequest)
+		return
+	}
+	if req.ID == "" {
+		xiaobot.ForgetAllMemories()
+	} else if !xiaobot.ForgetMemory(req.ID) {
+		http.Error(writer, "没有记忆: "+req.ID, http.StatusNotFound)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write([]byte(`{"msg":"","success":1}`))
+}
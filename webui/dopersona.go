@@ -0,0 +1,62 @@
+package webui
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"xiaobot"
+)
+
+// 人设库：全部人设和当前人设(默认时为空)
+func do_personaList(writer http.ResponseWriter, request *http.Request) {
+	rest := struct {
+		Active   string             `json:"active"`
+		Running  bool               `json:"running"` //机器人是否在运行(未运行时不能切换)
+		Personas []*xiaobot.Persona `json:"personas"`
+	}{
+		Running:  bot != nil,
+		Personas: xiaobot.Personas(),
+	}
+	if rest.Personas == nil {
+		rest.Personas = []*xiaobot.Persona{}
+	}
+	if bot != nil {
+		if p := bot.Persona(); p != nil {
+			rest.Active = p.Name
+		}
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	json.NewEncoder(writer).Encode(rest)
+}
+
+// 切换人设：{"name":"英语老师"}，name为空时恢复默认
+func do_personaSwitch(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost || request.Body == nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	req := struct {
+		Name string `json:"name"`
+	}{}
+	if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+		http.Error(writer, "参数有误", http.StatusBadRequest)
+		return
+	}
+	if bot == nil {
+		http.Error(writer, "机器人没有运行", http.StatusServiceUnavailable)
+		return
+	}
+	p, err := bot.SetPersona(req.Name)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusNotFound)
+		return
+	}
+	active := ""
+	if p != nil {
+		active = p.Name
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	json.NewEncoder(writer).Encode(map[string]string{"active": active})
+}
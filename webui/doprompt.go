@@ -0,0 +1,45 @@
+package webui
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"xiaobot/jsengine"
+)
+
+// 预览提示词模板：GET ?prompt=xx&query=xx；POST {"prompt":"","query":""}，prompt为空时用配置中的提示词
+func do_promptPreview(writer http.ResponseWriter, request *http.Request) {
+	var req struct {
+		Prompt string `json:"prompt"`
+		Query  string `json:"query"`
+	}
+	switch request.Method {
+	case http.MethodGet:
+		req.Prompt = request.URL.Query().Get("prompt")
+		req.Query = request.URL.Query().Get("query")
+	case http.MethodPost:
+		if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if req.Prompt == "" {
+		req.Prompt = config.Prompt
+	}
+
+	result := struct {
+		Text  string `json:"text"`
+		Error string `json:"error,omitempty"`
+	}{}
+	text, err := jsengine.RenderPrompt(req.Prompt, map[string]interface{}{"query": req.Query})
+	result.Text = text
+	if err != nil {
+		result.Error = err.Error()
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	json.NewEncoder(writer).Encode(result)
+}
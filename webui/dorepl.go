@@ -0,0 +1,50 @@
+package webui
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"ninego/log"
+	"xiaobot/jsengine"
+
+	"github.com/gorilla/websocket"
+)
+
+// 调试控制台的websocket，使用默认的同源检查(控制台拥有全部脚本权限，不允许其他网站的页面连接)
+var replUpgrader = websocket.Upgrader{}
+
+// 调试控制台：ws://IP:9997/repl/ws，每个连接一个独立的VM
+func do_Repl(writer http.ResponseWriter, request *http.Request) {
+	conn, err := replUpgrader.Upgrade(writer, request, nil)
+	if err != nil {
+		log.Error("调试控制台连接失败:", err)
+		return
+	}
+	defer conn.Close()
+	log.Println("调试控制台已连接:", request.RemoteAddr)
+
+	var mu sync.Mutex
+	send := func(msg *jsengine.ReplMessage) {
+		mu.Lock()
+		defer mu.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		conn.WriteJSON(msg)
+	}
+	repl := jsengine.NewRepl(send)
+	defer repl.Close()
+
+	for {
+		var msg jsengine.ReplMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			return
+		}
+		if msg.Type != "eval" {
+			send(&jsengine.ReplMessage{Type: "error", ID: msg.ID, Text: "不支持的消息类型: " + msg.Type})
+			continue
+		}
+		if !repl.Eval(msg.ID, msg.Code) {
+			return
+		}
+	}
+}
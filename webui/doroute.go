@@ -0,0 +1,55 @@
+package webui
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"xiaobot"
+)
+
+// 测试路由规则：GET ?query=xx&answer=xx(小爱的回答)；
+// POST {"query":"","answer":"","routes":[...]} 可测试未保存的规则，routes为空时用配置中的规则
+func do_routeTest(writer http.ResponseWriter, request *http.Request) {
+	var req struct {
+		Query  string              `json:"query"`
+		Answer string              `json:"answer"`
+		Routes []xiaobot.RouteRule `json:"routes"`
+	}
+	switch request.Method {
+	case http.MethodGet:
+		req.Query = request.URL.Query().Get("query")
+		req.Answer = request.URL.Query().Get("answer")
+	case http.MethodPost:
+		if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if req.Query == "" {
+		http.Error(writer, "没有提问(query)", http.StatusBadRequest)
+		return
+	}
+	if req.Routes == nil {
+		req.Routes = config.Routes
+	}
+
+	result := struct {
+		Query   string               `json:"query"`
+		Answer  string               `json:"answer"`
+		Match   *xiaobot.RouteResult `json:"match"`   //匹配的规则，index为-1时没有匹配
+		Default *xiaobot.RouteResult `json:"default"` //没有规则匹配时原来流程的处理
+		Errors  []string             `json:"errors,omitempty"`
+	}{
+		Query:   req.Query,
+		Answer:  req.Answer,
+		Match:   xiaobot.MatchRoute(req.Routes, req.Query, req.Answer),
+		Default: xiaobot.DefaultRoute(config, req.Query, req.Answer),
+		Errors:  xiaobot.RouteErrors(config, req.Routes),
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	json.NewEncoder(writer).Encode(result)
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"ninego/log"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 	"xiaobot/gcron"
@@ -37,6 +38,9 @@ func do_Savegcron(writer http.ResponseWriter, request *http.Request) {
 
 	//保存
 	isNewfile := (item.Filename == "")
+	if old := jsengine.Schedules.Get(item.Filename); old != nil && item.BotScript == "" {
+		item.BotScript = old.BotScript //页面中不编辑引用的任务脚本
+	}
 	if err := gcron.Save(&item.CronJob); err != nil {
 		http.Error(writer, err.Error(), http.StatusBadRequest)
 		return
@@ -68,7 +72,7 @@ func do_Deletegcron(writer http.ResponseWriter, request *http.Request) {
 	}
 	writer.Header().Set("Content-Type", "application/json")
 	writer.WriteHeader(http.StatusOK)
-	task := jsengine.Schedules.Jobs[filename]
+	task := jsengine.Schedules.Get(filename)
 	if task != nil {
 		fnjob := strings.TrimSuffix(filename, ".json") + ".job"
 		if gcron.IsExist(fnjob) {
@@ -147,7 +151,7 @@ func do_getNextTime(writer http.ResponseWriter, request *http.Request) {
 	filename := request.URL.Query().Get("filename")
 	writer.Header().Set("Content-Type", "application/json")
 	writer.WriteHeader(http.StatusOK)
-	task := jsengine.Schedules.Jobs[filename]
+	task := jsengine.Schedules.Get(filename)
 	rest := struct {
 		Next      time.Time `json:"next"`
 		IsExpired bool      `json:"is_expired"`
@@ -174,7 +178,7 @@ func do_scheduleScript(writer http.ResponseWriter, request *http.Request) {
 	// 设置正确的Content-Type
 	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	writer.WriteHeader(http.StatusOK)
-	task := jsengine.Schedules.Jobs[strings.TrimSuffix(filename, ".job")+".json"]
+	task := jsengine.Schedules.Get(strings.TrimSuffix(filename, ".job") + ".json")
 	if task != nil && task.Schedule != nil {
 		task.Schedule.LoadScript()
 		writer.Write([]byte(task.Schedule.RunScript))
@@ -206,6 +210,10 @@ func do_scheduleTest(writer http.ResponseWriter, request *http.Request) {
 	exec := jsengine.DefaultScriptExecutor{}
 	if err := exec.Execute(jscode, filename); err != nil {
 		log.Error("Run jscript failed:", err)
+		if jsengine.IsTimeout(err) {
+			http.Error(writer, err.Error(), http.StatusGatewayTimeout)
+			return
+		}
 		http.Error(writer, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -230,19 +238,88 @@ func do_scheduleSave(writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 	defer request.Body.Close() // 确保关闭body
-	err = ioutil.WriteFile(filename, body, 0666)
+	err = saveScript(filename, body, scriptAuthor(request))
 	if err != nil {
 		log.Errorf("Write file '%s' failed:%s", filename, err)
 		http.Error(writer, err.Error(), http.StatusBadRequest)
 		return
 	}
 	log.Println("Write file", filename, " - ok")
-	task := jsengine.Schedules.Jobs[strings.TrimSuffix(filename, ".job")+".json"]
-	if task == nil {
-	} else if task.Schedule != nil {
-		task.Schedule.RunScript = string(body)
-	}
 	// 设置正确的Content-Type
 	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	writer.WriteHeader(http.StatusOK)
 }
+
+// 预览任务之后的N次触发时间(或时间段内的全部触发时间)及跳过原因
+// GET  ?filename=xxx&count=10&from=RFC3339&to=RFC3339  预览已保存的任务
+// POST 提交编辑中的任务(TaskItem)，参数同上
+func do_previewgcron(writer http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	var job *gcron.CronJob
+	if request.Method == http.MethodPost {
+		var item TaskItem
+		if err := json.NewDecoder(request.Body).Decode(&item); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		item.StartTime = item.StartTime.Local()
+		item.EndDate = item.EndDate.Local()
+		job = &item.CronJob
+	} else {
+		job = jsengine.Schedules.Get(query.Get("filename"))
+		if job == nil {
+			http.Error(writer, "任务不存在", http.StatusNotFound)
+			return
+		}
+	}
+
+	from := time.Now()
+	var until time.Time
+	if s := query.Get("from"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		from = t.Local()
+	}
+	if s := query.Get("to"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		until = t.Local()
+	}
+	count, _ := strconv.Atoi(query.Get("count"))
+
+	list := job.Preview(from, until, count)
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	if len(list) == 0 {
+		writer.Write([]byte(`[]`))
+		return
+	}
+	json.NewEncoder(writer).Encode(list)
+}
+
+// 按当前状态计算执行条件(JS表达式)，POST body为表达式
+func do_testCondition(writer http.ResponseWriter, request *http.Request) {
+	body, err := io.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	rest := struct {
+		Met   bool   `json:"met"`
+		Error string `json:"error,omitempty"`
+	}{}
+	rest.Met, err = jsengine.DefaultScriptExecutor{}.Evaluate(string(body))
+	if err != nil {
+		rest.Error = err.Error()
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	json.NewEncoder(writer).Encode(rest)
+}
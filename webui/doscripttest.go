@@ -0,0 +1,43 @@
+package webui
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"ninego/log"
+	"xiaobot/jsengine"
+)
+
+// 同一时间只运行一次测试
+var scriptTestMu sync.Mutex
+
+// 程序目录下的测试文件(*.test.js)
+func do_scriptTestList(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	json.NewEncoder(writer).Encode(jsengine.FindScriptTests())
+}
+
+// 运行测试：?file=a.test.js 可指定多个，不指定时运行全部；返回 jsengine.TestReport
+func do_scriptTestRun(writer http.ResponseWriter, request *http.Request) {
+	var files []string
+	for _, file := range request.URL.Query()["file"] {
+		// 只运行程序目录下的测试文件
+		file = filepath.Base(file)
+		if !strings.HasSuffix(file, ".test.js") {
+			http.Error(writer, "不是测试文件: "+file, http.StatusBadRequest)
+			return
+		}
+		files = append(files, file)
+	}
+	scriptTestMu.Lock()
+	report := jsengine.RunScriptTests(files...)
+	scriptTestMu.Unlock()
+	log.Printf("脚本测试: 通过%d，失败%d，跳过%d\n", report.Passed, report.Failed, report.Skipped)
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	json.NewEncoder(writer).Encode(report)
+}
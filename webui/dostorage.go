@@ -0,0 +1,114 @@
+package webui
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"ninego/log"
+	"xiaobot/jsengine"
+)
+
+// 脚本数据(bot.storage/bot.store)：全部命名空间和键值
+func do_storageList(writer http.ResponseWriter, request *http.Request) {
+	st := jsengine.ScriptStorage()
+	rest := struct {
+		Namespaces []string               `json:"namespaces"`
+		Items      []jsengine.StorageItem `json:"items"`
+	}{
+		Namespaces: st.Namespaces(),
+		Items:      st.Items(request.URL.Query().Get("ns")),
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	json.NewEncoder(writer).Encode(rest)
+}
+
+// 修改键值：{"ns":"global","key":"k","value":任意JSON,"ttl":秒(0为不过期)}
+func do_storageSet(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost || request.Body == nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	req := struct {
+		NS    string      `json:"ns"`
+		Key   string      `json:"key"`
+		Value interface{} `json:"value"`
+		TTL   float64     `json:"ttl"`
+	}{}
+	if err := json.NewDecoder(request.Body).Decode(&req); err != nil || req.NS == "" || req.Key == "" {
+		http.Error(writer, "参数有误", http.StatusBadRequest)
+		return
+	}
+	jsengine.ScriptStorage().Namespace(req.NS).SetTTL(req.Key, req.Value, time.Duration(req.TTL*float64(time.Second)))
+	log.Printf("网页修改脚本数据: %s.%s\n", req.NS, req.Key)
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write([]byte(`{"msg":"","success":1}`))
+}
+
+// 删除键值：{"ns":"global","key":"k"}，key为空时清空命名空间
+func do_storageDelete(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost || request.Body == nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	req := struct {
+		NS  string `json:"ns"`
+		Key string `json:"key"`
+	}{}
+	if err := json.NewDecoder(request.Body).Decode(&req); err != nil || req.NS == "" {
+		http.Error(writer, "参数有误", http.StatusBadRequest)
+		return
+	}
+	sd := jsengine.ScriptStorage().Namespace(req.NS)
+	if req.Key == "" {
+		sd.Clear()
+		log.Println("网页清空脚本数据:", req.NS)
+	} else {
+		sd.Delete(req.Key)
+		log.Printf("网页删除脚本数据: %s.%s\n", req.NS, req.Key)
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write([]byte(`{"msg":"","success":1}`))
+}
+
+// 导出全部脚本数据
+func do_storageExport(writer http.ResponseWriter, request *http.Request) {
+	data, err := jsengine.ScriptStorage().Export()
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	filename := fmt.Sprintf("botdata-%s.json", time.Now().Format("20060102-150405"))
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Content-Disposition", "attachment; filename="+filename)
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(data)
+}
+
+// 导入脚本数据(导出的文件或旧版botdata.storage)，?replace=1 时先清空现有数据
+func do_storageImport(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost || request.Body == nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(request.Body, 32<<20))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	count, err := jsengine.ScriptStorage().Import(data, request.URL.Query().Get("replace") == "1")
+	if err != nil {
+		log.Error("导入脚本数据失败:", err)
+		writer.Write([]byte(fmt.Sprintf(`{"msg":%q,"success":0}`, err.Error())))
+		return
+	}
+	log.Printf("导入脚本数据%d项\n", count)
+	writer.Write([]byte(fmt.Sprintf(`{"msg":"导入%d项","success":1}`, count)))
+}
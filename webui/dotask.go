@@ -3,7 +3,6 @@ package webui
 import (
 	"encoding/json"
 	"io"
-	"io/ioutil"
 	"net/http"
 
 	"ninego/log"
@@ -50,6 +49,10 @@ func do_queryTest(writer http.ResponseWriter, request *http.Request) {
 	}
 	if _, err := jsengine.Exec_queryJS(req.Question, req.ScriptContent); err != nil {
 		log.Error("Execute query JavaScript Error:", err)
+		if jsengine.IsTimeout(err) {
+			http.Error(writer, err.Error(), http.StatusGatewayTimeout)
+			return
+		}
 		http.Error(writer, err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -72,14 +75,13 @@ func do_querySave(writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 	defer request.Body.Close() // 确保关闭body
-	err = ioutil.WriteFile("query.bot", body, 0666)
+	err = saveScript("query.bot", body, scriptAuthor(request))
 	if err != nil {
 		log.Error("Write file 'query.bot' failed:", err)
 		http.Error(writer, err.Error(), http.StatusBadRequest)
 		return
 	}
 	log.Println("Write file 'query.bot' - ok")
-	config.QueryJS = string(body)
 	// 设置正确的Content-Type
 	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	writer.WriteHeader(http.StatusOK)
@@ -0,0 +1,137 @@
+package webui
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"ninego/log"
+	"xiaobot/jsengine"
+)
+
+// 保存脚本的作者：请求头 X-Author 或参数 author，没有时为客户端地址
+func scriptAuthor(request *http.Request) string {
+	if author := request.Header.Get("X-Author"); author != "" {
+		return author
+	}
+	if author := request.URL.Query().Get("author"); author != "" {
+		return author
+	}
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
+
+// saveScript 保存网页编辑的脚本(query.bot、任务脚本xxx.bot、定时任务脚本xxx.job)：写入文件、保存历史版本、更新内存中的脚本
+func saveScript(filename string, body []byte, author string) error {
+	previous, _ := os.ReadFile(filename)
+	if err := os.WriteFile(filename, body, 0666); err != nil {
+		return err
+	}
+	script := filepath.Base(filename)
+	if _, err := jsengine.Versions.Save(script, string(body), author, string(previous)); err != nil {
+		log.Error("保存脚本版本出错:", script, err)
+	}
+	switch {
+	case script == "query.bot":
+		config.QueryJS = string(body)
+	case strings.HasSuffix(script, ".bot"):
+		config.TaskJS[strings.TrimSuffix(script, ".bot")] = string(body)
+	case strings.HasSuffix(script, ".job"):
+		task := jsengine.Schedules.Get(strings.TrimSuffix(filename, ".job") + ".json")
+		if task != nil && task.Schedule != nil {
+			task.Schedule.RunScript = string(body)
+		}
+	}
+	return nil
+}
+
+// 脚本的当前内容
+func currentScript(script string) string {
+	content, _ := os.ReadFile(script)
+	return string(content)
+}
+
+func writeVersionError(writer http.ResponseWriter, err error) {
+	if errors.Is(err, os.ErrNotExist) || strings.Contains(err.Error(), "没有版本") {
+		http.Error(writer, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(writer, err.Error(), http.StatusBadRequest)
+}
+
+// 历史版本：?script=query.bot 返回版本列表(从新到旧)；不指定script时返回有历史版本的脚本名
+func do_versionList(writer http.ResponseWriter, request *http.Request) {
+	script := request.URL.Query().Get("script")
+	var result interface{}
+	if script == "" {
+		result = jsengine.Versions.Scripts()
+	} else {
+		list, err := jsengine.Versions.List(script)
+		if err != nil {
+			writeVersionError(writer, err)
+			return
+		}
+		result = list
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	json.NewEncoder(writer).Encode(result)
+}
+
+// 版本内容：?script=query.bot&id=版本号，返回脚本文本
+func do_versionGet(writer http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	ver, err := jsengine.Versions.Get(query.Get("script"), query.Get("id"))
+	if err != nil {
+		writeVersionError(writer, err)
+		return
+	}
+	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write([]byte(ver.Content))
+}
+
+// 两个版本的差异：?script=query.bot&from=版本号&to=版本号，from/to 为空或 current 时为当前文件；返回统一diff格式，相同时为空
+func do_versionDiff(writer http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	script := query.Get("script")
+	diff, err := jsengine.Versions.Diff(script, query.Get("from"), query.Get("to"), currentScript(filepath.Base(script)))
+	if err != nil {
+		writeVersionError(writer, err)
+		return
+	}
+	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write([]byte(diff))
+}
+
+// 恢复版本：POST ?script=query.bot&id=版本号，恢复的内容作为新版本保存
+func do_versionRestore(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	query := request.URL.Query()
+	script, id := query.Get("script"), query.Get("id")
+	ver, err := jsengine.Versions.Get(script, id)
+	if err != nil {
+		writeVersionError(writer, err)
+		return
+	}
+	if err := saveScript(script, []byte(ver.Content), scriptAuthor(request)+" (恢复 "+id+")"); err != nil {
+		log.Error("恢复脚本版本出错:", script, err)
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("恢复脚本 %s 到版本 %s\n", script, id)
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write([]byte(`{"msg":"","success":1}`))
+}
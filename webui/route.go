@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"io"
 	"io/fs"
-	"io/ioutil"
 	"net/http"
 	"ninego/log"
 	"sort"
@@ -31,11 +30,13 @@ func do_Monitoring(writer http.ResponseWriter, request *http.Request) {
 func Router() *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/task/{action}", do_Task)
+	mux.Handle("/app/", jsengine.Apps) //Web应用脚本(scripts/apps/*.js)
 	mux.HandleFunc("/chat", do_Chat)
 	mux.HandleFunc("/monitor", do_Monitoring)
 	mux.HandleFunc("/submit-config", do_setConfig)
 	mux.HandleFunc("/get-config", do_getConfig)
 	mux.HandleFunc("/get-devices", do_getDeviceList)
+	mux.HandleFunc("/prompt/preview", do_promptPreview) //提示词模板预览
 
 	// 创建一个子文件系统，只包含static目录下的内容
 	staticFS, _ := fs.Sub(Assets, "website")
@@ -68,6 +69,76 @@ func Router() *http.ServeMux {
 	})
 	mux.HandleFunc("/query/test", do_queryTest)
 	mux.HandleFunc("/query/save", do_querySave)
+	mux.HandleFunc("/api/route/test", do_routeTest) //路由规则测试
+
+	//脚本调试控制台
+	mux.HandleFunc("/repl", func(w http.ResponseWriter, r *http.Request) {
+		content, _ := Assets.ReadFile("website/repl.html")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(content)
+	})
+	mux.HandleFunc("/repl/ws", do_Repl)
+
+	//脚本数据(bot.storage)管理
+	mux.HandleFunc("/storage", func(w http.ResponseWriter, r *http.Request) {
+		content, _ := Assets.ReadFile("website/storage.html")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(content)
+	})
+	mux.HandleFunc("/storage/list", do_storageList)
+	mux.HandleFunc("/storage/set", do_storageSet)
+	mux.HandleFunc("/storage/delete", do_storageDelete)
+	mux.HandleFunc("/storage/export", do_storageExport)
+	mux.HandleFunc("/storage/import", do_storageImport)
+
+	//长期记忆管理
+	mux.HandleFunc("/memory", func(w http.ResponseWriter, r *http.Request) {
+		content, _ := Assets.ReadFile("website/memory.html")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(content)
+	})
+	mux.HandleFunc("/memory/list", do_memoryList)
+	mux.HandleFunc("/memory/save", do_memorySave)
+	mux.HandleFunc("/memory/delete", do_memoryDelete)
+
+	//人设库
+	mux.HandleFunc("/persona", func(w http.ResponseWriter, r *http.Request) {
+		content, _ := Assets.ReadFile("website/persona.html")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(content)
+	})
+	mux.HandleFunc("/persona/list", do_personaList)
+	mux.HandleFunc("/persona/switch", do_personaSwitch)
+
+	//本地知识库
+	mux.HandleFunc("/knowledge/search", do_knowledgeSearch)
+	mux.HandleFunc("/knowledge/reindex", do_knowledgeReindex)
+
+	//网页保存的脚本的历史版本
+	mux.HandleFunc("/versions", func(w http.ResponseWriter, r *http.Request) {
+		content, _ := Assets.ReadFile("website/versions.html")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(content)
+	})
+	mux.HandleFunc("/versions/list", do_versionList)
+	mux.HandleFunc("/versions/get", do_versionGet)
+	mux.HandleFunc("/versions/diff", do_versionDiff)
+	mux.HandleFunc("/versions/restore", do_versionRestore)
+
+	//脚本测试(*.test.js)
+	mux.HandleFunc("/scripttest", func(w http.ResponseWriter, r *http.Request) {
+		content, _ := Assets.ReadFile("website/scripttest.html")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(content)
+	})
+	mux.HandleFunc("/scripttest/list", do_scriptTestList)
+	mux.HandleFunc("/scripttest/run", do_scriptTestRun)
 
 	//任务内容js编辑
 	mux.HandleFunc("/task", func(w http.ResponseWriter, r *http.Request) {
@@ -122,14 +193,13 @@ func Router() *http.ServeMux {
 			return
 		}
 		defer r.Body.Close() // 确保关闭body
-		err = ioutil.WriteFile(taskName+".bot", body, 0666)
+		err = saveScript(taskName+".bot", body, scriptAuthor(r))
 		if err != nil {
 			log.Error("Write file failed:", taskName, err)
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 		log.Println("Write file", taskName, "- ok")
-		config.TaskJS[taskName] = string(body)
 		// 设置正确的Content-Type
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		w.WriteHeader(http.StatusOK)
@@ -195,7 +265,14 @@ func Router() *http.ServeMux {
 	mux.HandleFunc("/gcron/list", do_gcronList)
 	mux.HandleFunc("/gcron/setactive", do_setgcronActive)
 	mux.HandleFunc("/gcron/getnexttime", do_getNextTime)
+	mux.HandleFunc("/gcron/preview", do_previewgcron)
+	mux.HandleFunc("/gcron/condition", do_testCondition)
+	mux.HandleFunc("/gcron/calendar.ics", do_gcronCalendar)
+	mux.HandleFunc("/gcron/import", do_gcronImport)
 	mux.HandleFunc("/gcron/getlunar", do_getLunar)
+	mux.HandleFunc("/holiday/list", do_holidayList)
+	mux.HandleFunc("/holiday/overrides", do_holidayOverrides)
+	mux.HandleFunc("/holiday/refresh", do_holidayRefresh)
 	mux.HandleFunc("/schedule/script", do_scheduleScript)
 	mux.HandleFunc("/schedule/test", do_scheduleTest)
 	mux.HandleFunc("/schedule/save", do_scheduleSave)
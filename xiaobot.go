@@ -5,13 +5,16 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"ninego/log"
 	"xiaobot/jarvis"
 	"xiaobot/jsengine"
+	"xiaobot/miservice"
 )
 
 const (
@@ -28,9 +31,14 @@ type MiBot struct {
 	records       chan Record
 	LastTimestamp int64 //末次对话时间戳
 
-	HasGPT    bool
-	assistant jarvis.Jarvis
-	Prompt    string //对话前bot人设
+	HasGPT       bool
+	assistant    jarvis.Jarvis
+	profiles     map[string]profileAssistant //llm_profiles 的AI助手
+	profilesMu   sync.Mutex
+	Prompt       string   //对话前bot人设
+	configPrompt string   //配置的提示词(用语音更改提示词时 config.Prompt 会被覆盖)，人设以此为默认
+	persona      *Persona //当前人设(personas)，默认时为nil
+	personaMu    sync.RWMutex
 
 	monitor *StateStore //0=未轮询 >0轮询中 0xFFFF为非监控模式
 	speaker *StateStore //0=未静音 1=静音 -1=已播think 小于-1=正在播thing
@@ -216,9 +224,26 @@ func (mt *MiBot) InitAllData() error {
 
 	//获取bot的方法到map(供JS调用使用)
 	mt.SetVM(jsengine.BotfuncMap)
+	//米家设备规格缓存在数据目录
+	miservice.SpecCacheDir = filepath.Join(GetExecutableDir(), jsengine.DataDir, "miot-spec")
+
+	mt.configPrompt = mt.config.Prompt
+	mt.assistant = newAssistant(mt.config.Bot, mt.config.OpenAIKey, mt.config.OpenAIBackend, mt.config.Proxy, mt.config.Prompt, mt.config.GPTOptions)
+	mt.HasGPT = (mt.config.OpenAIBackend != "")
+
+	return nil
+}
+
+type profileAssistant struct {
+	profile   *LLMProfile
+	persona   *Persona
+	assistant jarvis.Jarvis
+}
 
+// newAssistant 按模型(bot，可为“类型=模型”使用模型适配器)、帐号和提示词创建AI助手
+func newAssistant(bot, key, backend, proxy, prompt string, options map[string]interface{}) *jarvis.GhatGPT {
 	llmtype := "openai"
-	model := mt.config.Bot
+	model := bot
 	if index := strings.Index(model, "="); index != -1 {
 		llmtype = strings.TrimSpace(model[0:index])
 		model = strings.TrimSpace(model[index+1:])
@@ -232,20 +257,58 @@ func (mt *MiBot) InitAllData() error {
 			log.Println("加载模型适配器：", llmtype, model)
 		}
 	}
-	mt.assistant = &jarvis.GhatGPT{
+	assistant := &jarvis.GhatGPT{
 		Model:          model,
-		Key:            mt.config.OpenAIKey,
-		Backend:        mt.config.OpenAIBackend,
-		Proxy:          mt.config.Proxy,
-		Prompt:         mt.config.Prompt,
-		GPTOptions:     mt.config.GPTOptions,
+		Key:            key,
+		Backend:        backend,
+		Proxy:          proxy,
+		Prompt:         prompt,
+		GPTOptions:     options,
 		Adapter:        adapter,
 		HistoryMessage: make([]jarvis.RoleContent, 0),
 	}
-	mt.assistant.SetPrompt(mt.config.Prompt)
-	mt.HasGPT = (mt.config.OpenAIBackend != "")
+	assistant.SetPrompt(prompt)
+	return assistant
+}
 
-	return nil
+// assistantOf 配置 llm_profiles 中的AI助手(连续对话的历史仍记在默认的AI助手)，profile为空或不存在时为默认的AI助手；
+// persona不为nil时用人设的提示词
+func (mt *MiBot) assistantOf(profile string, persona *Persona) jarvis.Jarvis {
+	p, ok := mt.config.LLMProfiles[profile]
+	if profile == "" || !ok || p == nil {
+		return mt.assistant
+	}
+	key := profile
+	if persona != nil {
+		key = profile + "@" + persona.Name
+	}
+	mt.profilesMu.Lock()
+	defer mt.profilesMu.Unlock()
+	if cached, ok := mt.profiles[key]; ok && cached.profile == p && cached.persona == persona {
+		return cached.assistant
+	}
+	or := func(value, def string) string {
+		if value != "" {
+			return value
+		}
+		return def
+	}
+	options := p.GPTOptions
+	if options == nil {
+		options = mt.config.GPTOptions
+	}
+	prompt := or(p.Prompt, mt.config.Prompt)
+	if persona != nil {
+		prompt = persona.systemPrompt(or(p.Prompt, mt.configPrompt))
+	}
+	assistant := newAssistant(or(p.Bot, mt.config.Bot), or(p.Key, mt.config.OpenAIKey), or(p.Backend, mt.config.OpenAIBackend),
+		mt.config.Proxy, prompt, options)
+	if mt.profiles == nil {
+		mt.profiles = make(map[string]profileAssistant)
+	}
+	mt.profiles[key] = profileAssistant{profile: p, persona: persona, assistant: assistant} //配置修改后重新创建
+	log.Println("使用AI配置:", profile)
+	return assistant
 }
 
 func (mt *MiBot) startNewTalk() {
@@ -273,6 +336,11 @@ func (mt *MiBot) Run(monitor int) error {
 	log.Println("✅ 开启服务...")
 
 	mt.monitor.Set(monitor)
+	mt.restorePersona()
+	Skills.start(mt)
+	for _, msg := range RouteErrors(mt.config, mt.config.Routes) {
+		log.Error("路由", msg)
+	}
 	go mt.pollLatestAsk()
 	for {
 		select {
@@ -448,7 +516,7 @@ func (mt *MiBot) SetVM(bot map[string]interface{}) {
 			defer mt.monitor.Set(0)
 			mt.monitor.Set(0xFFFF) // 非监控模式
 		}
-		err := mt.Box.MiTTS(text)
+		err := mt.speak(text)
 		if err != nil {
 			return false
 		}
@@ -477,4 +545,31 @@ func (mt *MiBot) SetVM(bot map[string]interface{}) {
 		return int(i)
 	}
 	bot["wait"] = mt.Box.WaitForTTSFinish
+	bot["dialog"] = mt.startScriptDialog
+	bot["persona"] = func() string {
+		if p := mt.Persona(); p != nil {
+			return p.Name
+		}
+		return ""
+	}
+	bot["setPersona"] = func(name string) error {
+		_, err := mt.SetPersona(name)
+		return err
+	}
+	// require('miot')
+	miot := jsengine.ModulefuncMap["miot"]
+	miot["action"] = mt.Box.MiAction
+	miot["tts"] = mt.Box.MiTTS
+	miot["play"] = mt.Box.MiPlay
+	miot["stop"] = mt.Box.StopSpeaker
+	miot["wakeup"] = mt.Box.WakeUp
+	miot["getVolume"] = mt.Box.MiGetVolume
+	miot["setVolume"] = mt.Box.MiSetVolume
+	mt.Box.setMiotModule(miot)
+	bot["idle"] = func() int {
+		if mt.LastTimestamp == 0 {
+			return -1
+		}
+		return int(time.Now().Unix() - mt.LastTimestamp/1000)
+	}
 }
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"ninego/log"
+	"xiaobot/jsengine"
 	"xiaobot/miservice"
 )
 
@@ -29,6 +30,7 @@ type XiaoMi struct {
 	deviceID string
 	MacAddr  string //音箱MAC地址 （用于ARP欺骗）
 	IP_Addr  string //音箱IP地址（用于Music）
+	Name     string //音箱名称（用于提示词模板）
 
 	account     *miservice.Account
 	minaService *miservice.AIService
@@ -161,6 +163,7 @@ func (mt *XiaoMi) initDataHardware() error {
 		if h.MiotDID == mt.config.MiDID {
 			mt.deviceID = h.DeviceID
 			mt.MacAddr = normalizeMAC(h.Mac)
+			mt.Name = h.Name
 			break
 		}
 	}
@@ -169,6 +172,7 @@ func (mt *XiaoMi) initDataHardware() error {
 			if h.Hardware == mt.config.Hardware {
 				mt.deviceID = h.DeviceID
 				mt.MacAddr = normalizeMAC(h.Mac)
+				mt.Name = h.Name
 				break
 			}
 		}
@@ -176,6 +180,10 @@ func (mt *XiaoMi) initDataHardware() error {
 	if mt.deviceID == "" {
 		return errors.New("we have no hardware: " + mt.config.Hardware + " please use micli mina to check")
 	}
+	if mt.Name == "" {
+		mt.Name = mt.config.Hardware
+	}
+	jsengine.SetPromptVar("device", mt.Name)
 
 	// 查找IP（可选）
 	if mt.IP_Addr == "" {
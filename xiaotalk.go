@@ -33,6 +33,7 @@ type MiTalk struct {
 
 	ChatDontTTS  int                 //0=非chat模式 1=chat不播放 -1=chat要播放
 	StreamWriter http.ResponseWriter //chat模式下的流式输出
+	profile      string              //路由规则指定的AI配置(llm_profiles)
 
 	stopchannel *Channel //用于优雅退出
 }
@@ -98,8 +99,19 @@ func (mt *MiTalk) askJarvis(query, answer string, chatStream bool) (string, stri
 		}
 	}()
 
+	// 路由规则指定的AI配置优先，其次是当前人设的AI配置
+	profile, persona := mt.profile, mt.Bot.Persona()
+	if profile != "" {
+		persona = nil
+	} else if persona != nil {
+		profile = persona.Profile
+	}
+	assistant := mt.Bot.assistantOf(profile, persona)
+	if assistant != mt.Bot.assistant { //连续对话的历史记在默认的AI助手
+		*assistant.GetHistory() = append([]jarvis.RoleContent(nil), *mt.Bot.assistant.GetHistory()...)
+	}
 	if !chatStream {
-		rets, err := mt.Bot.assistant.Ask(query, answer)
+		rets, err := assistant.Ask(query, answer)
 		if cancel {
 			return "", "", nil //errors.New("cancel")
 		}
@@ -108,7 +120,7 @@ func (mt *MiTalk) askJarvis(query, answer string, chatStream bool) (string, stri
 
 	//流式返回
 	strtts := "" //全部已经播放的串
-	stream, err := mt.Bot.assistant.AskStream(query, answer)
+	stream, err := assistant.AskStream(query, answer)
 	if err != nil {
 		return "", strtts, err
 	}
@@ -235,6 +247,9 @@ func (mt *MiTalk) miTTS(message string, waitForFinish bool) error {
 	mt.Bot.activeSpeakerVoice(0)
 	var value string
 	for message != "" {
+		if mt.terminated() { //新的提问打断了播放
+			return nil
+		}
 		if utf8.RuneCountInString(message) > maxttsWord {
 			if i := possentence(message); i > -1 {
 				value = substr(message, 0, i+1)
@@ -248,7 +263,7 @@ func (mt *MiTalk) miTTS(message string, waitForFinish bool) error {
 			message = ""
 		}
 
-		if err := mt.Box.MiTTS(value); err != nil {
+		if err := mt.Bot.speak(value); err != nil {
 			return fmt.Errorf("TTS命令执行失败: %w", err)
 		}
 
@@ -319,7 +334,10 @@ func (mt *MiTalk) handleConversationControl(query string) bool {
 			log.Println("恢复bot人设")
 			mt.changePrompt(mt.Bot.Prompt)
 			mt.InConversation = false
-			*mt.Bot.assistant.GetHistory() = nil
+			history := mt.Bot.assistant.GetHistory()
+			go mt.Bot.extractMemories(*history) //总结要长期记住的事实(memory_auto)
+			*history = nil
+			go jsengine.Hooks.Emit(jsengine.EventConversationEnd, map[string]interface{}{"query": query})
 			return true
 		}
 		if query == WakeupKeyword {
@@ -331,6 +349,7 @@ func (mt *MiTalk) handleConversationControl(query string) bool {
 		mt.Bot.startSpeakerMuteLoop()
 		mt.InConversation = true
 		*mt.Bot.assistant.GetHistory() = make([]jarvis.RoleContent, 0)
+		go jsengine.Hooks.Emit(jsengine.EventConversationStart, map[string]interface{}{"query": query})
 		return false
 	}
 	return false
@@ -389,15 +408,7 @@ func needStop(answer string) bool {
 		return true
 	}
 
-	stopPhrases := []string{
-		"被你问住了", "把我难住了", "被难住了", "我好像还不太知道",
-		"我暂时还回答不上", "我暂时还不支持", "暂不支持",
-		"我还在研究中", "我还在学习中", "要再学习", "要更努力学习",
-		"换个方式再说一遍", "换个话题", "这个话题我不太擅长",
-		"最新小爱音箱APP", "本设备暂不支持该功能", "绑定音乐账号",
-	}
-
-	for _, phrase := range stopPhrases {
+	for _, phrase := range jarvis.UnknownPhrases {
 		if strings.Contains(answer, phrase) {
 			return true
 		}
@@ -424,7 +435,8 @@ func (mt *MiTalk) playThinking() {
 	}
 
 	// []string{"让我先想想", "让我想一下", "让我想一想"}
-	n := len(mt.config.Thinkingwords)
+	words := mt.Bot.thinkingWords()
+	n := len(words)
 	if n == 0 {
 		return
 	}
@@ -433,7 +445,7 @@ func (mt *MiTalk) playThinking() {
 	if i >= n {
 		i = 0
 	}
-	mithinking := mt.config.Thinkingwords[i]
+	mithinking := words[i]
 
 	if mithinking != "" && mt.Bot.loopStopSpeaker() {
 		//mt.wg.Add(1)
@@ -472,6 +484,15 @@ func (mt *MiTalk) handleConversation(record Record, isMuteMode bool) error {
 	if query == "" {
 		return nil
 	}
+	// 多轮对话的回答
+	if mt.handleDialog(query) {
+		return nil
+	}
+	// 事件脚本处理(e.handled=true时不再继续)
+	if ev := jsengine.Hooks.Emit(jsengine.EventQuery, map[string]interface{}{"query": query}); ev.Handled {
+		log.Println("事件脚本已处理:", query)
+		return nil
+	}
 	// 问题脚本处理
 	if mt.config.QueryJS != "" {
 		log.Println("Call query.bot")
@@ -484,10 +505,43 @@ func (mt *MiTalk) handleConversation(record Record, isMuteMode bool) error {
 			}
 		}
 	}
-
 	// 提取初始小爱的回答
 	answer = mt.extractAnswers(&record)
 
+	// 路由规则决定如何回答，没有匹配的规则时由Go技能处理(处理后不再问AI)
+	forceAI := false
+	// 技能在后台处理，出错且没有回答时改由AI回答
+	fallback := func() { mt.answerByAI(record, query, isMuteMode, false) }
+	mute := (isMuteMode || mt.InConversation) && (mt.Bot.HasGPT || mt.ChatDontTTS != -1) //同下面的立即静音
+	if route := mt.route(query, &answer, mute); route != nil {
+		switch route.Action {
+		case RouteXiaoai:
+			log.Println("由小爱回答:", query)
+			mt.Terminate()
+			return nil
+		case RouteScript:
+			mt.runSkill(&scriptSkill{script: route.Rule.Script, answer: answer}, query, 1, fallback)
+			return nil
+		case RouteSkill:
+			if skill := Skills.Get(route.Rule.Skill); skill == nil {
+				log.Error("没有技能:", route.Rule.Skill)
+			} else {
+				mt.runSkill(skill, query, 1, fallback)
+				return nil
+			}
+		case RouteLLM:
+			forceAI, mt.profile = true, route.Rule.Profile
+		}
+	} else if mt.handleSkill(query, fallback) {
+		return nil
+	}
+
+	mt.answerByAI(record, query, isMuteMode, forceAI)
+	return nil
+}
+
+// answerByAI 由AI回答(在后台问AI，新的提问可打断)；forceAI为路由规则指定由AI回答
+func (mt *MiTalk) answerByAI(record Record, query string, isMuteMode, forceAI bool) {
 	// 没有配置AI帐号
 	hasGPT := mt.Bot.HasGPT
 
@@ -496,7 +550,7 @@ func (mt *MiTalk) handleConversation(record Record, isMuteMode bool) error {
 
 	// 根据模式决定是否立即静音
 	firstlyStopped := needStop(answer)
-	if mt.InConversation || isMuteMode || firstlyStopped || mt.Bot.monitor.Status() != 0xFFFF /*监控模式*/ {
+	if forceAI || mt.InConversation || isMuteMode || firstlyStopped || mt.Bot.monitor.Status() != 0xFFFF /*监控模式*/ {
 		if !hasGPT && mt.ChatDontTTS == -1 {
 			// 无AI配置,且chat要发音
 		} else {
@@ -541,7 +595,7 @@ func (mt *MiTalk) handleConversation(record Record, isMuteMode bool) error {
 		}
 
 		// 在非对话模式且没Loop静音下检查是否需要处理
-		if /*!mt.InConversation &&*/ !firstlyStopped && !mt.Bot.loopStopSpeaker() && !mt.needAskJarvis(query) {
+		if /*!mt.InConversation &&*/ !forceAI && !firstlyStopped && !mt.Bot.loopStopSpeaker() && !mt.needAskJarvis(query) {
 			//未被静音(已一定不是对话模式||监控模式),提问词首不匹配+且为有效回答!firstlyStopped(=!needStop)
 			log.Println("不需要问AI:", answer)
 			return
@@ -553,7 +607,7 @@ func (mt *MiTalk) handleConversation(record Record, isMuteMode bool) error {
 		}
 
 		// 非对话且非静音小爱模式下等待小爱完整回答
-		if (!mt.InConversation && !isMuteMode && answer == "") || !hasGPT {
+		if (!forceAI && !mt.InConversation && !isMuteMode && answer == "") || !hasGPT {
 			if err := mt.waitForCompleteAnswer(query, &answer); err != nil {
 				log.Error("等待完整回答失败:", err)
 			}
@@ -581,10 +635,22 @@ func (mt *MiTalk) handleConversation(record Record, isMuteMode bool) error {
 			log.Printf("-AI-的回答: %s\n", message)
 		}
 
+		// 事件脚本可改写未播放的回答，e.handled=true时不再播放
+		ev := jsengine.Hooks.Emit(jsengine.EventAnswer, map[string]interface{}{"query": query, "answer": message, "spoken": aiSpokenText})
+		rewritten := false
+		if text, ok := ev.Data["answer"].(string); ok && text != message {
+			log.Printf("事件脚本改写回答: %s\n", text)
+			message, rewritten = text, true
+		}
+		if ev.Handled {
+			answer = aiSpokenText + message
+			return
+		}
+
 		// 检查回答相似度，避免重复播放
 		fullAiResponse := aiSpokenText + message
-		needAI := (answer != fullAiResponse && levenshtein.RatioForStrings([]rune(answer), []rune(fullAiResponse), levenshtein.DefaultOptions) < 0.5)
-		if mt.InConversation || isMuteMode || firstlyStopped || mt.Bot.monitor.Status() != 0xFFFF /*监控模式*/ ||
+		needAI := rewritten || (answer != fullAiResponse && levenshtein.RatioForStrings([]rune(answer), []rune(fullAiResponse), levenshtein.DefaultOptions) < 0.5)
+		if forceAI || mt.InConversation || isMuteMode || firstlyStopped || mt.Bot.monitor.Status() != 0xFFFF /*监控模式*/ ||
 			needAI {
 			// 播放AI的回答
 			mt.miTTS(message, mt.InConversation /*false*/)
@@ -597,10 +663,11 @@ func (mt *MiTalk) handleConversation(record Record, isMuteMode bool) error {
 			//log.Println("no speaker", mt.InConversation, isMuteMode, firstlyStopped, needAI, mt.Bot.speaker.Status())
 		}
 		answer = fullAiResponse
+		if !mt.InConversation {
+			mt.Bot.collectMemory(query, answer)
+		}
 		return
 	}()
-
-	return nil
 }
 
 var answer string //设为全局可供chat使用